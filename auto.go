@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lon9/waifu2x-go/waifu2x"
+	"image"
+	"os"
+	"path/filepath"
+)
+
+func autoModels(dir, input string) ([]string, error) {
+
+	// Choose the denoising model of the estimated noise level, or the
+	// highest level below it in dir, followed by the scale model.
+
+	b, err := os.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", waifu2x.ErrUnsupportedFormat, input)
+	}
+	level := waifu2x.NoiseLevel(img, b)
+
+	var models []string
+	for l := level; l > 0; l-- {
+		name := filepath.Join(dir, fmt.Sprintf("noise%d_model.json", l))
+		if _, err := os.Stat(name); err == nil {
+			models = append(models, name)
+			break
+		}
+	}
+	scale := filepath.Join(dir, "scale2.0x_model.json")
+	if _, err := os.Stat(scale); err != nil {
+		return nil, err
+	}
+	models = append(models, scale)
+	fmt.Fprintf(os.Stderr, "noise level %d: %v\n", level, models)
+	return models, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func TestAutoModels(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"noise1_model.json", "noise3_model.json", "scale2.0x_model.json"} {
+		writeModel(t, dir, name)
+	}
+	clean := writeImage(t, filepath.Join(dir, "clean.png"), 64, 64)
+
+	// Gray noise of 20 around the middle.
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(128 + 20*rng.NormFloat64())
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	noisy := filepath.Join(dir, "noisy.png")
+	if err := savePNG(&waifu2x.Waifu2x{}, noisy, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for input, want := range map[string][]string{
+		clean: {filepath.Join(dir, "scale2.0x_model.json")},
+		noisy: {filepath.Join(dir, "noise3_model.json"), filepath.Join(dir, "scale2.0x_model.json")},
+	} {
+		models, err := autoModels(dir, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(models, want) {
+			t.Errorf("%s: got %v, want %v", filepath.Base(input), models, want)
+		}
+	}
+
+	opts := &Options{Input: []string{noisy}, Output: filepath.Join(dir, "out.png"), Auto: dir}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if s := readImage(t, opts.Output).Bounds().Size(); s != image.Pt(128, 128) {
+		t.Errorf("got size %v, want (128,128)", s)
+	}
+	if len(opts.ModelName) != 0 {
+		t.Errorf("got models %v in the options, want none", opts.ModelName)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lon9/waifu2x-go/waifu2x"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func isImageFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, f := range waifu2x.SupportedInputFormats() {
+		if ext == f {
+			return true
+		}
+	}
+	return false
+}
+
+func expandInputs(paths []string) ([]string, bool, error) {
+
+	// Replace directories with the images in them. It is a batch when
+	// there are multiple inputs or a directory.
+
+	var inputs []string
+	batch := len(paths) > 1
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.IsDir() {
+			inputs = append(inputs, p)
+			continue
+		}
+		batch = true
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, false, err
+		}
+		var names []string
+		for _, e := range entries {
+			if !e.IsDir() && isImageFile(e.Name()) {
+				names = append(names, filepath.Join(p, e.Name()))
+			}
+		}
+		sort.Strings(names)
+		inputs = append(inputs, names...)
+	}
+	return inputs, batch, nil
+}
+
+// prefetchImages is the number of images decoded ahead in batch.
+const prefetchImages = 2
+
+// decodeInput is replaced in tests to observe the decoding.
+var decodeInput = (*waifu2x.Waifu2x).ReadImage
+
+type decodedImage struct {
+	img     image.Image
+	profile []byte
+	pages   []waifu2x.TIFFPage
+	err     error
+}
+
+func prefetch(ctx context.Context, w *waifu2x.Waifu2x, inputs []string) <-chan decodedImage {
+
+	// Decode the inputs in order, at most prefetchImages ahead of the
+	// image being processed.
+
+	images := make(chan decodedImage, prefetchImages)
+	go func() {
+		defer close(images)
+		for _, input := range inputs {
+			d := readInput(w, input)
+			select {
+			case images <- d:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return images
+}
+
+func readManifest(name string) (inputs, outputs []string, err error) {
+
+	// Each line is an input path, optionally followed by a tab and the
+	// output path. Blank lines and lines starting with # are skipped.
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) > 2 {
+			return nil, nil, fmt.Errorf("%s:%d: more than an input and an output", name, i+1)
+		}
+		inputs = append(inputs, strings.TrimSpace(fields[0]))
+		output := ""
+		if len(fields) == 2 {
+			output = strings.TrimSpace(fields[1])
+		}
+		outputs = append(outputs, output)
+	}
+	return inputs, outputs, nil
+}
+
+// batchOutputs returns the output of each input. Inputs of the same name in
+// different directories would overwrite each other in the output directory,
+// so it fails when two inputs have the same output.
+func batchOutputs(dir string, inputs, outputs []string) ([]string, error) {
+	names := make([]string, len(inputs))
+	seen := make(map[string]string, len(inputs))
+	for i, input := range inputs {
+		output := filepath.Join(dir, filepath.Base(input))
+		if i < len(outputs) && outputs[i] != "" {
+			output = outputs[i]
+		}
+		key := filepath.Clean(output)
+		if prev, ok := seen[key]; ok {
+			return nil, fmt.Errorf("%s and %s are both saved to %s", prev, input, output)
+		}
+		seen[key] = input
+		names[i] = output
+	}
+	return names, nil
+}
+
+func runBatch(ctx context.Context, stages []*waifu2x.Waifu2x, names []string, opts *Options, inputs, outputs []string) error {
+
+	// An empty or missing output is the input name in the output directory.
+	needDir := len(outputs) < len(inputs)
+	for _, o := range outputs {
+		needDir = needDir || o == ""
+	}
+	if needDir && opts.Output == "" {
+		return errors.New("output directory is required in batch")
+	}
+	if opts.Diff != "" || opts.PSNRAgainst != "" || opts.Reference != "" || opts.AssertEquals != "" || opts.DumpPlanes != "" || opts.HTML != "" || opts.Meta != "" || opts.AlphaOut != "" {
+		return errors.New("--diff, --psnr-against, --reference, --assert-equals, --dump-planes, --html, --meta and --alpha-out are only for a single input")
+	}
+	outputs, err := batchOutputs(opts.Output, inputs, outputs)
+	if err != nil {
+		return err
+	}
+	if needDir {
+		if err := os.MkdirAll(opts.Output, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Decode the next images while one is processed, within the limits of
+	// the first stage. The stage is configured again for each image, so the
+	// decoding is given a copy.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limits := *stages[0]
+	images := prefetch(ctx, &limits, inputs)
+
+	// Keep going when an image fails, and report all failures at the end.
+	// When ctx is done, the image being processed is stopped and the saved
+	// images are kept. After an interrupt, the batch stops once the image
+	// being processed is saved.
+	var failed []string
+	done := 0
+	interrupted := false
+	for i, input := range inputs {
+		if ctx.Err() != nil {
+			break
+		}
+		img := <-images
+		output := outputs[i]
+		err := img.err
+		hit := false
+		if err == nil {
+			hit, err = processCached(opts, input, output, func() error {
+				return processDecoded(ctx, stages, names, opts, img, output)
+			})
+		}
+		if err == nil && opts.PreserveTimes {
+			err = preserveTimes(input, output)
+		}
+		if err == nil && opts.Sidecar && !hit {
+			err = writeSidecar(output, input, opts.ModelName, waifu2x.NewModelChain(stages...).Stats())
+		}
+		if err != nil {
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				break
+			}
+			failed = append(failed, fmt.Sprintf("%s: %v", input, err))
+		}
+		done++
+		if stopping(ctx) && done < len(inputs) {
+			interrupted = true
+			break
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d images failed:\n", len(failed), len(inputs))
+		for _, f := range failed {
+			fmt.Fprintln(os.Stderr, "  "+f)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stopped after %d of %d images: %w", done, len(inputs), err)
+	}
+	if interrupted {
+		return fmt.Errorf("stopped after %d of %d images: %w", done, len(inputs), errInterrupted)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d images failed", len(failed), len(inputs))
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func TestRunBatchCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(src, "a.png"), 4, 3)
+	writeImage(t, filepath.Join(src, "c.png"), 5, 2)
+	if err := os.WriteFile(filepath.Join(src, "b.png"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	opts := &Options{
+		Input:     []string{src},
+		Output:    out,
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error with a corrupt file")
+	}
+
+	for name, size := range map[string]image.Point{"a.png": image.Pt(8, 6), "c.png": image.Pt(10, 4)} {
+		if s := readImage(t, filepath.Join(out, name)).Bounds().Size(); s != size {
+			t.Errorf("%s: got size %v, want %v", name, s, size)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "b.png")); !os.IsNotExist(err) {
+		t.Errorf("got %v for the corrupt file, want not exist", err)
+	}
+}
+
+func TestRunBatchSameName(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeImage(t, filepath.Join(dir, sub, "img.png"), 4, 3)
+	}
+	out := filepath.Join(dir, "out")
+	opts := &Options{
+		Input:     []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")},
+		Output:    out,
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error for two inputs saved to the same output")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("got %v for the output directory, want not exist", err)
+	}
+}
+
+func TestRunBatchCancel(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(src, "a.png"), 4, 3)
+	writeImage(t, filepath.Join(src, "b.png"), 400, 400)
+	writeImage(t, filepath.Join(src, "c.png"), 5, 2)
+
+	out := filepath.Join(dir, "out")
+	opts := &Options{
+		Input:     []string{src},
+		Output:    out,
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		TileSize:  16,
+	}
+
+	// Cancel once the first image is saved, while the second is processed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	canceled := make(chan time.Time, 1)
+	go func() {
+		for {
+			if _, err := os.Stat(filepath.Join(out, "a.png")); err == nil {
+				cancel()
+				canceled <- time.Now()
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	err := run(ctx, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if d := time.Since(<-canceled); d > 5*time.Second {
+		t.Errorf("took %v to stop after canceling", d)
+	}
+
+	if s := readImage(t, filepath.Join(out, "a.png")).Bounds().Size(); s != image.Pt(8, 6) {
+		t.Errorf("a.png: got size %v, want (8,6)", s)
+	}
+	for _, name := range []string{"b.png", "c.png"} {
+		if _, err := os.Stat(filepath.Join(out, name)); !os.IsNotExist(err) {
+			t.Errorf("got %v for %s, want not exist", err, name)
+		}
+	}
+}
+
+func TestRunBatchPrefetch(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]image.Point{"a.png": {200, 200}, "b.png": {5, 2}, "c.png": {3, 4}, "d.png": {6, 6}}
+	for name, size := range sizes {
+		writeImage(t, filepath.Join(src, name), size.X, size.Y)
+	}
+	out := filepath.Join(dir, "out")
+
+	// b.png is decoded while a.png, which takes much longer, is processed.
+	var mu sync.Mutex
+	var decodedBeforeA []string
+	decodeInput = func(w *waifu2x.Waifu2x, path string) (image.Image, []byte, error) {
+		if _, err := os.Stat(filepath.Join(out, "a.png")); os.IsNotExist(err) {
+			mu.Lock()
+			decodedBeforeA = append(decodedBeforeA, filepath.Base(path))
+			mu.Unlock()
+		}
+		return w.ReadImage(path)
+	}
+	defer func() { decodeInput = (*waifu2x.Waifu2x).ReadImage }()
+
+	opts := &Options{
+		Input:     []string{src},
+		Output:    out,
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range sizes {
+		if s := readImage(t, filepath.Join(out, name)).Bounds().Size(); s != size.Mul(2) {
+			t.Errorf("%s: got size %v, want %v", name, s, size.Mul(2))
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(decodedBeforeA) < 2 || decodedBeforeA[1] != "b.png" {
+		t.Errorf("decoded %v before a.png was saved, want b.png among them", decodedBeforeA)
+	}
+}
+
+func TestRunBatchSoftDeadline(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b.png"} {
+		writeImage(t, filepath.Join(src, name), 4, 3)
+	}
+	out := filepath.Join(dir, "out")
+
+	// The decoding of b.png takes longer than the deadline, which must not
+	// count against it.
+	decodeInput = func(w *waifu2x.Waifu2x, path string) (image.Image, []byte, error) {
+		if filepath.Base(path) == "b.png" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return w.ReadImage(path)
+	}
+	defer func() { decodeInput = (*waifu2x.Waifu2x).ReadImage }()
+
+	// The model halves the luma, unlike the resize of an approximate
+	// output.
+	model := identityModel()
+	model[0].Weight[0][0][1][1] = 0.5
+	b, err := json.Marshal(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelName := filepath.Join(dir, "scale2.0x_model.json")
+	if err := os.WriteFile(modelName, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		Input:        []string{src},
+		Output:       out,
+		ModelName:    []string{modelName},
+		SoftDeadline: 200 * time.Millisecond,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", "b.png"} {
+		in, res := readImage(t, filepath.Join(src, name)), readImage(t, filepath.Join(out, name))
+		y0 := color.GrayModel.Convert(in.At(3, 2)).(color.Gray).Y
+		y1 := color.GrayModel.Convert(res.At(6, 4)).(color.Gray).Y
+		if int(y1) > int(y0)*3/4 {
+			t.Errorf("%s: got the luma %d for %d, want about half, not an approximate output", name, y1, y0)
+		}
+	}
+}
+
+func TestRunBatchFromFile(t *testing.T) {
+	dir := t.TempDir()
+	a := writeImage(t, filepath.Join(dir, "a.png"), 4, 3)
+	b := writeImage(t, filepath.Join(dir, "b c.png"), 5, 2)
+	c := writeImage(t, filepath.Join(dir, "c.png"), 3, 3)
+	outA := filepath.Join(dir, "x.png")
+	outB := filepath.Join(dir, "y z.png")
+	manifest := filepath.Join(dir, "list.txt")
+	list := "# inputs\n" + a + "\t" + outA + "\n\n" + b + "\t" + outB + "\n" + c + "\n"
+	if err := os.WriteFile(manifest, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{
+		FromFile:  manifest,
+		Output:    filepath.Join(dir, "out"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]image.Point{
+		outA:                                image.Pt(8, 6),
+		outB:                                image.Pt(10, 4),
+		filepath.Join(opts.Output, "c.png"): image.Pt(6, 6),
+	} {
+		if s := readImage(t, name).Bounds().Size(); s != size {
+			t.Errorf("%s: got size %v, want %v", name, s, size)
+		}
+	}
+}
+
+func TestRunBatchInterrupt(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeImage(t, filepath.Join(src, "a.png"), 4, 3)
+	writeImage(t, filepath.Join(src, "b.png"), 5, 2)
+
+	out := filepath.Join(dir, "out")
+	opts := &Options{
+		Input:     []string{src},
+		Output:    out,
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+
+	// The first signal arrives while the first image is processed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	exited := make(chan struct{})
+	ctx = handleInterrupts(ctx, signals, func() { close(exited) })
+	signals <- os.Interrupt
+	for !stopping(ctx) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := run(ctx, opts); !errors.Is(err, errInterrupted) {
+		t.Errorf("got %v, want %v", err, errInterrupted)
+	}
+	if s := readImage(t, filepath.Join(out, "a.png")).Bounds().Size(); s != image.Pt(8, 6) {
+		t.Errorf("a.png: got size %v, want (8,6)", s)
+	}
+	if _, err := os.Stat(filepath.Join(out, "b.png")); !os.IsNotExist(err) {
+		t.Errorf("got %v for b.png, want not exist", err)
+	}
+
+	signals <- os.Interrupt
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Error("didn't exit at the second signal")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+func cacheKey(opts *Options, input, output string) (string, error) {
+
+	// The key is the hash of the input, the models and the options. The
+	// options that don't change the output are cleared, and the paths of
+	// the models are replaced by their contents.
+
+	h := sha256.New()
+	for _, name := range append([]string{input}, opts.ModelName...) {
+		if err := hashFile(h, name); err != nil {
+			return "", err
+		}
+	}
+	for _, name := range []string{opts.ChromaModel, opts.Mask} {
+		if name == "" {
+			continue
+		}
+		if err := hashFile(h, name); err != nil {
+			return "", err
+		}
+	}
+	settings := *opts
+	settings.Input, settings.Output, settings.FromFile, settings.CacheDir = nil, "", "", ""
+	settings.Timeout, settings.MemStats, settings.FileMode, settings.Sidecar = 0, false, 0, false
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	h.Write(b)
+	h.Write([]byte(filepath.Ext(output)))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h io.Writer, name string) error {
+
+	// URLs and the embedded model are hashed by name.
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		_, err = h.Write([]byte(name + "\x00"))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	h.Write([]byte{0})
+	return err
+}
+
+// uncachedFlags returns the flags given in opts whose outputs or checks a hit
+// would skip, since only the output is cached.
+func uncachedFlags(opts *Options) []string {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"--dump-stages", opts.DumpStages != ""},
+		{"--diff", opts.Diff != ""},
+		{"--alpha-out", opts.AlphaOut != ""},
+		{"--mipmaps", opts.Mipmaps},
+		{"--no-chroma-upscale", opts.NoChromaUpscale},
+		{"--html", opts.HTML != ""},
+		{"--dump-planes", opts.DumpPlanes != ""},
+		{"--psnr-against", opts.PSNRAgainst != ""},
+		{"--reference", opts.Reference != ""},
+		{"--assert-equals", opts.AssertEquals != ""},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// processCached runs process unless the output of the input is cached in
+// --cache-dir, and returns whether it was.
+func processCached(opts *Options, input, output string, process func() error) (bool, error) {
+	if opts.CacheDir == "" {
+		return false, process()
+	}
+	hit, store, err := cachedOutput(opts, input, output)
+	if err != nil || hit {
+		return hit, err
+	}
+	if err := process(); err != nil {
+		return false, err
+	}
+	return false, store()
+}
+
+// cachedOutput copies the cached output of the input to output and returns
+// true on a hit. On a miss, it returns the function storing output in the
+// cache after it is written.
+func cachedOutput(opts *Options, input, output string) (bool, func() error, error) {
+	key, err := cacheKey(opts, input, output)
+	if err != nil {
+		return false, nil, err
+	}
+	cached := filepath.Join(opts.CacheDir, key+filepath.Ext(output))
+	if _, err := os.Stat(cached); err == nil {
+		return true, nil, copyFile(cached, output, opts.FileMode.perm())
+	}
+	return false, func() error {
+		if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+			return err
+		}
+		tmp := cached + ".tmp"
+		if err := copyFile(output, tmp, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, cached)
+	}, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	// The umask and an existing file don't change the permission.
+	if err := out.Chmod(perm); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
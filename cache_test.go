@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:     []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		CacheDir:  filepath.Join(dir, "cache"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(opts.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d cached files, want 1", len(entries))
+	}
+
+	// Replace the cached output, so that a hit is told from processing.
+	cached := filepath.Join(opts.CacheDir, entries[0].Name())
+	writeImage(t, cached, 3, 3)
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if s := readImage(t, opts.Output).Bounds().Size(); s != image.Pt(3, 3) {
+		t.Errorf("got size %v, want the cached (3,3)", s)
+	}
+
+	// Other options miss.
+	opts.TargetWidth = 20
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if s := readImage(t, opts.Output).Bounds().Size(); s != image.Pt(20, 16) {
+		t.Errorf("got size %v, want (20,16)", s)
+	}
+}
+
+func TestRunCacheDirUncached(t *testing.T) {
+	dir := t.TempDir()
+	ref := writeImage(t, filepath.Join(dir, "ref.png"), 10, 8)
+	for _, opts := range []*Options{
+		{AssertEquals: ref},
+		{PSNRAgainst: ref},
+		{Diff: filepath.Join(dir, "diff.png")},
+		{Mipmaps: true},
+		{NoChromaUpscale: true},
+	} {
+		opts.Input = []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)}
+		opts.Output = filepath.Join(dir, "out.png")
+		opts.ModelName = []string{writeModel(t, dir, "scale2.0x_model.json")}
+		opts.CacheDir = filepath.Join(dir, "cache")
+		if err := run(context.Background(), opts); err == nil {
+			t.Errorf("got no error for %v with --cache-dir", uncachedFlags(opts))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cache")); !os.IsNotExist(err) {
+		t.Errorf("got a cache directory, want none: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// GlobalOptions is option shared by the commands.
+type GlobalOptions struct {
+	CPU int `short:"c" long:"cpu" description:"The number of CPUs used to calcurate"`
+}
+
+type upscaleCommand struct {
+	Options
+	ctx context.Context
+}
+
+func (c *upscaleCommand) Usage() string {
+	return "-i[--input] <input-image-path> -o[--output] <output-image-path> [-m[--model] <model-path>]"
+}
+
+func (c *upscaleCommand) Execute(args []string) error {
+	return run(c.ctx, &c.Options)
+}
+
+type convertCommand struct {
+	ConvertOptions
+}
+
+func (c *convertCommand) Execute(args []string) error {
+	return convert(&c.ConvertOptions)
+}
+
+type inspectCommand struct {
+	InspectOptions
+	out io.Writer
+}
+
+func (c *inspectCommand) Execute(args []string) error {
+	return runInspect(&c.InspectOptions, c.out)
+}
+
+type selftestCommand struct {
+	out io.Writer
+}
+
+func (c *selftestCommand) Execute(args []string) error {
+	if !selfTest(c.out) {
+		return errors.New("self-test failed")
+	}
+	return nil
+}
+
+type envCommand struct {
+	out io.Writer
+}
+
+func (c *envCommand) Execute(args []string) error {
+	printEnv(c.out)
+	return nil
+}
+
+// newParser returns the parser of the commands, writing the output of
+// inspect, selftest and env to out.
+func newParser(ctx context.Context, out io.Writer) *flags.Parser {
+	global := &GlobalOptions{}
+	parser := flags.NewParser(global, flags.Default)
+	parser.Name = "waifu2x-go"
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		setCPU(global.CPU)
+		return cmd.Execute(args)
+	}
+	parser.AddCommand("upscale", "Upscale or denoise images with the models",
+		"Apply the models to the images. This is the default command.", &upscaleCommand{ctx: ctx})
+	parser.AddCommand("convert", "Convert an image to another format",
+		"Save the image in the format of the extension of the output, keeping the pages of TIFF images.", &convertCommand{})
+	parser.AddCommand("inspect", "Print the layers of a model",
+		"Print the kernel size, the planes, the biases, the parameters and the MACs of each layer of the model.", &inspectCommand{out: out})
+	parser.AddCommand("selftest", "Process a generated image with a built-in model",
+		"Process a generated image with a tiny built-in model and print PASS or FAIL.", &selftestCommand{out: out})
+	parser.AddCommand("env", "Print the CPUs, the Go runtime and the memory",
+		"Print the number of CPUs, GOMAXPROCS, the Go version and the available memory, for reports of performance problems.", &envCommand{out: out})
+	return parser
+}
+
+// parseArgs parses the arguments and runs the command. The upscale command is
+// the default, so the flags of upscale can be given without a command.
+func parseArgs(parser *flags.Parser, args []string) error {
+	if !hasCommand(parser, args) {
+		args = append([]string{"upscale"}, args...)
+	}
+	_, err := parser.ParseArgs(args)
+	return err
+}
+
+// hasCommand tells whether the first argument that is neither a flag nor the
+// value of a flag is a command, which is how go-flags finds the command. The
+// flags are the global ones and those of upscale, which may be given without
+// the command.
+func hasCommand(parser *flags.Parser, args []string) bool {
+	upscale := parser.Find("upscale")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-h" || arg == "--help":
+			return true
+		case arg == "--":
+			return false
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			return parser.Find(arg) != nil
+		}
+		if takesValue(parser.Command, arg) || takesValue(upscale, arg) {
+			i++
+		}
+	}
+	return false
+}
+
+// takesValue tells whether the flag of the command is given its value in the
+// next argument, rather than after = or attached to a short flag.
+func takesValue(cmd *flags.Command, arg string) bool {
+	var opt *flags.Option
+	switch {
+	case strings.HasPrefix(arg, "--"):
+		if strings.Contains(arg, "=") {
+			return false
+		}
+		opt = cmd.FindOptionByLongName(arg[2:])
+	case len(arg) == 2:
+		opt = cmd.FindOptionByShortName(rune(arg[1]))
+	}
+	if opt == nil || opt.OptionalArgument {
+		return false
+	}
+	t := opt.Field().Type
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() != reflect.Bool
+}
+
+func setCPU(numCPU int) {
+	cpus := runtime.NumCPU()
+	if numCPU != 0 {
+		if numCPU > cpus {
+			runtime.GOMAXPROCS(cpus)
+		} else {
+			runtime.GOMAXPROCS(numCPU)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// parse parses the arguments, returning the command instead of running it.
+func parse(t *testing.T, args ...string) flags.Commander {
+	t.Helper()
+	parser := newParser(context.Background(), &bytes.Buffer{})
+	parser.Options = flags.None
+	var got flags.Commander
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		got = cmd
+		return nil
+	}
+	if err := parseArgs(parser, args); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return got
+}
+
+func TestParseUpscale(t *testing.T) {
+	for _, args := range [][]string{
+		{"upscale", "-i", "a.png", "-i", "b.png", "-o", "out", "-m", "m.json", "--tile-size", "64"},
+
+		// upscale is the default command.
+		{"-i", "a.png", "-i", "b.png", "-o", "out", "-m", "m.json", "--tile-size", "64"},
+
+		// The values of the flags aren't taken for commands.
+		{"-c", "1", "-i", "a.png", "--input", "b.png", "--half", "-o", "out", "-m", "m.json", "--tile-size=64"},
+	} {
+		cmd, ok := parse(t, args...).(*upscaleCommand)
+		if !ok {
+			t.Fatalf("%v: got %T, want upscale", args, cmd)
+		}
+		if want := []string{"a.png", "b.png"}; !reflect.DeepEqual(cmd.Input, want) {
+			t.Errorf("%v: got inputs %v, want %v", args, cmd.Input, want)
+		}
+		if cmd.Output != "out" || !reflect.DeepEqual(cmd.ModelName, []string{"m.json"}) || cmd.TileSize != 64 {
+			t.Errorf("%v: got output %q, models %v and tile size %d", args, cmd.Output, cmd.ModelName, cmd.TileSize)
+		}
+		if cmd.Padding != "edge" || cmd.DownloadRetries != 3 {
+			t.Errorf("%v: got padding %q and %d download retries, want the defaults", args, cmd.Padding, cmd.DownloadRetries)
+		}
+	}
+}
+
+func TestParseConvert(t *testing.T) {
+	cmd, ok := parse(t, "convert", "-i", "in.tif", "-o", "out.png").(*convertCommand)
+	if !ok {
+		t.Fatalf("got %T, want convert", cmd)
+	}
+	if cmd.Input != "in.tif" || cmd.Output != "out.png" {
+		t.Errorf("got %q and %q", cmd.Input, cmd.Output)
+	}
+
+	parser := newParser(context.Background(), &bytes.Buffer{})
+	parser.Options = flags.None
+	if err := parseArgs(parser, []string{"convert", "-i", "in.tif"}); err == nil {
+		t.Error("got no error without the output")
+	}
+}
+
+func TestParseInspect(t *testing.T) {
+	cmd, ok := parse(t, "inspect", "-m", "m.json", "--width", "10").(*inspectCommand)
+	if !ok {
+		t.Fatalf("got %T, want inspect", cmd)
+	}
+	if cmd.ModelName != "m.json" || cmd.Width != 10 || cmd.Height != 256 {
+		t.Errorf("got model %q of %dx%d", cmd.ModelName, cmd.Width, cmd.Height)
+	}
+}
+
+func TestParseSelfTest(t *testing.T) {
+	if cmd, ok := parse(t, "selftest").(*selftestCommand); !ok {
+		t.Fatalf("got %T, want selftest", cmd)
+	}
+}
+
+func TestParseCommandNameAsValue(t *testing.T) {
+	for _, args := range [][]string{
+		{"-i", "env", "-o", "inspect"},
+		{"--input", "selftest", "--output=env"},
+		{"-c", "2", "-i", "convert", "-o", "out.png"},
+	} {
+		if cmd, ok := parse(t, args...).(*upscaleCommand); !ok {
+			t.Errorf("%v: got %T, want upscale", args, cmd)
+		}
+	}
+	for _, args := range [][]string{
+		{"-c", "2", "env"},
+		{"--cpu=2", "env"},
+	} {
+		if cmd, ok := parse(t, args...).(*envCommand); !ok {
+			t.Errorf("%v: got %T, want env", args, cmd)
+		}
+	}
+}
+
+func TestParseGlobalOptions(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	// The shared options are accepted before and after the command.
+	for _, args := range [][]string{
+		{"-c", "1", "selftest"},
+		{"selftest", "-c", "1"},
+	} {
+		runtime.GOMAXPROCS(runtime.NumCPU())
+		var out bytes.Buffer
+		if err := parseArgs(newParser(context.Background(), &out), args); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if n := runtime.GOMAXPROCS(0); n != 1 {
+			t.Errorf("%v: got GOMAXPROCS %d, want 1", args, n)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+// ConvertOptions is option of the convert command.
+type ConvertOptions struct {
+	Input  string `short:"i" long:"input" description:"Input image file path" required:"true"`
+	Output string `short:"o" long:"output" description:"Output image file path, of PNG, JPEG or TIFF" required:"true"`
+}
+
+func convert(opts *ConvertOptions) error {
+	// Converting has no limits.
+	w := &waifu2x.Waifu2x{}
+	d := readInput(w, opts.Input)
+	if d.err != nil {
+		return d.err
+	}
+	if d.pages != nil {
+		if !isTIFF(opts.Output) {
+			return fmt.Errorf("%w: %s: the output of a multi-page TIFF image must be a TIFF image", waifu2x.ErrUnsupportedFormat, opts.Output)
+		}
+		return savePages(w, opts.Output, d.pages)
+	}
+	return saveImage(w, opts.Output, d.img)
+}
+
+// saveImage saves the image in the format of the extension of the name, like
+// the results of w.
+func saveImage(w *waifu2x.Waifu2x, name string, img image.Image) error {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".png":
+		return savePNG(w, name, img)
+	case ".jpg", ".jpeg":
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpeg.DefaultQuality}); err != nil {
+			return err
+		}
+		return w.WriteFile(name, buf.Bytes())
+	case ".tif", ".tiff":
+		return savePages(w, name, []waifu2x.TIFFPage{{Image: img}})
+	default:
+		return fmt.Errorf("%w: %s", waifu2x.ErrUnsupportedFormat, ext)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	in := writeImage(t, filepath.Join(dir, "in.png"), 5, 4)
+	opts := &ConvertOptions{Input: in, Output: filepath.Join(dir, "out.tif")}
+	if err := convert(opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pages, err := waifu2x.DecodeTIFF(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	max, _, err := waifu2x.Compare(pages[0].Image, readImage(t, in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 || max != 0 {
+		t.Errorf("got %d pages differing by %d, want the same image", len(pages), max)
+	}
+
+	// Back to PNG through the command.
+	back := filepath.Join(dir, "back.png")
+	if err := parseArgs(newParser(context.Background(), os.Stdout), []string{"convert", "-i", opts.Output, "-o", back}); err != nil {
+		t.Fatal(err)
+	}
+	if max, _, err = waifu2x.Compare(readImage(t, back), readImage(t, in)); err != nil || max != 0 {
+		t.Errorf("got a difference of %d (%v), want the same image", max, err)
+	}
+}
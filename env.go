@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// meminfo is the file availableMemory reads. Tests replace it.
+var meminfo = "/proc/meminfo"
+
+// availableMemory returns the memory available to start new processes
+// without swapping, from MemAvailable of /proc/meminfo. It fails on the
+// systems without the file.
+func availableMemory() (uint64, error) {
+	f, err := os.Open(meminfo)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kib, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", meminfo, err)
+		}
+		return kib << 10, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s: no MemAvailable", meminfo)
+}
+
+// printEnv prints the CPUs, the Go runtime and the memory, for reports of
+// performance problems.
+func printEnv(out io.Writer) {
+	fmt.Fprintf(out, "cpus: %d\n", runtime.NumCPU())
+	fmt.Fprintf(out, "gomaxprocs: %d\n", runtime.GOMAXPROCS(0))
+	fmt.Fprintf(out, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if mem, err := availableMemory(); err == nil {
+		const mib = 1 << 20
+		fmt.Fprintf(out, "available memory: %.1f MiB\n", float64(mem)/mib)
+	} else {
+		fmt.Fprintf(out, "available memory: unknown (%v)\n", err)
+	}
+}
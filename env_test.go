@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	defer func(name string) { meminfo = name }(meminfo)
+	meminfo = filepath.Join(t.TempDir(), "meminfo")
+	if err := os.WriteFile(meminfo, []byte("MemTotal:       16384000 kB\nMemAvailable:    2097152 kB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := parseArgs(newParser(context.Background(), &out), []string{"env"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("cpus: %d\n", runtime.NumCPU()),
+		fmt.Sprintf("gomaxprocs: %d\n", runtime.GOMAXPROCS(0)),
+		runtime.Version(),
+		"available memory: 2048.0 MiB\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("got %q, want %q in it", out.String(), want)
+		}
+	}
+
+	// The memory is unknown without the file.
+	meminfo = filepath.Join(t.TempDir(), "missing")
+	out.Reset()
+	printEnv(&out)
+	if !strings.Contains(out.String(), "available memory: unknown") {
+		t.Errorf("got %q, want the memory unknown", out.String())
+	}
+}
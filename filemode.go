@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// fileMode is the value of --file-mode, an octal permission like 0640.
+type fileMode os.FileMode
+
+// UnmarshalFlag parses the octal permission.
+func (m *fileMode) UnmarshalFlag(value string) error {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || n == 0 || n > 0777 {
+		return fmt.Errorf("invalid file mode %q, want an octal permission like 0640", value)
+	}
+	*m = fileMode(n)
+	return nil
+}
+
+// perm returns the permission of the outputs, 0644 by default.
+func (m fileMode) perm() os.FileMode {
+	if m == 0 {
+		return 0644
+	}
+	return os.FileMode(m)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestFileModeFlag(t *testing.T) {
+	for value, want := range map[string]fileMode{"0640": 0640, "600": 0600, "0755": 0755} {
+		var m fileMode
+		if err := m.UnmarshalFlag(value); err != nil || m != want {
+			t.Errorf("%q: got %o (%v), want %o", value, m, err, want)
+		}
+	}
+	for _, value := range []string{"", "0", "0648", "1777", "rw-r-----"} {
+		var m fileMode
+		if err := m.UnmarshalFlag(value); err == nil {
+			t.Errorf("%q: got %o, want an error", value, m)
+		}
+	}
+
+	parser := newParser(context.Background(), &bytes.Buffer{})
+	parser.Options = flags.None
+	if err := parseArgs(parser, []string{"-i", "in.png", "--file-mode", "9"}); err == nil {
+		t.Error("got no error parsing a file mode that isn't octal")
+	}
+}
+
+func TestRunFileMode(t *testing.T) {
+	dir := t.TempDir()
+	cmd := parse(t, "-i", writeImage(t, filepath.Join(dir, "in.png"), 4, 3), "-o", filepath.Join(dir, "out.png"),
+		"-m", writeModel(t, dir, "scale2.0x_model.json"), "--file-mode", "0640",
+		"--diff", filepath.Join(dir, "diff.png"), "--alpha-out", filepath.Join(dir, "alpha.png"),
+		"--dump-stages", filepath.Join(dir, "stages"), "--html", filepath.Join(dir, "cmp.html")).(*upscaleCommand)
+	if err := run(context.Background(), &cmd.Options); err != nil {
+		t.Fatal(err)
+	}
+
+	// The side outputs take the mode too.
+	for _, name := range []string{cmd.Output, cmd.Diff, cmd.AlphaOut, stagePath(cmd.DumpStages, 0, "scale2.0x_model"), cmd.HTML} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != 0640 {
+			t.Errorf("%s: got %o, want 640", filepath.Base(name), got)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 module github.com/lon9/waifu2x-go
 
-go 1.13
+go 1.20
 
 require (
 	github.com/jessevdk/go-flags v1.4.0
+	github.com/klauspost/compress v1.17.4
 	github.com/lon9/mat v1.1.2
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	golang.org/x/net v0.17.0
 )
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+// InspectOptions is option of the inspect command.
+type InspectOptions struct {
+	ModelName string `short:"m" long:"model" description:"Path or URL of model" required:"true"`
+	Width     int    `long:"width" description:"Width of the image given to the model to count the MACs" default:"256"`
+	Height    int    `long:"height" description:"Height of the image given to the model to count the MACs" default:"256"`
+}
+
+func runInspect(opts *InspectOptions, out io.Writer) error {
+
+	// Loading validates the model.
+	w, err := waifu2x.NewWaifu2x(opts.ModelName, "")
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "layer\tkernel\tinput\toutput\tbias\tparams\tMACs")
+	params, macs := 0, int64(0)
+	layers := w.Layers(opts.Width, opts.Height)
+	for i, l := range layers {
+		fmt.Fprintf(tw, "%d\t%dx%d\t%d\t%d\t%d\t%d\t%d\n", i+1, l.KW, l.KH, l.NInputPlane, l.NOutputPlane, l.Biases, l.Params, l.MACs)
+		params += l.Params
+		macs += l.MACs
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%d layers, %d params, %d MACs for %dx%d\n", len(layers), params, macs, opts.Width, opts.Height)
+	return err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunInspect(t *testing.T) {
+	model := writeModel(t, t.TempDir(), "scale2.0x_model.json")
+	var out bytes.Buffer
+	parser := newParser(context.Background(), &out)
+	if err := parseArgs(parser, []string{"inspect", "-m", model, "--width", "10", "--height", "6"}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	// A header, a line for the identity layer and the totals.
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), out.String())
+	}
+	if got := strings.Fields(lines[1]); strings.Join(got, " ") != "1 3x3 1 1 1 10 540" {
+		t.Errorf("got layer %q", lines[1])
+	}
+	if want := "1 layers, 10 params, 540 MACs for 10x6"; lines[2] != want {
+		t.Errorf("got %q, want %q", lines[2], want)
+	}
+}
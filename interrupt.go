@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errInterrupted is the error of a batch stopped by the first interrupt.
+var errInterrupted = errors.New("interrupted")
+
+type stopKey struct{}
+
+// handleInterrupts returns ctx with a stop that the first signal triggers,
+// after which a batch stops once the image being processed is saved. The
+// second signal calls exit.
+func handleInterrupts(ctx context.Context, signals <-chan os.Signal, exit func()) context.Context {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "interrupted, stopping after the current image, interrupt again to exit now")
+		close(stop)
+		select {
+		case <-signals:
+			exit()
+		case <-ctx.Done():
+		}
+	}()
+	return context.WithValue(ctx, stopKey{}, stop)
+}
+
+// stopping tells whether the first interrupt was handled.
+func stopping(ctx context.Context) bool {
+	stop, _ := ctx.Value(stopKey{}).(chan struct{})
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
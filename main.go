@@ -1,45 +1,538 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/jessevdk/go-flags"
 	"github.com/lon9/waifu2x-go/waifu2x"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
 	"os"
-	"runtime"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func main() {
 
-	opts := &Options{}
-	parser := flags.NewParser(opts, flags.Default)
-	parser.Name = "waifu2x-go"
-	parser.Usage = "-i[--input] <input-image-path> -o[--output] <output-image-path> -m[--model] <model-path> -c[--cpu] <the-number-of-cpus>"
-	_, err := parser.Parse()
-	if err != nil {
+	// Stop after the image being processed at SIGINT, and exit at the
+	// second.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+	ctx := handleInterrupts(context.Background(), signals, func() { os.Exit(130) })
+	if err := parseArgs(newParser(ctx, os.Stdout), os.Args[1:]); err != nil {
+
+		// The parser prints its own errors.
+		if _, ok := err.(*flags.Error); !ok {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
+}
+
+// modelEnv is the environment variable of the model used without -m, before
+// the embedded one.
+const modelEnv = "WAIFU2X_MODEL"
+
+func run(ctx context.Context, opts *Options) error {
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	if opts.SoftDeadline > 0 && opts.CacheDir != "" {
+		return errors.New("--soft-deadline can't be combined with --cache-dir, the approximate outputs would be cached")
+	}
+	if opts.DumpStages != "" {
+		if err := os.MkdirAll(opts.DumpStages, 0755); err != nil {
+			return err
+		}
+	}
+	if opts.TmpDir != "" {
+		if err := checkWritable(opts.TmpDir); err != nil {
+			return fmt.Errorf("--tmp-dir: %w", err)
+		}
+	}
+
+	inputs, batch, err := expandInputs(opts.Input)
+	if err != nil {
+		return err
+	}
+	var outputs []string
+	if opts.FromFile != "" {
+		listed, listedOutputs, err := readManifest(opts.FromFile)
+		if err != nil {
+			return err
+		}
+
+		// The inputs of -i take the default outputs.
+		outputs = append(make([]string, len(inputs)), listedOutputs...)
+		inputs = append(inputs, listed...)
+		batch = true
+	}
+	if len(inputs) == 0 {
+		return errors.New("no input, give -i or --from-file")
+	}
+	if opts.SplitOutput > 1 && (opts.CacheDir != "" || opts.PreserveTimes || opts.Sidecar) {
+		return errors.New("--split-output can't be combined with --cache-dir, --preserve-times or --sidecar")
+	}
+	if flags := uncachedFlags(opts); opts.CacheDir != "" && len(flags) > 0 {
+		return fmt.Errorf("--cache-dir can't be combined with %s, a hit only copies the output", strings.Join(flags, ", "))
+	}
+	if opts.Passes < 0 || (opts.Passes > 0 && (opts.TargetWidth > 0 || opts.TargetHeight > 0)) {
+		return errors.New("--passes must be positive and can't be combined with --target-width or --target-height, which pick the passes")
+	}
+	if opts.Auto != "" {
+		if batch || len(opts.ModelName) > 0 {
+			return errors.New("--auto is only for a single input without -m")
+		}
+		models, err := autoModels(opts.Auto, inputs[0])
+		if err != nil {
+			return err
+		}
+		auto := *opts
+		auto.ModelName = models
+		opts = &auto
+	} else if env := os.Getenv(modelEnv); env != "" && len(opts.ModelName) == 0 {
+		defaults := *opts
+		defaults.ModelName = []string{env}
+		opts = &defaults
+	}
 
-	iptImageName := opts.Input
-	optImageName := opts.Output
-	if optImageName == "" {
-		optImageName = "dst.png"
+	if opts.Mask != "" {
+		mask, err := loadImage(opts.Mask)
+		if err != nil {
+			return fmt.Errorf("--mask: %w", err)
+		}
+		masked := *opts
+		masked.mask = mask
+		opts = &masked
+	}
+
+	stages, names, err := loadStages(opts)
+	if err != nil {
+		return err
 	}
-	modelName := opts.ModelName
-	numCPU := opts.CPU
-	cpus := runtime.NumCPU()
-	if numCPU != 0 {
-		if numCPU > cpus {
-			runtime.GOMAXPROCS(cpus)
-		} else {
-			runtime.GOMAXPROCS(numCPU)
+	if !batch {
+		optImageName := opts.Output
+		if optImageName == "" {
+			optImageName = "dst.png"
 		}
+		return process(ctx, stages, names, opts, inputs[0], optImageName)
 	}
+	return runBatch(ctx, stages, names, opts, inputs, outputs)
+}
 
-	w, err := waifu2x.NewWaifu2x(modelName, iptImageName)
+func process(ctx context.Context, stages []*waifu2x.Waifu2x, names []string, opts *Options, iptImageName, optImageName string) error {
+	hit, err := processCached(opts, iptImageName, optImageName, func() error {
+		return processDecoded(ctx, stages, names, opts, readInput(stages[0], iptImageName), optImageName)
+	})
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if opts.PreserveTimes {
+		if err := preserveTimes(iptImageName, optImageName); err != nil {
+			return err
+		}
+	}
+	if hit {
+		return nil
+	}
+	if opts.Sidecar {
+		if err := writeSidecar(optImageName, iptImageName, opts.ModelName, waifu2x.NewModelChain(stages...).Stats()); err != nil {
+			return err
+		}
+	}
+	if opts.Meta != "" {
+		return writeMeta(opts.Meta, iptImageName, opts.ModelName, waifu2x.NewModelChain(stages...).Stats())
+	}
+	return nil
+}
+
+func processDecoded(ctx context.Context, stages []*waifu2x.Waifu2x, names []string, opts *Options, d decodedImage, optImageName string) error {
+	if d.err != nil {
+		return d.err
 	}
-	w.Exec()
-	if err = w.SaveImage(optImageName); err != nil {
-		panic(err)
+	if opts.SoftDeadline > 0 {
+		// Each input of a batch has the whole duration.
+		timed := *opts
+		timed.deadline = time.Now().Add(opts.SoftDeadline)
+		opts = &timed
+	}
+	if d.pages != nil {
+		return processPages(ctx, stages, opts, d.pages, optImageName)
+	}
+	return processImage(ctx, stages, names, opts, d.img, d.profile, optImageName)
+}
+
+func processImage(ctx context.Context, stages []*waifu2x.Waifu2x, names []string, opts *Options, img image.Image, profile []byte, optImageName string) error {
+	if err := execStages(ctx, stages, opts, img, profile); err != nil {
+		return err
+	}
+	if opts.DumpStages != "" {
+		for i, w := range stages {
+			if err := savePNG(w, stagePath(opts.DumpStages, i, names[i]), w.Result()); err != nil {
+				return err
+			}
+		}
+	}
+	w := stages[len(stages)-1]
+
+	if opts.SplitOutput > 1 {
+		if _, err := w.SaveStrips(optImageName, opts.SplitOutput); err != nil {
+			return err
+		}
+	} else if err := w.SaveImage(optImageName); err != nil {
+		return err
+	}
+	if opts.NoChromaUpscale {
+		if err := saveSourceChroma(stages[0], optImageName); err != nil {
+			return err
+		}
+	}
+	if opts.Mipmaps {
+		if _, err := w.SaveMipmaps(optImageName); err != nil {
+			return err
+		}
+	}
+	if opts.Diff != "" {
+		diff, err := w.Diff()
+		if err != nil {
+			return err
+		}
+		if err = savePNG(w, opts.Diff, diff); err != nil {
+			return err
+		}
+	}
+	if opts.AlphaOut != "" {
+		if err := savePNG(w, opts.AlphaOut, w.Alpha()); err != nil {
+			return err
+		}
+	}
+	if opts.HTML != "" {
+		if err := writeComparison(w, opts.HTML); err != nil {
+			return err
+		}
+	}
+	if opts.DumpPlanes != "" {
+		if err := dumpPlanes(w, opts.DumpPlanes); err != nil {
+			return err
+		}
+	}
+	for _, ref := range []string{opts.PSNRAgainst, opts.Reference} {
+		if ref != "" {
+			if err := printQuality(os.Stdout, w.Result(), ref); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.AssertEquals != "" {
+		return assertEquals(w.Result(), opts.AssertEquals, opts.Tolerance)
+	}
+	return nil
+}
+
+func execStages(ctx context.Context, stages []*waifu2x.Waifu2x, opts *Options, img image.Image, profile []byte) error {
+	stages[0].SetImage(img)
+	stages[0].SetProfile(profile)
+	if opts.Downscale > 1 {
+		downscale := waifu2x.Downscale
+		if opts.DownscaleLinear {
+			downscale = waifu2x.DownscaleLinear
+		}
+		stages[0].SetImage(downscale(stages[0].Image(), opts.Downscale))
+	}
+
+	// Apply the models in order, passing the result of each model to the
+	// next in memory.
+	configureStages(stages, opts)
+	chain := waifu2x.NewModelChain(stages...)
+	if opts.MemStats {
+		stats, err := measureMem(func() error { return chain.ExecContext(ctx) })
+		if err != nil {
+			return err
+		}
+		printMemStats(os.Stderr, stats)
+	} else if err := chain.ExecContext(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+func preserveTimes(input, output string) error {
+	info, err := os.Stat(input)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(output, info.ModTime(), info.ModTime())
+}
+
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".waifu2x-go-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func writeComparison(w *waifu2x.Waifu2x, name string) error {
+	var buf bytes.Buffer
+	if err := w.WriteComparison(&buf); err != nil {
+		return err
+	}
+	return w.WriteFile(name, buf.Bytes())
+}
+
+func dumpPlanes(w *waifu2x.Waifu2x, prefix string) error {
+	y, cb, cr, err := w.Planes()
+	if err != nil {
+		return err
+	}
+	for suffix, img := range map[string]image.Image{"_y.png": y, "_cb.png": cb, "_cr.png": cr} {
+		if err := savePNG(w, prefix+suffix, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveSourceChroma saves the chroma of the input at its resolution next to
+// the output, named with _cb and _cr before the extension.
+func saveSourceChroma(w *waifu2x.Waifu2x, output string) error {
+	cb, cr, err := w.SourceChroma()
+	if err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	for suffix, img := range map[string]image.Image{"_cb.png": cb, "_cr.png": cr} {
+		if err := savePNG(w, base+suffix, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selfTest(out io.Writer) bool {
+	if err := waifu2x.SelfTest(); err != nil {
+		fmt.Fprintln(out, "FAIL:", err)
+		return false
+	}
+	fmt.Fprintln(out, "PASS")
+	return true
+}
+
+func assertEquals(img image.Image, refName string, tolerance int) error {
+	ref, err := loadImage(refName)
+	if err != nil {
+		return err
+	}
+	max, mean, err := waifu2x.Compare(img, ref)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "max diff %d, mean diff %.4f\n", max, mean)
+	if max > tolerance {
+		return fmt.Errorf("output differs from %s: max diff %d exceeds tolerance %d", refName, max, tolerance)
+	}
+	return nil
+}
+
+func printQuality(out io.Writer, img image.Image, refName string) error {
+	ref, err := loadImage(refName)
+	if err != nil {
+		return err
+	}
+	psnr, err := waifu2x.PSNR(img, ref)
+	if err != nil {
+		return fmt.Errorf("comparing the output with %s: %w", refName, err)
+	}
+	ssim, err := waifu2x.SSIM(img, ref)
+	if err != nil {
+		return fmt.Errorf("comparing the output with %s: %w", refName, err)
+	}
+	fmt.Fprintf(out, "PSNR: %.4f dB, SSIM: %.4f\n", psnr, ssim)
+	return nil
+}
+
+func loadImage(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func loadStages(opts *Options) ([]*waifu2x.Waifu2x, []string, error) {
+
+	// Load the models without the image. A .w2xpack file gives the
+	// models and the settings of all its stages.
+
+	modelNames := opts.ModelName
+	if len(modelNames) == 0 {
+		modelNames = []string{""}
+	}
+
+	var stages []*waifu2x.Waifu2x
+	var names []string
+	for i, modelName := range modelNames {
+		if filepath.Ext(modelName) == ".w2xpack" {
+			p, err := waifu2x.LoadPack(modelName)
+			if err != nil {
+				return nil, nil, err
+			}
+			for j, w := range p.Stages() {
+				stages = append(stages, w)
+				names = append(names, fmt.Sprintf("%s_%d", modelBase(modelName), j+1))
+			}
+			continue
+		}
+
+		loadOpts := []waifu2x.Option{
+			waifu2x.WithDownloadRetry(opts.DownloadRetries, opts.DownloadTimeout),
+			waifu2x.WithTempDir(opts.TmpDir),
+		}
+		if i < len(opts.ModelSHA256) && opts.ModelSHA256[i] != "" {
+			loadOpts = append(loadOpts, waifu2x.WithModelSHA256(opts.ModelSHA256[i]))
+		}
+		if opts.ChromaModel != "" && !isNoiseModel(modelName) {
+			loadOpts = append(loadOpts, waifu2x.WithChromaModel(opts.ChromaModel))
+		}
+		w, err := waifu2x.NewWaifu2x(modelName, "", loadOpts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		w.Denoise = isNoiseModel(modelName)
+		stages = append(stages, w)
+		names = append(names, modelBase(modelName))
+	}
+
+	// Configure the stages now too, so that the inputs are decoded within
+	// the limits of the first stage.
+	configureStages(stages, opts)
+	return stages, names, nil
+}
+
+func configureStages(stages []*waifu2x.Waifu2x, opts *Options) {
+	for i, w := range stages {
+		configure(w, opts)
+		if i > 0 {
+			w.PreDenoise = false
+		}
+		if i < len(stages)-1 {
+			w.TargetWidth, w.TargetHeight = 0, 0
+		}
+	}
+}
+
+func configure(w *waifu2x.Waifu2x, opts *Options) {
+	if opts.Padding == "reflect101" {
+		w.Padding = waifu2x.Reflect101
+	}
+	switch opts.Interpolation {
+	case "nearest":
+		w.Interpolation = waifu2x.NearestNeighbor
+	case "bicubic":
+		w.Interpolation = waifu2x.Bicubic
+	case "bilinear":
+		w.Interpolation = waifu2x.Bilinear
+	case "lanczos":
+		w.Interpolation = waifu2x.Lanczos
+	}
+	w.TargetWidth = opts.TargetWidth
+	w.TargetHeight = opts.TargetHeight
+	if opts.Passes > 0 {
+		// Keep the passes of the scale of a .w2xpack file otherwise.
+		w.Passes = opts.Passes
+	}
+	switch opts.KeepAspect {
+	case "letterbox":
+		w.Fit = waifu2x.Letterbox
+	case "crop":
+		w.Fit = waifu2x.Crop
+	}
+	w.MaxPixels = opts.MaxPixels
+	w.MaxOutputDim = opts.MaxOutputDim
+	w.LowMemory = opts.LowMemory
+	w.ColorManaged = opts.ColorManaged
+	w.SRGBOutput = opts.OutputProfile == "srgb"
+	w.PreDenoise = opts.PreDenoise
+	w.TileSize = opts.TileSize
+	w.TileOverlap = opts.TileOverlap
+	w.TileWorkers = opts.TileWorkers
+	w.Workers = opts.Workers
+	w.HDR = opts.HDR
+	w.AutoLevels = opts.AutoLevels
+	w.AutoLevelsPercentile = opts.AutoLevelsPercentile
+	w.JPEGNoSubsample = opts.JPEGNoSubsample
+	w.Half = opts.Half
+	w.Accumulate64 = opts.Accumulate64
+	w.Linear = opts.Linear
+	w.AlphaThreshold = opts.AlphaThreshold
+	w.DropAlpha = opts.AlphaOut != ""
+	w.TempDir = opts.TmpDir
+	w.LumaOnly = opts.LumaOnly || opts.NoChromaUpscale
+	w.ClipWarning = opts.ClipWarning
+	w.GuidedChroma = opts.GuidedChroma
+	w.Preview = opts.Preview
+	w.Palette = opts.Palette
+	w.Mask = opts.mask
+	w.AutoTrim = opts.AutoTrim
+	w.TTA = opts.TTA
+	w.SoftDeadline = opts.deadline
+	w.FileMode = opts.FileMode.perm()
+	switch opts.Dither {
+	case "ordered":
+		w.Dither = waifu2x.OrderedDither
+	case "error-diffusion":
+		w.Dither = waifu2x.ErrorDiffusion
+	}
+	if opts.Rounding == "truncate" {
+		w.Rounding = waifu2x.Truncate
+	}
+	switch opts.ProgressFormat {
+	case "human":
+		w.Progress = humanProgress(os.Stderr)
+	case "json":
+		w.Progress = jsonProgress(os.Stderr)
+	}
+}
+
+func modelBase(modelName string) string {
+	if modelName == "" {
+		return "default"
+	}
+	base := path.Base(filepath.ToSlash(modelName))
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+func isNoiseModel(modelName string) bool {
+
+	// Denoising models are named like noise1_model.json.
+
+	base := modelBase(modelName)
+	return strings.HasPrefix(base, "noise") && !strings.Contains(base, "scale")
+}
+
+func stagePath(dir string, i int, name string) string {
+	return filepath.Join(dir, fmt.Sprintf("%d_%s.png", i+1, name))
+}
+
+// savePNG saves the image with the TempDir and the FileMode of w, like its
+// result.
+func savePNG(w *waifu2x.Waifu2x, name string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
 	}
+	return w.WriteFile(name, buf.Bytes())
 }
@@ -0,0 +1,701 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func identityModel() []waifu2x.Model {
+	return []waifu2x.Model{{
+		Weight: [][][][]float32{{{
+			{0, 0, 0},
+			{0, 1, 0},
+			{0, 0, 0},
+		}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{0},
+		NInputPlane:  1,
+	}}
+}
+
+func writeModel(t *testing.T, dir, name string) string {
+	t.Helper()
+	b, err := json.Marshal(identityModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeImage(t *testing.T, path string, width, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+	if err := savePNG(&waifu2x.Waifu2x{}, path, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readImage(t *testing.T, path string) image.Image {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRunDumpStages(t *testing.T) {
+	dir := t.TempDir()
+	stages := filepath.Join(dir, "stages")
+	opts := &Options{
+		Input:  []string{writeImage(t, filepath.Join(dir, "src.png"), 6, 4)},
+		Output: filepath.Join(dir, "dst.png"),
+		ModelName: []string{
+			writeModel(t, dir, "noise1_model.json"),
+			writeModel(t, dir, "scale2.0x_model.json"),
+		},
+		DumpStages: stages,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(stages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d staged images, want 2", len(entries))
+	}
+	expected := map[string]image.Point{
+		"1_noise1_model.png":    image.Pt(6, 4),
+		"2_scale2.0x_model.png": image.Pt(12, 8),
+	}
+	for name, size := range expected {
+		img := readImage(t, filepath.Join(stages, name))
+		if img.Bounds().Size() != size {
+			t.Errorf("%s: got size %v, want %v", name, img.Bounds().Size(), size)
+		}
+	}
+	if size := readImage(t, opts.Output).Bounds().Size(); size != image.Pt(12, 8) {
+		t.Errorf("got output size %v, want (12,8)", size)
+	}
+}
+
+func TestRunAssertEquals(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:     []string{writeImage(t, filepath.Join(dir, "src.png"), 6, 4)},
+		Output:    filepath.Join(dir, "dst.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The output is compared to itself.
+	ref := filepath.Join(dir, "ref.png")
+	if err := os.Rename(opts.Output, ref); err != nil {
+		t.Fatal(err)
+	}
+	opts.AssertEquals = ref
+	if err := run(context.Background(), opts); err != nil {
+		t.Errorf("got %v comparing with the same output", err)
+	}
+
+	img := image.NewRGBA(readImage(t, ref).Bounds())
+	draw.Draw(img, img.Bounds(), readImage(t, ref), image.Point{}, draw.Src)
+	img.Pix[0] += 10
+	if err := savePNG(&waifu2x.Waifu2x{}, ref, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error comparing with a modified reference")
+	}
+	opts.Tolerance = 10
+	if err := run(context.Background(), opts); err != nil {
+		t.Errorf("got %v comparing within the tolerance", err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	var out bytes.Buffer
+	if !selfTest(&out) {
+		t.Fatalf("self-test failed: %s", out.String())
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("PASS\n")) {
+		t.Errorf("got %q, want PASS", out.String())
+	}
+}
+
+func TestRunDefaultModel(t *testing.T) {
+	t.Setenv(modelEnv, "")
+	dir := t.TempDir()
+	opts := &Options{
+		Input:  []string{writeImage(t, filepath.Join(dir, "in.png"), 8, 6)},
+		Output: filepath.Join(dir, "out.png"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if size := readImage(t, opts.Output).Bounds().Size(); size != image.Pt(16, 12) {
+		t.Errorf("got %v, want (16,12)", size)
+	}
+}
+
+func TestRunModelEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	// A noise model keeps the size, unlike the embedded scale model.
+	t.Setenv(modelEnv, writeModel(t, dir, "noise1_model.json"))
+	opts := &Options{
+		Input:  []string{writeImage(t, filepath.Join(dir, "in.png"), 8, 6)},
+		Output: filepath.Join(dir, "out.png"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if size := readImage(t, opts.Output).Bounds().Size(); size != image.Pt(8, 6) {
+		t.Errorf("got %v, want the size kept by the model of %s", size, modelEnv)
+	}
+
+	// -m takes precedence.
+	opts.ModelName = []string{writeModel(t, dir, "scale2.0x_model.json")}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if size := readImage(t, opts.Output).Bounds().Size(); size != image.Pt(16, 12) {
+		t.Errorf("got %v with -m, want (16,12)", size)
+	}
+}
+
+func TestRunDumpPlanes(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:      []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:     filepath.Join(dir, "out.png"),
+		ModelName:  []string{writeModel(t, dir, "scale2.0x_model.json")},
+		DumpPlanes: filepath.Join(dir, "planes"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, suffix := range []string{"_y.png", "_cb.png", "_cr.png"} {
+		img := readImage(t, opts.DumpPlanes+suffix)
+		if _, ok := img.(*image.Gray); !ok {
+			t.Errorf("%s: got %T, want *image.Gray", suffix, img)
+		}
+		if size := img.Bounds().Size(); size != image.Pt(10, 8) {
+			t.Errorf("%s: got size %v, want (10,8)", suffix, size)
+		}
+	}
+}
+
+func TestRunMipmaps(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:     []string{writeImage(t, filepath.Join(dir, "in.png"), 8, 3)},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		Mipmaps:   true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]image.Point{
+		"out_8x3.png": image.Pt(8, 3),
+		"out_4x1.png": image.Pt(4, 1),
+		"out_2x1.png": image.Pt(2, 1),
+		"out_1x1.png": image.Pt(1, 1),
+	} {
+		if s := readImage(t, filepath.Join(dir, name)).Bounds().Size(); s != size {
+			t.Errorf("%s: got size %v, want %v", name, s, size)
+		}
+	}
+}
+
+func TestConfigureWorkers(t *testing.T) {
+	cmd := parse(t, "-i", "in.png", "-c", "4", "--workers", "2").(*upscaleCommand)
+	w := &waifu2x.Waifu2x{}
+	configure(w, &cmd.Options)
+	if w.Workers != 2 {
+		t.Errorf("got %d workers, want 2", w.Workers)
+	}
+}
+
+func TestConfigureDither(t *testing.T) {
+	for mode, want := range map[string]waifu2x.DitherMode{
+		"none":            waifu2x.NoDither,
+		"ordered":         waifu2x.OrderedDither,
+		"error-diffusion": waifu2x.ErrorDiffusion,
+	} {
+		opts := &Options{}
+		if _, err := flags.ParseArgs(opts, []string{"-i", "in.png", "--dither", mode}); err != nil {
+			t.Fatal(err)
+		}
+		w := &waifu2x.Waifu2x{}
+		configure(w, opts)
+		if w.Dither != want {
+			t.Errorf("--dither %s: got %d, want %d", mode, w.Dither, want)
+		}
+	}
+}
+
+func TestRunHugeHeader(t *testing.T) {
+
+	// The header declares a 65535x65535 image, which is too large to be
+	// decoded, whether the input is read alone or prefetched in a batch.
+
+	dir := t.TempDir()
+	path := writeImage(t, filepath.Join(dir, "huge.png"), 3, 2)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(b[16:24], []byte{0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff})
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []*Options{
+		{MaxPixels: 1 << 12},
+		{MaxOutputDim: 1 << 12},
+	} {
+		opts.Input = []string{path}
+		opts.Output = filepath.Join(dir, "out.png")
+		opts.ModelName = []string{writeModel(t, dir, "scale2.0x_model.json")}
+		if err := run(context.Background(), opts); !errors.Is(err, waifu2x.ErrImageTooLarge) {
+			t.Errorf("got %v, want %v", err, waifu2x.ErrImageTooLarge)
+		}
+	}
+	w := &waifu2x.Waifu2x{MaxPixels: 1 << 12}
+	if d := <-prefetch(context.Background(), w, []string{path}); !errors.Is(d.err, waifu2x.ErrImageTooLarge) {
+		t.Errorf("prefetch: got %v, want %v", d.err, waifu2x.ErrImageTooLarge)
+	}
+}
+
+func TestRunMaxOutputDim(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:        []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:       filepath.Join(dir, "out.png"),
+		ModelName:    []string{writeModel(t, dir, "scale2.0x_model.json")},
+		TargetWidth:  100000,
+		MaxOutputDim: 1000,
+	}
+	if err := run(context.Background(), opts); !errors.Is(err, waifu2x.ErrImageTooLarge) {
+		t.Errorf("got %v, want %v", err, waifu2x.ErrImageTooLarge)
+	}
+	if _, err := os.Stat(opts.Output); !os.IsNotExist(err) {
+		t.Errorf("got %v for the output, want not exist", err)
+	}
+}
+
+func TestRunPreserveTimes(t *testing.T) {
+	dir := t.TempDir()
+	input := writeImage(t, filepath.Join(dir, "in.png"), 5, 4)
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := os.Chtimes(input, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		Input:         []string{input},
+		Output:        filepath.Join(dir, "out.png"),
+		ModelName:     []string{writeModel(t, dir, "scale2.0x_model.json")},
+		PreserveTimes: true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := info.ModTime().Sub(mtime); d < -time.Second || d > time.Second {
+		t.Errorf("got mtime %v, want %v", info.ModTime(), mtime)
+	}
+}
+
+func TestRunChromaModel(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:       []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:      filepath.Join(dir, "out.png"),
+		ModelName:   []string{writeModel(t, dir, "noise1_model.json"), writeModel(t, dir, "scale2.0x_model.json")},
+		ChromaModel: writeModel(t, dir, "chroma.json"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if s := readImage(t, opts.Output).Bounds().Size(); s != image.Pt(10, 8) {
+		t.Errorf("got size %v, want (10,8)", s)
+	}
+
+	// The chroma model is loaded and checked with the scale model.
+	if err := os.WriteFile(opts.ChromaModel, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), opts); !errors.Is(err, waifu2x.ErrInvalidModel) {
+		t.Errorf("got %v for an empty chroma model, want %v", err, waifu2x.ErrInvalidModel)
+	}
+}
+
+func TestRunSplitOutput(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:       []string{writeImage(t, filepath.Join(dir, "in.png"), 9, 4)},
+		Output:      filepath.Join(dir, "out.png"),
+		ModelName:   []string{writeModel(t, dir, "scale2.0x_model.json")},
+		SplitOutput: 3,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"out_1.png", "out_2.png", "out_3.png"} {
+		if s := readImage(t, filepath.Join(dir, name)).Bounds().Size(); s != image.Pt(6, 8) {
+			t.Errorf("%s: got size %v, want (6,8)", name, s)
+		}
+	}
+	if _, err := os.Stat(opts.Output); !os.IsNotExist(err) {
+		t.Errorf("got %v for the whole output, want not exist", err)
+	}
+}
+
+func TestRunTmpDir(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:     []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		TmpDir:    filepath.Join(dir, "missing"),
+	}
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error for a missing --tmp-dir")
+	}
+
+	opts.TmpDir = t.TempDir()
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if s := readImage(t, opts.Output).Bounds().Size(); s != image.Pt(10, 8) {
+		t.Errorf("got size %v, want (10,8)", s)
+	}
+	if entries, _ := os.ReadDir(opts.TmpDir); len(entries) != 0 {
+		t.Errorf("temporary files are left: %v", entries)
+	}
+}
+
+func TestRunLumaOnly(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:     []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		LumaOnly:  true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if img := readImage(t, opts.Output); img.ColorModel() != color.GrayModel {
+		t.Errorf("got %T, want a grayscale image", img)
+	}
+}
+
+func TestPrintQuality(t *testing.T) {
+	dir := t.TempDir()
+	ref := writeImage(t, filepath.Join(dir, "gt.png"), 16, 12)
+	var out bytes.Buffer
+	if err := printQuality(&out, readImage(t, ref), ref); err != nil {
+		t.Fatal(err)
+	}
+	var psnr, ssim float64
+	if _, err := fmt.Sscanf(out.String(), "PSNR: %g dB, SSIM: %g", &psnr, &ssim); err != nil {
+		t.Fatalf("%v: %q", err, out.String())
+	}
+	if psnr < 100 || ssim != 1 {
+		t.Errorf("got PSNR %v and SSIM %v against itself, want a very high PSNR and 1", psnr, ssim)
+	}
+
+	small := writeImage(t, filepath.Join(dir, "small.png"), 8, 6)
+	if err := printQuality(&out, readImage(t, ref), small); !errors.Is(err, waifu2x.ErrSizeMismatch) {
+		t.Errorf("got %v, want %v", err, waifu2x.ErrSizeMismatch)
+	}
+}
+
+func TestRunPreview(t *testing.T) {
+	dir := t.TempDir()
+	cmd := parse(t, "-i", writeImage(t, filepath.Join(dir, "in.png"), 16, 12), "-o", filepath.Join(dir, "out.png"),
+		"-m", writeModel(t, dir, "scale2.0x_model.json"), "--preview").(*upscaleCommand)
+	if cmd.Preview != 4 {
+		t.Fatalf("got --preview %d, want 4 by default", cmd.Preview)
+	}
+	if err := run(context.Background(), &cmd.Options); err != nil {
+		t.Fatal(err)
+	}
+	if size := readImage(t, cmd.Output).Bounds().Size(); size != image.Pt(32, 24) {
+		t.Errorf("got %v, want the size of the full output", size)
+	}
+}
+
+func TestRunNoChromaUpscale(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		Input:           []string{writeImage(t, filepath.Join(dir, "in.png"), 5, 4)},
+		Output:          filepath.Join(dir, "out.png"),
+		ModelName:       []string{writeModel(t, dir, "scale2.0x_model.json")},
+		NoChromaUpscale: true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	luma := readImage(t, opts.Output)
+	if luma.ColorModel() != color.GrayModel || luma.Bounds().Size() != image.Pt(10, 8) {
+		t.Errorf("got a %T luma of %v, want a gray image of 10x8", luma, luma.Bounds().Size())
+	}
+	for _, name := range []string{"out_cb.png", "out_cr.png"} {
+		if size := readImage(t, filepath.Join(dir, name)).Bounds().Size(); size != image.Pt(5, 4) {
+			t.Errorf("%s: got %v, want the size of the input", name, size)
+		}
+	}
+}
+
+func TestRunDownscaleLinear(t *testing.T) {
+	dir := t.TempDir()
+	checker := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range checker.Pix {
+		if (i%8+i/8)%2 == 0 {
+			checker.Pix[i] = 255
+		}
+	}
+	in := filepath.Join(dir, "in.png")
+	if err := savePNG(&waifu2x.Waifu2x{}, in, checker); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		Input:           []string{in},
+		Output:          filepath.Join(dir, "out.png"),
+		ModelName:       []string{writeModel(t, dir, "scale2.0x_model.json")},
+		Downscale:       2,
+		DownscaleLinear: true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := readImage(t, opts.Output).At(3, 3).RGBA(); r>>8 < 180 {
+		t.Errorf("got %d, want the mid-gray of half the light", r>>8)
+	}
+}
+
+func TestRunMask(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeImage(t, in, 8, 6)
+	opts := &Options{
+		Input:     []string{in},
+		Output:    filepath.Join(dir, "full.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	full := readImage(t, opts.Output)
+
+	outputs := map[color.Color]image.Image{}
+	for _, c := range []color.Color{color.White, color.Black} {
+		mask := image.NewGray(image.Rect(0, 0, 8, 6))
+		draw.Draw(mask, mask.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		opts.Mask = filepath.Join(dir, "mask.png")
+		if err := savePNG(&waifu2x.Waifu2x{}, opts.Mask, mask); err != nil {
+			t.Fatal(err)
+		}
+		opts.Output = filepath.Join(dir, "masked.png")
+		if err := run(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		outputs[c] = readImage(t, opts.Output)
+	}
+	if !reflect.DeepEqual(outputs[color.White], full) {
+		t.Error("a white mask changed the output")
+	}
+	if reflect.DeepEqual(outputs[color.Black], full) {
+		t.Error("a black mask didn't change the output")
+	}
+
+	opts.Mask = filepath.Join(dir, "missing.png")
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error for a missing mask")
+	}
+}
+
+func TestRunSoftDeadline(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeImage(t, in, 8, 6)
+	opts := &Options{
+		Input:        []string{in},
+		Output:       filepath.Join(dir, "out.png"),
+		ModelName:    []string{writeModel(t, dir, "scale2.0x_model.json")},
+		SoftDeadline: time.Nanosecond,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readImage(t, opts.Output).Bounds(); got != image.Rect(0, 0, 16, 12) {
+		t.Errorf("got bounds %v, want 16x12", got)
+	}
+
+	opts.CacheDir = filepath.Join(dir, "cache")
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error for --soft-deadline with --cache-dir")
+	}
+}
+
+func TestRunPasses(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeImage(t, in, 8, 6)
+	opts := &Options{
+		Input:     []string{in},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+		Passes:    2,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readImage(t, opts.Output).Bounds(); got != image.Rect(0, 0, 32, 24) {
+		t.Errorf("got bounds %v, want 32x24", got)
+	}
+
+	opts.TargetWidth = 64
+	if err := run(context.Background(), opts); err == nil {
+		t.Error("got no error for --passes with --target-width")
+	}
+}
+
+func TestRunPackPasses(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writeImage(t, in, 8, 6)
+	pack := filepath.Join(dir, "model.w2xpack")
+	f, err := os.Create(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	b, err := json.Marshal(identityModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"manifest.json":        `{"scale": 4, "models": [{"file": "scale2.0x_model.json"}]}`,
+		"scale2.0x_model.json": string(b),
+	}
+	for name, data := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The scale of the pack gives the passes without --passes.
+	opts := &Options{
+		Input:     []string{in},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{pack},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readImage(t, opts.Output).Bounds(); got != image.Rect(0, 0, 32, 24) {
+		t.Errorf("got bounds %v, want 32x24", got)
+	}
+}
+
+func TestRunAlphaOut(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(30 * x), 200, uint8(40 * y), uint8(255 - 32*x)})
+		}
+	}
+	if err := savePNG(&waifu2x.Waifu2x{}, in, src); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{
+		Input:     []string{in},
+		Output:    filepath.Join(dir, "full.png"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	full := readImage(t, opts.Output)
+
+	opts.Output = filepath.Join(dir, "out.png")
+	opts.AlphaOut = filepath.Join(dir, "alpha.png")
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	mask, out := readImage(t, opts.AlphaOut), readImage(t, opts.Output)
+	if mask.Bounds() != full.Bounds() {
+		t.Fatalf("got mask bounds %v, want %v", mask.Bounds(), full.Bounds())
+	}
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			_, _, _, want := full.At(x, y).RGBA()
+			if got := color.GrayModel.Convert(mask.At(x, y)).(color.Gray).Y; uint32(got) != want>>8 {
+				t.Fatalf("mask at (%d, %d): got %d, want the alpha %d", x, y, got, want>>8)
+			}
+			if _, _, _, a := out.At(x, y).RGBA(); a != 0xffff {
+				t.Fatalf("output at (%d, %d) has alpha %d, want opaque", x, y, a>>8)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// memSampleInterval is the interval of sampling the heap for the peak.
+const memSampleInterval = time.Millisecond
+
+type memStats struct {
+	before, peak, after uint64
+}
+
+func heapAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func measureMem(f func() error) (memStats, error) {
+
+	// Sample the allocated heap while f runs. The peak between samples is
+	// missed, so it is a lower bound.
+
+	stats := memStats{before: heapAlloc()}
+	stats.peak = stats.before
+	done := make(chan struct{})
+	sampled := make(chan uint64)
+	go func() {
+		peak := uint64(0)
+		ticker := time.NewTicker(memSampleInterval)
+		defer ticker.Stop()
+		for {
+			if h := heapAlloc(); h > peak {
+				peak = h
+			}
+			select {
+			case <-done:
+				sampled <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	err := f()
+	stats.after = heapAlloc()
+	close(done)
+	for _, h := range []uint64{<-sampled, stats.after} {
+		if h > stats.peak {
+			stats.peak = h
+		}
+	}
+	return stats, err
+}
+
+func printMemStats(out io.Writer, stats memStats) {
+	const mib = 1 << 20
+	fmt.Fprintf(out, "heap: %.1f MiB before, %.1f MiB peak, %.1f MiB after\n",
+		float64(stats.before)/mib, float64(stats.peak)/mib, float64(stats.after)/mib)
+}
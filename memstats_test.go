@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMeasureMem(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")}}
+	stages, names, err := loadStages(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := writeImage(t, filepath.Join(dir, "in.png"), 256, 256)
+	runtime.GC()
+
+	stats, err := measureMem(func() error {
+		return process(context.Background(), stages, names, opts, in, filepath.Join(dir, "out.png"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.peak <= stats.before {
+		t.Errorf("got peak %d, want more than the baseline %d", stats.peak, stats.before)
+	}
+
+	var out bytes.Buffer
+	printMemStats(&out, stats)
+	if !strings.HasPrefix(out.String(), "heap: ") {
+		t.Errorf("got %q", out.String())
+	}
+}
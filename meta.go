@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+// version is set at build time by -ldflags "-X main.version=...".
+var version = "devel"
+
+// runMeta is the provenance of a run written by --meta.
+type runMeta struct {
+	Input        string   `json:"input"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	OutputWidth  int      `json:"outputWidth"`
+	OutputHeight int      `json:"outputHeight"`
+	Models       []string `json:"models"`
+	Scale        float64  `json:"scale"`
+	Elapsed      float64  `json:"elapsed"`
+	Version      string   `json:"version"`
+
+	// The checksums of the files, written by --sidecar.
+	InputSHA256  string `json:"inputSha256,omitempty"`
+	OutputSHA256 string `json:"outputSha256,omitempty"`
+}
+
+func writeMeta(name, input string, models []string, stats waifu2x.Stats) error {
+	return writeJSON(name, newRunMeta(input, models, stats))
+}
+
+// writeSidecar writes the meta of the output with the checksums of the input
+// and the output next to it, named with .json appended.
+func writeSidecar(output, input string, models []string, stats waifu2x.Stats) error {
+	meta := newRunMeta(input, models, stats)
+	var err error
+	if meta.InputSHA256, err = fileSHA256(input); err != nil {
+		return err
+	}
+	if meta.OutputSHA256, err = fileSHA256(output); err != nil {
+		return err
+	}
+	return writeJSON(sidecarPath(output), meta)
+}
+
+func sidecarPath(output string) string {
+	return output + ".json"
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newRunMeta(input string, models []string, stats waifu2x.Stats) runMeta {
+
+	// The models are the paths given by -m, or "default" for the embedded
+	// model. Elapsed is in seconds.
+
+	if len(models) == 0 {
+		models = []string{"default"}
+	}
+	meta := runMeta{
+		Input:        input,
+		Width:        stats.InputWidth,
+		Height:       stats.InputHeight,
+		OutputWidth:  stats.OutputWidth,
+		OutputHeight: stats.OutputHeight,
+		Models:       models,
+		Elapsed:      stats.Elapsed.Seconds(),
+		Version:      version,
+	}
+	if stats.InputWidth > 0 {
+		meta.Scale = float64(stats.OutputWidth) / float64(stats.InputWidth)
+	}
+	return meta
+}
+
+func writeJSON(name string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0666)
+}
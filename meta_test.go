@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunMeta(t *testing.T) {
+	dir := t.TempDir()
+	input := writeImage(t, filepath.Join(dir, "in.png"), 5, 4)
+	model := writeModel(t, dir, "scale2.0x_model.json")
+	opts := &Options{
+		Input:       []string{input},
+		Output:      filepath.Join(dir, "out.png"),
+		ModelName:   []string{model},
+		TargetWidth: 20,
+		Meta:        filepath.Join(dir, "meta.json"),
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(opts.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta map[string]interface{}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		t.Fatal(err)
+	}
+	elapsed, ok := meta["elapsed"].(float64)
+	if !ok || elapsed <= 0 {
+		t.Errorf("got elapsed %v, want a positive number", meta["elapsed"])
+	}
+	delete(meta, "elapsed")
+	want := map[string]interface{}{
+		"input":        input,
+		"width":        5.0,
+		"height":       4.0,
+		"outputWidth":  20.0,
+		"outputHeight": 16.0,
+		"models":       []interface{}{model},
+		"scale":        4.0,
+		"version":      "devel",
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("got %v, want %v", meta, want)
+	}
+}
+
+func TestRunSidecar(t *testing.T) {
+	dir := t.TempDir()
+	input := writeImage(t, filepath.Join(dir, "in.png"), 5, 4)
+	model := writeModel(t, dir, "scale2.0x_model.json")
+	opts := &Options{
+		Input:     []string{input},
+		Output:    filepath.Join(dir, "out.png"),
+		ModelName: []string{model},
+		Sidecar:   true,
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "out.png.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta runMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(out); meta.OutputSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("got output checksum %s, want %x", meta.OutputSHA256, sum)
+	}
+	if sum := sha256.Sum256(in); meta.InputSHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("got input checksum %s, want %x", meta.InputSHA256, sum)
+	}
+	if meta.Input != input || !reflect.DeepEqual(meta.Models, []string{model}) || meta.Scale != 2 || meta.OutputWidth != 10 || meta.Elapsed <= 0 {
+		t.Errorf("got %+v", meta)
+	}
+
+	// Each output of a batch has a sidecar.
+	opts.Input = []string{input, writeImage(t, filepath.Join(dir, "b.png"), 3, 3)}
+	opts.Output = filepath.Join(dir, "batch")
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"in.png.json", "b.png.json"} {
+		if _, err := os.Stat(filepath.Join(opts.Output, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -1,9 +1,85 @@
 package main
 
+import (
+	"image"
+	"time"
+)
+
 // Options is option of the command.
 type Options struct {
-	Input     string `short:"i" long:"input" description:"Input image file path" required:"true"`
-	Output    string `short:"o" long:"output" description:"Output image file path"`
-	ModelName string `short:"m" long:"model" description:"Path of model" required:"true"`
-	CPU       int    `short:"c" long:"cpu" description:"The number of CPUs used to calcurate"`
+	Input     []string `short:"i" long:"input" description:"Input image file or directory path, processed in batch when given multiple times or a directory"`
+	Output    string   `short:"o" long:"output" description:"Output image file path, or directory path in batch"`
+	ModelName []string `short:"m" long:"model" description:"Path or URL of model, applied in order when given multiple times, $WAIFU2X_MODEL or the embedded model by default"`
+	Padding   string   `long:"padding" description:"Padding mode at the image borders" choice:"edge" choice:"reflect101" default:"edge"`
+
+	TargetWidth  int      `long:"target-width" description:"Width of the output image"`
+	TargetHeight int      `long:"target-height" description:"Height of the output image"`
+	KeepAspect   string   `long:"keep-aspect" description:"Keep the aspect ratio when both target width and height are set" choice:"letterbox" choice:"crop"`
+	MaxPixels    int      `long:"max-pixels" description:"The maximum number of pixels given to the model"`
+	ModelSHA256  []string `long:"model-sha256" description:"SHA-256 checksum the model of the same position must match"`
+	Diff         string   `long:"diff" description:"Output path of the PNG image showing where the model changed the luma"`
+	LowMemory    bool     `long:"low-memory" description:"Process the image in bands of rows to reduce memory usage"`
+	DumpStages   string   `long:"dump-stages" description:"Directory where the result of each model is saved"`
+	ColorManaged bool     `long:"color-managed" description:"Compute the luma of Display P3 images from the P3 primaries"`
+	AssertEquals string   `long:"assert-equals" description:"Fail if the output differs from the reference image"`
+	Tolerance    int      `long:"tolerance" description:"The maximum difference of a channel allowed by --assert-equals"`
+	Downscale    int      `long:"downscale" description:"Shrink the input by N with bilinear interpolation before processing"`
+	PSNRAgainst  string   `long:"psnr-against" description:"Print the PSNR and SSIM of the output against the original image"`
+	PreDenoise   bool     `long:"pre-denoise" description:"Apply a 3x3 median filter to the luma before processing"`
+	TileSize     int      `long:"tile-size" description:"Process the image in tiles of the size"`
+	TileWorkers  int      `long:"tile-workers" description:"The number of tiles processed at the same time"`
+	HDR          bool     `long:"hdr" description:"Process OpenEXR images in linear floating point"`
+
+	AutoLevels           bool          `long:"auto-levels" description:"Stretch the luma to the whole range before processing"`
+	AutoLevelsPercentile float64       `long:"auto-levels-percentile" description:"Percentage of the darkest and the brightest pixels ignored by --auto-levels"`
+	Timeout              time.Duration `long:"timeout" description:"Stop processing after the duration, e.g. 10m"`
+	DumpPlanes           string        `long:"dump-planes" description:"Path prefix of the gray PNG images of the Y, Cb and Cr channels of the output"`
+	JPEGNoSubsample      bool          `long:"jpeg-no-subsample" description:"Save JPEG images without chroma subsampling"`
+	MemStats             bool          `long:"mem-stats" description:"Print the allocated heap before, at the peak of and after processing"`
+	Half                 bool          `long:"half" description:"Store the planes between the layers in float16 to reduce memory usage"`
+	Linear               bool          `long:"linear" description:"Give the model the luma in linear light instead of gamma encoded"`
+	HTML                 string        `long:"html" description:"Output path of the HTML page comparing the input and the output with a slider"`
+	Mipmaps              bool          `long:"mipmaps" description:"Also save the output shrunk to a half, a quarter and so on, named with their sizes"`
+	Workers              int           `long:"workers" description:"The number of convolutions computed at the same time"`
+	Auto                 string        `long:"auto" description:"Directory of the noise and scale models to choose from by the noise of the input"`
+	MaxOutputDim         int           `long:"max-output-dim" description:"The maximum width and height of the output of the model"`
+	Meta                 string        `long:"meta" description:"Output path of the JSON file of the input, the models, the scale, the elapsed time and the version"`
+	AlphaThreshold       uint8         `long:"alpha-threshold" description:"Make the pixels of alpha below the value fully transparent"`
+	FromFile             string        `long:"from-file" description:"File listing an input path on each line, optionally followed by a tab and the output path, processed in batch"`
+	PreserveTimes        bool          `long:"preserve-times" description:"Set the modification time of the output to that of the input"`
+	ChromaModel          string        `long:"chroma-model" description:"Path of the model applied to the Cb and Cr planes by the scale models"`
+	CacheDir             string        `long:"cache-dir" description:"Directory where the outputs are cached by the input, the models and the options"`
+	ProgressFormat       string        `long:"progress-format" description:"Format of the progress on stderr" choice:"human" choice:"json" default:"human"`
+	SplitOutput          int           `long:"split-output" description:"Save the output in N strips across the longer side, numbered before the extension"`
+	DownloadRetries      int           `long:"download-retries" description:"The number of retries of a failed model download" default:"3"`
+	DownloadTimeout      time.Duration `long:"download-timeout" description:"Give up downloading a model after the duration including the retries, e.g. 1m"`
+	TmpDir               string        `long:"tmp-dir" description:"Directory of the temporary files the outputs and the downloaded models are written to before they are renamed"`
+	Dither               string        `long:"dither" description:"Dither the 8-bit output to reduce the banding of smooth gradients" choice:"none" choice:"ordered" choice:"error-diffusion" default:"none"`
+	LumaOnly             bool          `long:"luma-only" description:"Save the luma of the output as a grayscale PNG or JPEG image"`
+	Reference            string        `long:"reference" description:"Ground truth image the PSNR and SSIM of the output are printed against, like --psnr-against"`
+	ClipWarning          float64       `long:"clip-warning" description:"Warn when more than the fraction of the values the model outputs are clipped to [0, 1], e.g. 0.01"`
+	GuidedChroma         bool          `long:"guided-chroma" description:"Sharpen the upscaled chroma along the edges of the luma"`
+	Preview              int           `long:"preview" description:"Process the input shrunk by N, 4 by default, and resize the result to the full size, for a quick look at the settings" optional:"yes" optional-value:"4"`
+	FileMode             fileMode      `long:"file-mode" description:"Octal permission of the outputs regardless of the umask, e.g. 0640" default-mask:"0644"`
+	NoChromaUpscale      bool          `long:"no-chroma-upscale" description:"Save the luma of the output, and the chroma of the input at its size as PNG images named with _cb and _cr"`
+	DownscaleLinear      bool          `long:"downscale-linear" description:"Shrink the input of --downscale by averaging in linear light instead of bilinear interpolation"`
+	TileOverlap          int           `long:"tile-overlap" description:"The pixels of the neighbours given to each tile, the receptive field of the model by default"`
+	Palette              int           `long:"palette" description:"Quantize PNG outputs to N colors, at most 256, by median cut and save them as indexed PNG"`
+	Accumulate64         bool          `long:"accumulate64" description:"Sum the products of the convolutions in float64, which is more accurate for deep models and slower"`
+	Mask                 string        `long:"mask" description:"Path of an image whose non-black pixels are reconstructed, the rest of the output is resized"`
+	AutoTrim             bool          `long:"autotrim" description:"Process only the image inside its uniform borders and fill the borders of the output"`
+	TTA                  bool          `long:"tta" description:"Average the results of the 8 rotations and flips of the image, better at 8 times the work"`
+	Sidecar              bool          `long:"sidecar" description:"Write the JSON of --meta with the checksums of the input and the output next to each output, named with .json appended"`
+	Interpolation        string        `long:"interpolation" description:"Resizing of the image before the model, auto picks nearest for flat colors and bicubic for photographs" choice:"auto" choice:"nearest" choice:"bilinear" choice:"bicubic" choice:"lanczos" default:"auto"`
+	SoftDeadline         time.Duration `long:"soft-deadline" description:"After the duration, finish the layer being computed and output the tiles not done yet only resized, an approximate result"`
+	Passes               int           `long:"passes" description:"Apply each scale model N times, resizing the image before each, for a 2^N upscale"`
+	OutputProfile        string        `long:"output-profile" description:"Convert the outputs to the color space and embed its profile instead of the input's" choice:"srgb"`
+	AlphaOut             string        `long:"alpha-out" description:"Save the upscaled alpha as a grayscale PNG image, and the output opaque"`
+	Rounding             string        `long:"rounding" description:"Quantize the output to 8 bits by rounding to the nearest level or truncating, without --dither" choice:"nearest" choice:"truncate" default:"nearest"`
+
+	// mask is the image of Mask, loaded by run.
+	mask image.Image
+	// deadline is the time of SoftDeadline from the start of the input being
+	// processed.
+	deadline time.Time
 }
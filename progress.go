@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// humanProgress writes the percentage over itself on a terminal line.
+func humanProgress(out io.Writer) func(float64) {
+	return func(fraction float64) {
+		fmt.Fprintf(out, "\r%.1f%%...", 100*fraction)
+		if fraction == 1 {
+			fmt.Fprintln(out)
+		}
+	}
+}
+
+type progressLine struct {
+	Fraction float64 `json:"fraction"`
+	ETASec   float64 `json:"eta_sec"`
+}
+
+func jsonProgress(out io.Writer) func(float64) {
+
+	// Write a JSON object on each line. The ETA is from the time since the
+	// fraction last started over, at the next image of a batch.
+
+	start := time.Now()
+	last := 0.0
+	enc := json.NewEncoder(out)
+	return func(fraction float64) {
+		if fraction < last {
+			start = time.Now()
+		}
+		last = fraction
+		eta := 0.0
+		if fraction > 0 {
+			eta = time.Since(start).Seconds() * (1 - fraction) / fraction
+		}
+		enc.Encode(progressLine{Fraction: fraction, ETASec: eta})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func TestJSONProgress(t *testing.T) {
+	dir := t.TempDir()
+	w, err := waifu2x.NewWaifu2x(writeModel(t, dir, "scale2.0x_model.json"), writeImage(t, filepath.Join(dir, "in.png"), 40, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w.TileSize = 16
+	w.Progress = jsonProgress(&out)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	last := 0.0
+	n := 0
+	for s := bufio.NewScanner(&out); s.Scan(); n++ {
+		var p map[string]float64
+		if err := json.Unmarshal(s.Bytes(), &p); err != nil {
+			t.Fatalf("line %d: %v: %q", n+1, err, s.Text())
+		}
+		if len(p) != 2 || p["fraction"] <= last || p["eta_sec"] < 0 {
+			t.Errorf("line %d: got %v after fraction %v", n+1, p, last)
+		}
+		last = p["fraction"]
+	}
+	if n == 0 || last != 1 {
+		t.Errorf("got %d lines ending at %v, want them to end at 1", n, last)
+	}
+}
+
+func TestHumanProgress(t *testing.T) {
+	var out bytes.Buffer
+	report := humanProgress(&out)
+	report(0.5)
+	report(1)
+	if got, want := out.String(), "\r50.0%...\r100.0%...\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The library reports nothing by itself.
+	for format, want := range map[string]bool{"human": true, "json": true, "": false} {
+		w := &waifu2x.Waifu2x{}
+		configure(w, &Options{ProgressFormat: format})
+		if got := w.Progress != nil; got != want {
+			t.Errorf("%q: got a reporter %v, want %v", format, got, want)
+		}
+	}
+}
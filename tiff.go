@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func isTIFF(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".tif", ".tiff":
+		return true
+	}
+	return false
+}
+
+// readInput decodes the input, keeping the pages of multi-page TIFF images.
+// The size is checked against the limits of w, the first stage.
+func readInput(w *waifu2x.Waifu2x, name string) decodedImage {
+	var d decodedImage
+	if isTIFF(name) {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return decodedImage{err: err}
+		}
+		pages, err := waifu2x.DecodeTIFF(bytes.NewReader(b))
+		if err != nil {
+			return decodedImage{err: fmt.Errorf("%s: %w", name, err)}
+		}
+		if len(pages) > 1 {
+			return decodedImage{pages: pages}
+		}
+	}
+	d.img, d.profile, d.err = decodeInput(w, name)
+	return d
+}
+
+// processPages applies the models to each page and saves the results as the
+// pages of a TIFF image, keeping the metadata of the pages.
+func processPages(ctx context.Context, stages []*waifu2x.Waifu2x, opts *Options, pages []waifu2x.TIFFPage, optImageName string) error {
+	if !isTIFF(optImageName) {
+		return fmt.Errorf("%w: %s: the output of a multi-page TIFF image must be a TIFF image", waifu2x.ErrUnsupportedFormat, optImageName)
+	}
+	out := make([]waifu2x.TIFFPage, len(pages))
+	for i, page := range pages {
+		if err := execStages(ctx, stages, opts, page.Image, nil); err != nil {
+			return fmt.Errorf("page %d: %w", i+1, err)
+		}
+		out[i] = page.WithImage(stages[len(stages)-1].Result())
+	}
+	return savePages(stages[len(stages)-1], optImageName, out)
+}
+
+func savePages(w *waifu2x.Waifu2x, name string, pages []waifu2x.TIFFPage) error {
+	var buf bytes.Buffer
+	if err := waifu2x.EncodeTIFF(&buf, pages); err != nil {
+		return err
+	}
+	return w.WriteFile(name, buf.Bytes())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+)
+
+func writeTIFF(t *testing.T, path string, pages []waifu2x.TIFFPage) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := waifu2x.EncodeTIFF(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunMultiPageTIFF(t *testing.T) {
+	dir := t.TempDir()
+	writeImage(t, filepath.Join(dir, "a.png"), 5, 4)
+	writeImage(t, filepath.Join(dir, "b.png"), 3, 6)
+	in := writeTIFF(t, filepath.Join(dir, "in.tif"), []waifu2x.TIFFPage{
+		{Image: readImage(t, filepath.Join(dir, "a.png")), Text: map[uint16]string{285: "one"}, XResolution: [2]uint32{200, 1}, YResolution: [2]uint32{200, 1}, ResolutionUnit: 2},
+		{Image: readImage(t, filepath.Join(dir, "b.png")), Text: map[uint16]string{285: "two"}},
+	})
+	opts := &Options{
+		Input:     []string{in},
+		Output:    filepath.Join(dir, "out.tiff"),
+		ModelName: []string{writeModel(t, dir, "scale2.0x_model.json")},
+	}
+	if err := run(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(opts.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pages, err := waifu2x.DecodeTIFF(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	for i, want := range []image.Point{{10, 8}, {6, 12}} {
+		if size := pages[i].Image.Bounds().Size(); size != want {
+			t.Errorf("page %d: got %v, want %v", i, size, want)
+		}
+	}
+	if pages[0].Text[285] != "one" || pages[1].Text[285] != "two" {
+		t.Errorf("got page names %q and %q, want one and two", pages[0].Text[285], pages[1].Text[285])
+	}
+	if pages[0].XResolution != [2]uint32{400, 1} {
+		t.Errorf("got resolution %v, want [400 1]", pages[0].XResolution)
+	}
+
+	// The pages can't be saved as a single image of another format.
+	opts.Output = filepath.Join(dir, "out.png")
+	if err := run(context.Background(), opts); !errors.Is(err, waifu2x.ErrUnsupportedFormat) {
+		t.Errorf("got %v, want %v", err, waifu2x.ErrUnsupportedFormat)
+	}
+}
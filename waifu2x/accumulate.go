@@ -0,0 +1,70 @@
+package waifu2x
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+// convolveSum64 is the layerSum of Accumulate64, summing the products of the
+// kernels and the input planes in float64. The sum is rounded to float32
+// once, with the bias.
+func convolveSum64(planes planeStore, kernels [][][]float32, bias float32, tick func()) (*mat.Matrix, error) {
+	fj := int(math.Min(float64(planes.len()), float64(len(kernels))))
+	if fj == 0 {
+		return nil, errors.New("no input planes")
+	}
+	var acc []float64
+	var rows, cols int
+	for j := 0; j < fj; j++ {
+		plane, kernel := planes.plane(j), kernels[j]
+		if acc == nil {
+			rows, cols = int(plane.Rows)-len(kernel)+1, int(plane.Cols)-len(kernel[0])+1
+			if rows <= 0 || cols <= 0 {
+				return nil, fmt.Errorf("a %dx%d plane is smaller than the %dx%d kernel", plane.Cols, plane.Rows, len(kernel[0]), len(kernel))
+			}
+			acc = make([]float64, rows*cols)
+		}
+		if err := convolveAdd64(acc, cols, plane, kernel); err != nil {
+			return nil, err
+		}
+		tick()
+	}
+	b := float64(bias)
+	res := make([][]float32, rows)
+	for y := range res {
+		res[y] = make([]float32, cols)
+		for x := range res[y] {
+			res[y][x] = float32(acc[y*cols+x] + b)
+		}
+	}
+	return mat.NewMatrix(res), nil
+}
+
+// convolveAdd64 adds the products of the kernel and the plane to acc, the
+// rows of the output of cols pixels.
+func convolveAdd64(acc []float64, cols int, plane *mat.Matrix, kernel [][]float32) error {
+	if trackConvolution != nil {
+		trackConvolution(1)
+		defer trackConvolution(-1)
+	}
+	rows := len(acc) / cols
+	for ky, krow := range kernel {
+		for kx, k := range krow {
+			if kx+cols > int(plane.Cols) || ky+rows > int(plane.Rows) {
+				return fmt.Errorf("a %dx%d kernel doesn't fit a %dx%d plane", len(krow), len(kernel), plane.Cols, plane.Rows)
+			}
+			k := float64(k)
+			for y := 0; y < rows; y++ {
+				row := plane.M[y+ky][kx : kx+cols]
+				out := acc[y*cols : (y+1)*cols]
+				for x, v := range row {
+					out[x] += float64(v) * k
+				}
+			}
+		}
+	}
+	return nil
+}
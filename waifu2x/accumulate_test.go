@@ -0,0 +1,112 @@
+package waifu2x
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/lon9/mat"
+)
+
+// float64Network applies the layers in float64 throughout, as the reference
+// the float32 networks are compared with.
+func float64Network(models []Model, padded *mat.Matrix) [][]float64 {
+	planes := [][][]float64{make([][]float64, padded.Rows)}
+	for y, row := range padded.M {
+		planes[0][y] = make([]float64, len(row))
+		for x, v := range row {
+			planes[0][y][x] = float64(v)
+		}
+	}
+	for _, m := range models {
+		rows, cols := len(planes[0])-m.KH+1, len(planes[0][0])-m.KW+1
+		out := make([][][]float64, m.NOutputPlane)
+		for i := range out {
+			out[i] = make([][]float64, rows)
+			for y := range out[i] {
+				out[i][y] = make([]float64, cols)
+				for x := range out[i][y] {
+					sum := float64(m.Bias[i])
+					for j, plane := range planes {
+						for ky, krow := range m.Weight[i][j] {
+							for kx, k := range krow {
+								sum += plane[y+ky][x+kx] * float64(k)
+							}
+						}
+					}
+					if sum < 0 {
+						sum *= 0.1
+					}
+					out[i][y][x] = sum
+				}
+			}
+		}
+		planes = out
+	}
+	return planes[0]
+}
+
+func TestNetworkFloat64(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(8)), 1, 32, 32, 32, 32, 32, 32, 1)
+	w := &Waifu2x{models: models}
+	padded := pad(mat.NewMatrix(w.extY(w.convertYCbCr(testImage(12, 10)))), uint(len(models)), Edge).BroadcastDiv(255)
+
+	got32, err := w.network(padded, func() {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Accumulate64 = true
+	got64, err := w.network(padded, func() {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := float64Network(models, padded)
+
+	var err32, err64 float64
+	differ := false
+	for y := range want {
+		for x := range want[y] {
+			err32 += math.Abs(float64(got32.M[y][x]) - want[y][x])
+			err64 += math.Abs(float64(got64.M[y][x]) - want[y][x])
+			differ = differ || got32.M[y][x] != got64.M[y][x]
+		}
+	}
+	if !differ {
+		t.Error("the float64 sums are the same as the float32 sums")
+	}
+	if err64 >= err32 {
+		t.Errorf("got an error of %g with float64 sums and %g with float32 sums, want less with float64", err64, err32)
+	}
+}
+
+func TestExecAccumulate64(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(9)), 1, 4, 1)
+	src := testImage(13, 9)
+	want := &Waifu2x{models: models, src: src}
+	if err := want.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: models, src: src, TileSize: 8, Accumulate64: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	max, _, err := Compare(want.Result(), w.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max > 1 {
+		t.Errorf("got max diff %d from the float32 sums, want at most 1", max)
+	}
+
+	// The float64 sums are stored in float16 with Half.
+	w.Half = true
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if max, _, err = Compare(want.Result(), w.Result()); err != nil {
+		t.Fatal(err)
+	}
+	if max > 2 {
+		t.Errorf("got max diff %d with Half, want at most 2", max)
+	}
+}
@@ -0,0 +1,18 @@
+package waifu2x
+
+import "github.com/lon9/mat"
+
+// LeakyReLU scales the negative values of the planes by 0.1. It is the
+// default Activation, and modifies the planes in place.
+func LeakyReLU(planes []mat.Matrix) []mat.Matrix {
+	for _, p := range planes {
+		for _, row := range p.M {
+			for x, v := range row {
+				if v < 0 {
+					row[x] = v * 0.1
+				}
+			}
+		}
+	}
+	return planes
+}
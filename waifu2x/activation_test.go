@@ -0,0 +1,54 @@
+package waifu2x
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lon9/mat"
+)
+
+func TestActivation(t *testing.T) {
+	relu := func(planes []mat.Matrix) []mat.Matrix {
+		for _, p := range planes {
+			for _, row := range p.M {
+				for x, v := range row {
+					if v < 0 {
+						row[x] = 0
+					}
+				}
+			}
+		}
+		return planes
+	}
+
+	input := func() *mat.Matrix {
+		rows := make([][]float32, 5)
+		for y := range rows {
+			rows[y] = []float32{-1, -0.5, 0, 0.5, 1}
+		}
+		return mat.NewMatrix(rows)
+	}
+	for _, half := range []bool{false, true} {
+		for _, c := range []struct {
+			name       string
+			activation func([]mat.Matrix) []mat.Matrix
+			want       []float32
+		}{
+			{"LeakyReLU", nil, []float32{-0.05, 0, 0.5}},
+			{"ReLU", relu, []float32{0, 0, 0.5}},
+		} {
+			w := &Waifu2x{models: []Model{identityModel()}, Half: half, Activation: c.activation}
+			out, err := w.network(input(), func() {}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y, row := range out.M {
+				for x, v := range row {
+					if math.Abs(float64(v-c.want[x])) > 1e-4 {
+						t.Errorf("half %v, %s: got %v at (%d,%d), want %v", half, c.name, v, x, y, c.want[x])
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// splitAlpha returns the alpha of the image and the image with its colors
+// unpremultiplied and made opaque, or nil and the image itself if it is
+// opaque. The luma of the colors is reconstructed, and the alpha, which is
+// resized with nearest neighbor like the colors, is applied again after.
+func splitAlpha(img image.Image) ([][]uint8, image.Image) {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil, img
+	}
+	bounds := img.Bounds()
+	straight := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(straight, straight.Bounds(), img, bounds.Min, draw.Src)
+
+	alpha := make([][]uint8, bounds.Dy())
+	opaque := true
+	for y := range alpha {
+		alpha[y] = make([]uint8, bounds.Dx())
+		for x := range alpha[y] {
+			i := straight.PixOffset(x, y) + 3
+			alpha[y][x] = straight.Pix[i]
+			if straight.Pix[i] != 0xff {
+				opaque = false
+			}
+			straight.Pix[i] = 0xff
+		}
+	}
+	if opaque {
+		return nil, img
+	}
+	return alpha, straight
+}
+
+// applyAlpha premultiplies dst by the alpha. Alpha below threshold is
+// snapped to 0, which clears the colors there, as dst is premultiplied.
+func applyAlpha(dst *image.RGBA, alpha [][]uint8, threshold uint8) {
+	for y, row := range alpha {
+		for x, a := range row {
+			if a < threshold {
+				a = 0
+			}
+			c := dst.RGBAAt(x, y)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(uint32(c.R) * uint32(a) / 0xff),
+				G: uint8(uint32(c.G) * uint32(a) / 0xff),
+				B: uint8(uint32(c.B) * uint32(a) / 0xff),
+				A: a,
+			})
+		}
+	}
+}
+
+// Alpha returns the alpha of the result as a grayscale image, or nil before
+// Exec.
+func (w *Waifu2x) Alpha() *image.Gray {
+	if w.dst == nil {
+		return nil
+	}
+	bounds := w.dst.Bounds()
+	mask := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			mask.SetGray(x, y, color.Gray{w.dst.RGBAAt(x, y).A})
+		}
+	}
+	return mask
+}
+
+// opaqueImage returns a copy of the premultiplied image with the colors
+// unpremultiplied and the alpha dropped.
+func opaqueImage(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if a := uint32(c.A); a > 0 && a < 0xff {
+				c.R = uint8((uint32(c.R)*0xff + a/2) / a)
+				c.G = uint8((uint32(c.G)*0xff + a/2) / a)
+				c.B = uint8((uint32(c.B)*0xff + a/2) / a)
+			}
+			c.A = 0xff
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,53 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestExecAlpha(t *testing.T) {
+
+	// A red sprite with a soft edge of alpha 8 on a transparent image.
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 1; y < 7; y++ {
+		for x := 1; x < 7; x++ {
+			a := uint8(8)
+			if x > 1 && x < 6 && y > 1 && y < 6 {
+				a = 255
+			}
+			src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, a})
+		}
+	}
+
+	for _, threshold := range []uint8{0, 16} {
+		w := &Waifu2x{models: []Model{boxModel()}, src: src, AlphaThreshold: threshold, Interpolation: NearestNeighbor}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				want := src.NRGBAAt(x/2, y/2).A
+				if want < threshold {
+					want = 0
+				}
+				c := w.dst.RGBAAt(x, y)
+				if c.A != want {
+					t.Errorf("threshold %d: got alpha %d at (%d,%d), want %d", threshold, c.A, x, y, want)
+				}
+				if want == 0 && (c.R != 0 || c.G != 0 || c.B != 0) {
+					t.Errorf("threshold %d: got color %v bleeding into (%d,%d)", threshold, c, x, y)
+				}
+			}
+		}
+	}
+
+	// Opaque images stay opaque.
+	w := &Waifu2x{models: []Model{boxModel()}, src: testImage(4, 4)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.dst.Opaque() {
+		t.Error("got a transparent result of an opaque image")
+	}
+}
@@ -0,0 +1,68 @@
+package waifu2x
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// createTemp creates the temporary files of writeFile. Tests replace it to
+// see where they are created.
+var createTemp = os.CreateTemp
+
+// WithTempDir sets TempDir, so that it is also used for the models
+// downloaded by NewWaifu2x.
+func WithTempDir(dir string) Option {
+	return func(w *Waifu2x) {
+		w.TempDir = dir
+	}
+}
+
+// writeFile writes b to a temporary file and renames it to name, so that
+// name is either the old or the complete new file. The temporary file is
+// created in TempDir, or next to name by default. When TempDir is on another
+// file system, the file can't be renamed and is copied to name instead.
+func (w *Waifu2x) writeFile(name string, b []byte, perm os.FileMode) error {
+	dir := w.TempDir
+	if dir == "" {
+		dir = filepath.Dir(name)
+	}
+	f, err := createTemp(dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		if _, ok := err.(*os.LinkError); !ok || w.TempDir == "" {
+			return err
+		}
+		if err := os.WriteFile(name, b, perm); err != nil {
+			return err
+		}
+		return os.Chmod(name, perm)
+	}
+	return nil
+}
+
+// WriteFile writes b to name like the saved images, through a temporary file
+// in TempDir and with the permission of FileMode.
+func (w *Waifu2x) WriteFile(name string, b []byte) error {
+	return w.writeFile(name, b, w.fileMode())
+}
+
+// fileMode returns the permission of the saved images.
+func (w *Waifu2x) fileMode() os.FileMode {
+	if w.FileMode == 0 {
+		return 0644
+	}
+	return w.FileMode
+}
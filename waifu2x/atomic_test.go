@@ -0,0 +1,111 @@
+package waifu2x
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileTempDir(t *testing.T) {
+	defer func(f func(string, string) (*os.File, error)) { createTemp = f }(createTemp)
+	var dirs []string
+	createTemp = func(dir, pattern string) (*os.File, error) {
+		dirs = append(dirs, dir)
+		return ioutil.TempFile(dir, pattern)
+	}
+
+	b, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write(b)
+	}))
+	defer ts.Close()
+
+	tmp := t.TempDir()
+	w, err := NewWaifu2x(ts.URL+"/model.json", writeImage(t, 4, 4), WithCacheDir(t.TempDir()), WithTempDir(tmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.png")
+	if err := w.SaveImage(out); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other files of the caller are written the same way.
+	w.FileMode = 0600
+	side := filepath.Join(t.TempDir(), "side.png")
+	if err := w.WriteFile(side, []byte("side")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(side); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("got %o for the file of WriteFile, want 600", info.Mode().Perm())
+	}
+
+	if len(dirs) != 3 {
+		t.Fatalf("got %d temporary files, want 3 (the model, the output and the file of WriteFile)", len(dirs))
+	}
+	for _, dir := range dirs {
+		if dir != tmp {
+			t.Errorf("temporary file created in %s, want %s", dir, tmp)
+		}
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("temporary files are left: %v", entries)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteFileDefaultDir(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.bin")
+	var w Waifu2x
+	for _, s := range []string{"old", "new"} {
+		if err := w.writeFile(name, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b, _ := ioutil.ReadFile(name); string(b) != "new" {
+		t.Errorf("got %q, want %q", b, "new")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("got %d files, want 1", len(entries))
+	}
+}
+
+func TestSaveFileMode(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(4, 4)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.png")
+	for _, mode := range []os.FileMode{0, 0640, 0600} {
+		w.FileMode = mode
+		if err := w.SaveImage(out); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := mode
+		if want == 0 {
+			want = 0644
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("FileMode %o: got %o, want %o", mode, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// UnmarshalJSON decodes a layer of a model. Besides arrays of numbers, the
+// weight and the bias can be strings of the base64 encoded little-endian
+// float32 values, in the order of Layout, which some exporters write to
+// shrink the model.
+func (m *Model) UnmarshalJSON(b []byte) error {
+	type plain Model
+	var raw struct {
+		plain
+		Weight json.RawMessage `json:"weight"`
+		Bias   json.RawMessage `json:"bias"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*m = Model(raw.plain)
+
+	if isJSONString(raw.Weight) {
+		values, err := decodeBase64Floats(raw.Weight)
+		if err != nil {
+			return fmt.Errorf("weight: %w", err)
+		}
+		shape := [4]int{m.NOutputPlane, m.NInputPlane, m.KH, m.KW}
+		if m.Layout == LayoutOHWI {
+			shape = [4]int{m.NOutputPlane, m.KH, m.KW, m.NInputPlane}
+		}
+		if m.Weight, err = reshapeWeight(values, shape); err != nil {
+			return err
+		}
+	} else if len(raw.Weight) > 0 {
+		if err := json.Unmarshal(raw.Weight, &m.Weight); err != nil {
+			return err
+		}
+	}
+
+	if isJSONString(raw.Bias) {
+		values, err := decodeBase64Floats(raw.Bias)
+		if err != nil {
+			return fmt.Errorf("bias: %w", err)
+		}
+		m.Bias = values
+	} else if len(raw.Bias) > 0 {
+		if err := json.Unmarshal(raw.Bias, &m.Bias); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isJSONString(b json.RawMessage) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && b[0] == '"'
+}
+
+func decodeBase64Floats(b json.RawMessage) ([]float32, error) {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, fmt.Errorf("%d bytes aren't float32 values", len(data))
+	}
+	values := make([]float32, len(data)/4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return values, nil
+}
+
+// reshapeWeight splits the values into the weight of the shape, in row-major
+// order.
+func reshapeWeight(values []float32, shape [4]int) ([][][][]float32, error) {
+	n := 1
+	for _, d := range shape {
+		if d <= 0 {
+			return nil, fmt.Errorf("weight of shape %v", shape)
+		}
+		n *= d
+	}
+	if len(values) != n {
+		return nil, fmt.Errorf("%d weights for the shape %v", len(values), shape)
+	}
+	weight := make([][][][]float32, shape[0])
+	for a := range weight {
+		weight[a] = make([][][]float32, shape[1])
+		for b := range weight[a] {
+			weight[a][b] = make([][]float32, shape[2])
+			for c := range weight[a][b] {
+				weight[a][b][c], values = values[:shape[3]:shape[3]], values[shape[3]:]
+			}
+		}
+	}
+	return weight, nil
+}
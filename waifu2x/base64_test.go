@@ -0,0 +1,71 @@
+package waifu2x
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func base64Floats(values []float32) string {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestParseModelBase64(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(12)), 1, 4, 2, 1)
+	var layers []map[string]interface{}
+	for _, m := range models {
+		var weights []float32
+		for _, wgt := range m.Weight {
+			for _, k := range wgt {
+				for _, row := range k {
+					weights = append(weights, row...)
+				}
+			}
+		}
+		layers = append(layers, map[string]interface{}{
+			"weight":       base64Floats(weights),
+			"bias":         base64Floats(m.Bias),
+			"nInputPlane":  m.NInputPlane,
+			"nOutputPlane": m.NOutputPlane,
+			"kW":           m.KW,
+			"kH":           m.KH,
+		})
+	}
+	b, err := json.Marshal(layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := parseModel(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, models) {
+		t.Errorf("got %v, want %v", loaded, models)
+	}
+
+	// Plain arrays and base64 can be mixed.
+	plain := `[{"weight":[[[[0,0,0],[0,1,0],[0,0,0]]]],"bias":"` + base64Floats([]float32{0}) + `","nInputPlane":1,"nOutputPlane":1,"kW":3,"kH":3}]`
+	loaded, err = parseModel([]byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, []Model{identityModel()}) {
+		t.Errorf("got %v, want the identity model", loaded)
+	}
+
+	for _, weight := range []string{"not base64!", base64Floats(make([]float32, 8)), base64.StdEncoding.EncodeToString([]byte{1, 2, 3})} {
+		data := `[{"weight":"` + weight + `","bias":[0],"nInputPlane":1,"nOutputPlane":1,"kW":3,"kH":3}]`
+		if _, err := parseModel([]byte(data)); !errors.Is(err, ErrInvalidModel) {
+			t.Errorf("%q: got %v, want %v", weight, err, ErrInvalidModel)
+		}
+	}
+}
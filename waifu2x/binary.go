@@ -0,0 +1,136 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// binaryModelMagic starts a binary model file. It is followed by
+// binaryModelMarker in the byte order of the file, the number of layers and
+// the layers. A layer is nInputPlane, nOutputPlane, kW and kH as uint32, the
+// weights as float32 in the order of Model.Weight and the biases.
+var binaryModelMagic = []byte("W2XB")
+
+// binaryModelMarker reads as binaryModelSwapped in the other byte order.
+const (
+	binaryModelMarker  = 0x01020304
+	binaryModelSwapped = 0x04030201
+)
+
+// EncodeModel writes the models in the binary format in the given byte
+// order. The byte order is recorded in the header, so the file loads on
+// hosts of either endianness.
+func EncodeModel(out io.Writer, models []Model, order binary.ByteOrder) error {
+	var b bytes.Buffer
+	b.Write(binaryModelMagic)
+	put := func(v uint32) {
+		var buf [4]byte
+		order.PutUint32(buf[:], v)
+		b.Write(buf[:])
+	}
+	put(binaryModelMarker)
+	put(uint32(len(models)))
+	for _, m := range models {
+		put(uint32(m.NInputPlane))
+		put(uint32(m.NOutputPlane))
+		put(uint32(m.KW))
+		put(uint32(m.KH))
+		for _, wgt := range m.Weight {
+			for _, k := range wgt {
+				for _, row := range k {
+					for _, v := range row {
+						put(math.Float32bits(v))
+					}
+				}
+			}
+		}
+		for _, v := range m.Bias {
+			put(math.Float32bits(v))
+		}
+	}
+	_, err := out.Write(b.Bytes())
+	return err
+}
+
+func parseBinaryModel(b []byte) ([]Model, error) {
+	b = b[len(binaryModelMagic):]
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidModel)
+	}
+
+	// The marker tells the byte order of the file, whatever the host is.
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(b) {
+	case binaryModelMarker:
+		order = binary.LittleEndian
+	case binaryModelSwapped:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown byte order marker %#x", ErrInvalidModel, b[:4])
+	}
+	b = b[4:]
+
+	get := func() uint32 {
+		v := order.Uint32(b)
+		b = b[4:]
+		return v
+	}
+	// count returns the product of the dimensions, or false if reading
+	// that many values would run past the end of the file.
+	count := func(dims ...uint32) (int, bool) {
+		n := uint64(1)
+		for _, d := range dims {
+			n *= uint64(d)
+			if n > uint64(len(b)/4) {
+				return 0, false
+			}
+		}
+		return int(n), true
+	}
+
+	n := get()
+	if _, ok := count(n, 4); !ok {
+		return nil, fmt.Errorf("%w: %d layers in %d bytes", ErrInvalidModel, n, len(b))
+	}
+	models := make([]Model, n)
+	for l := range models {
+		if len(b) < 16 {
+			return nil, fmt.Errorf("%w: layer %d is truncated", ErrInvalidModel, l)
+		}
+		nIn, nOut, kW, kH := get(), get(), get(), get()
+		weights, ok := count(nOut, nIn, kH, kW)
+		if !ok || weights+int(nOut) > len(b)/4 {
+			return nil, fmt.Errorf("%w: layer %d is truncated", ErrInvalidModel, l)
+		}
+		m := Model{NInputPlane: int(nIn), NOutputPlane: int(nOut), KW: int(kW), KH: int(kH)}
+		m.Weight = make([][][][]float32, nOut)
+		for o := range m.Weight {
+			m.Weight[o] = make([][][]float32, nIn)
+			for i := range m.Weight[o] {
+				m.Weight[o][i] = make([][]float32, kH)
+				for y := range m.Weight[o][i] {
+					row := make([]float32, kW)
+					for x := range row {
+						row[x] = math.Float32frombits(get())
+					}
+					m.Weight[o][i][y] = row
+				}
+			}
+		}
+		m.Bias = make([]float32, nOut)
+		for o := range m.Bias {
+			m.Bias[o] = math.Float32frombits(get())
+		}
+		models[l] = m
+	}
+	if len(b) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidModel, len(b))
+	}
+	if err := validateModels(models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
@@ -0,0 +1,94 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryModelByteOrder(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 1)
+
+	// Models written on a big-endian host must load with the same values
+	// on a little-endian one and vice versa.
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		var b bytes.Buffer
+		if err := EncodeModel(&b, models, order); err != nil {
+			t.Fatal(err)
+		}
+		marker := make([]byte, 4)
+		order.PutUint32(marker, binaryModelMarker)
+		if got := b.Bytes()[4:8]; !bytes.Equal(got, marker) {
+			t.Errorf("%v: got marker %x, want %x", order, got, marker)
+		}
+
+		path := filepath.Join(t.TempDir(), "model.w2xb")
+		if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewWaifu2x(path, "")
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if !reflect.DeepEqual(w.models, models) {
+			t.Errorf("%v: got models %v, want %v", order, w.models, models)
+		}
+	}
+}
+
+func TestBinaryModelBigEndian(t *testing.T) {
+
+	// A big-endian model written by hand, as another host would.
+	var b bytes.Buffer
+	b.WriteString("W2XB")
+	for _, v := range []uint32{binaryModelMarker, 1, 1, 1, 3, 3} {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	weights := []float32{0, 0.25, 0, 0.25, -1.5, 0.25, 0, 0.25, 0}
+	binary.Write(&b, binary.BigEndian, weights)
+	binary.Write(&b, binary.BigEndian, float32(0.125))
+
+	models, err := parseModel(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Model{
+		Weight: [][][][]float32{{{
+			{0, 0.25, 0},
+			{0.25, -1.5, 0.25},
+			{0, 0.25, 0},
+		}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{0.125},
+		NInputPlane:  1,
+	}
+	if !reflect.DeepEqual(models, []Model{want}) {
+		t.Errorf("got %v, want %v", models, []Model{want})
+	}
+}
+
+func TestBinaryModelInvalid(t *testing.T) {
+	var b bytes.Buffer
+	if err := EncodeModel(&b, []Model{identityModel()}, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	valid := b.Bytes()
+
+	for name, data := range map[string][]byte{
+		"marker":    append([]byte("W2XB\x00\x00\x00\x00"), valid[8:]...),
+		"truncated": valid[:len(valid)-1],
+		"trailing":  append(append([]byte(nil), valid...), 0),
+		"layers":    append(append([]byte(nil), valid[:8]...), 0xff, 0xff, 0xff, 0xff),
+	} {
+		if _, err := parseModel(data); !errors.Is(err, ErrInvalidModel) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidModel)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package waifu2x
+
+import (
+	"context"
+	"image"
+)
+
+// ModelChain applies the stages in order, giving the result of each stage
+// to the next in memory instead of encoding it. The stages keep their own
+// settings, e.g. a denoising stage followed by a scale stage.
+type ModelChain struct {
+	Stages []*Waifu2x
+}
+
+// NewModelChain returns a ModelChain of the stages.
+func NewModelChain(stages ...*Waifu2x) *ModelChain {
+	return &ModelChain{Stages: stages}
+}
+
+// SetImage sets the image given to the first stage.
+func (c *ModelChain) SetImage(img image.Image) {
+	c.Stages[0].SetImage(img)
+}
+
+// Exec executes the stages in order.
+func (c *ModelChain) Exec() error {
+	return c.ExecContext(context.Background())
+}
+
+// ExecContext executes the stages in order like Exec, and stops with the
+// error of ctx when ctx is done.
+func (c *ModelChain) ExecContext(ctx context.Context) error {
+	if len(c.Stages) == 0 {
+		return ErrInvalidModel
+	}
+	if p := c.progress(); p != nil {
+		for _, w := range c.Stages {
+			w.progress = p
+		}
+		defer func() {
+			for _, w := range c.Stages {
+				w.progress = nil
+			}
+		}()
+	}
+	for i, w := range c.Stages {
+		if i > 0 {
+			prev := c.Stages[i-1]
+			if hdr := prev.HDRResult(); hdr != nil {
+				w.SetImage(hdr)
+			} else {
+				w.SetImage(prev.Result())
+			}
+			w.SetProfile(prev.outputProfile())
+		}
+		if err := w.ExecContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// progress returns the progress of all the stages, computed from the size
+// each stage gives to the next, or nil when the stages will fail anyway.
+func (c *ModelChain) progress() *progress {
+	first := c.Stages[0]
+	if first.src == nil {
+		return nil
+	}
+	_, float := first.src.(*FloatImage)
+	size := first.src.Bounds().Size()
+	var total int64
+	for _, w := range c.Stages {
+		float = float && w.HDR
+		n, next, err := w.work(size, float)
+		if err != nil {
+			return nil
+		}
+		total += n
+		size = next
+	}
+	return newProgress(total, first.Progress)
+}
+
+// Result returns the result of the last stage. Exec must be called before.
+func (c *ModelChain) Result() *image.RGBA {
+	return c.Stages[len(c.Stages)-1].Result()
+}
@@ -0,0 +1,45 @@
+package waifu2x
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestModelChain(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	noise := randomModel(rng, 1, 4, 1)
+	scale := randomModel(rng, 1, 4, 1)
+	src := testImage(9, 7)
+
+	chain := NewModelChain(
+		&Waifu2x{models: noise, Denoise: true},
+		&Waifu2x{models: scale},
+	)
+	chain.SetImage(src)
+	if err := chain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run the models with a file in between.
+	first := &Waifu2x{models: noise, src: src, Denoise: true}
+	if err := first.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "noise.png")
+	if err := first.SaveImage(path); err != nil {
+		t.Fatal(err)
+	}
+	second := &Waifu2x{models: scale}
+	if err := second.LoadImage(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if chain.Result().Bounds() != second.Result().Bounds() || !bytes.Equal(chain.Result().Pix, second.Result().Pix) {
+		t.Error("chained result differs from the result with a file in between")
+	}
+}
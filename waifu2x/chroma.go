@@ -0,0 +1,85 @@
+package waifu2x
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// WithChromaModel makes NewWaifu2x also load the model file applied to the
+// Cb and Cr planes, which are otherwise only resized with nearest neighbor.
+// Like the model of the luma, it must take and output a single plane. It
+// isn't applied to Display P3 images with ColorManaged.
+func WithChromaModel(path string) Option {
+	return func(w *Waifu2x) {
+		w.chromaModelPath = path
+	}
+}
+
+func (w *Waifu2x) loadChromaModel(path string) error {
+	b, err := w.readFile(path)
+	if err != nil {
+		return err
+	}
+	models, err := parseModel(b)
+	if err != nil {
+		return err
+	}
+	if len(models) == 0 {
+		return fmt.Errorf("%w: chroma model %s has no layers", ErrInvalidModel, path)
+	}
+	if in, out := models[0].NInputPlane, models[len(models)-1].NOutputPlane; in != 1 || out != 1 {
+		return fmt.Errorf("%w: chroma model %s takes %d planes and outputs %d, want 1 plane of Cb or Cr", ErrInvalidModel, path, in, out)
+	}
+	w.chromaModels = models
+	return nil
+}
+
+func (w *Waifu2x) hasChromaModel() bool {
+	return len(w.chromaModels) > 0 && !(w.ColorManaged && w.colorSpace == displayP3)
+}
+
+func (w *Waifu2x) reconstructChroma(ctx context.Context, src image.Image, into *image.RGBA) (*image.RGBA, error) {
+
+	// Apply the model to the luma and the chroma model to each of Cb and
+	// Cr, restoring into into like reconstruct. Linear and AutoLevels are only
+	// for the luma.
+
+	c := w.convertYCbCr(src)
+	planes := make([][][]float32, 3)
+	for k := range planes {
+		planes[k] = make([][]float32, len(c))
+		for i := range c {
+			planes[k][i] = make([]float32, len(c[i]))
+			for j, v := range c[i] {
+				planes[k][i][j] = float32([3]uint8{v.Y, v.Cb, v.Cr}[k])
+			}
+		}
+	}
+
+	chroma := *w
+	chroma.models = w.chromaModels
+	chroma.Linear, chroma.AutoLevels = false, false
+	clipped, values := chroma.clipped, chroma.values
+	var out [3][][]float32
+	for k, p := range planes {
+		stage := w
+		if k > 0 {
+			stage = &chroma
+		}
+		m, err := stage.reconstructLuma(ctx, p, w.Rounding)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = m.M
+	}
+	w.clipped += chroma.clipped - clipped
+	w.values += chroma.values - values
+	for i := range c {
+		for j := range c[i] {
+			c[i][j] = color.YCbCr{uint8(out[0][i][j]), uint8(out[1][i][j]), uint8(out[2][i][j])}
+		}
+	}
+	return ycbcrImage(c, into), nil
+}
@@ -0,0 +1,51 @@
+package waifu2x
+
+import (
+	"errors"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestWithChromaModel(t *testing.T) {
+
+	// The chroma model outputs the middle, so the result is gray with the
+	// luma of the identity model.
+	gray := Model{
+		Weight:       [][][][]float32{{{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{128.0 / 255},
+		NInputPlane:  1,
+	}
+	w, err := NewWaifu2x(writeModel(t, []Model{identityModel()}), writeImage(t, 6, 4), WithChromaModel(writeModel(t, []Model{gray})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	lumaOnly := &Waifu2x{models: []Model{identityModel()}, src: w.src}
+	if err := lumaOnly.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			c := color.YCbCrModel.Convert(w.dst.At(x, y)).(color.YCbCr)
+			want := color.YCbCrModel.Convert(lumaOnly.dst.At(x, y)).(color.YCbCr)
+			if d := int(c.Y) - int(want.Y); d < -2 || d > 2 {
+				t.Errorf("got luma %d at (%d,%d), want %d", c.Y, x, y, want.Y)
+			}
+			if c.Cb < 127 || c.Cb > 129 || c.Cr < 127 || c.Cr > 129 {
+				t.Errorf("got chroma %d, %d at (%d,%d), want 128", c.Cb, c.Cr, x, y)
+			}
+		}
+	}
+
+	rgb := writeModel(t, randomModel(rand.New(rand.NewSource(1)), 3, 1))
+	if _, err := NewWaifu2x(writeModel(t, []Model{identityModel()}), "", WithChromaModel(rgb)); !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("got %v for a chroma model of 3 planes, want %v", err, ErrInvalidModel)
+	}
+}
@@ -0,0 +1,243 @@
+package waifu2x
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+type colorSpace int
+
+const (
+	sRGB colorSpace = iota
+	displayP3
+)
+
+// Luminance of the Display P3 primaries with D65 white point.
+const (
+	p3R = 0.2289746
+	p3G = 0.6917385
+	p3B = 0.0792869
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	iccPrefix    = []byte("ICC_PROFILE\x00")
+)
+
+// Profile returns the ICC profile of the input image, or nil if it has none.
+func (w *Waifu2x) Profile() []byte {
+	return w.profile
+}
+
+// SetProfile sets the ICC profile of the image given by SetImage. It is
+// embedded in the saved image.
+func (w *Waifu2x) SetProfile(profile []byte) {
+	w.profile = profile
+	w.colorSpace = profileColorSpace(profile)
+}
+
+func profileColorSpace(profile []byte) colorSpace {
+
+	// Display P3 profiles are identified by the description, which is
+	// ASCII in v2 profiles and UTF-16BE in v4 profiles.
+
+	name := "Display P3"
+	utf16 := make([]byte, 0, len(name)*2)
+	for _, c := range []byte(name) {
+		utf16 = append(utf16, 0, c)
+	}
+	if bytes.Contains(profile, []byte(name)) || bytes.Contains(profile, utf16) {
+		return displayP3
+	}
+	return sRGB
+}
+
+func iccProfile(b []byte) []byte {
+	switch {
+	case bytes.HasPrefix(b, pngSignature):
+		return pngProfile(b)
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8}):
+		return jpegProfile(b)
+	}
+	return nil
+}
+
+func pngProfile(b []byte) []byte {
+
+	// Find the iCCP chunk, which holds the profile name, the compression
+	// method and the zlib compressed profile.
+
+	for p := len(pngSignature); p+8 <= len(b); {
+		n := int(binary.BigEndian.Uint32(b[p:]))
+		typ := string(b[p+4 : p+8])
+		if n < 0 || p+12+n > len(b) || typ == "IDAT" {
+			return nil
+		}
+		if typ == "iCCP" {
+			data := b[p+8 : p+8+n]
+			i := bytes.IndexByte(data, 0)
+			if i < 0 || i+2 > len(data) {
+				return nil
+			}
+			r, err := zlib.NewReader(bytes.NewReader(data[i+2:]))
+			if err != nil {
+				return nil
+			}
+			profile, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		p += 12 + n
+	}
+	return nil
+}
+
+func jpegProfile(b []byte) []byte {
+
+	// Concatenate the APP2 ICC_PROFILE segments before the scan.
+
+	var profile []byte
+	for p := 2; p+4 <= len(b) && b[p] == 0xff; {
+		marker := b[p+1]
+		n := int(binary.BigEndian.Uint16(b[p+2:]))
+		if marker == 0xda || n < 2 || p+2+n > len(b) {
+			break
+		}
+		data := b[p+4 : p+2+n]
+		if marker == 0xe2 && bytes.HasPrefix(data, iccPrefix) && len(data) > len(iccPrefix)+2 {
+			profile = append(profile, data[len(iccPrefix)+2:]...)
+		}
+		p += 2 + n
+	}
+	return profile
+}
+
+func embedProfile(b, profile []byte) []byte {
+	if len(profile) == 0 {
+		return b
+	}
+	switch {
+	case bytes.HasPrefix(b, pngSignature):
+
+		// Insert the iCCP chunk after IHDR.
+
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(profile)
+		zw.Close()
+		data := append([]byte("ICC profile\x00\x00"), z.Bytes()...)
+		chunk := make([]byte, 8, 12+len(data))
+		binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+		copy(chunk[4:], "iCCP")
+		chunk = append(chunk, data...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+		ihdr := len(pngSignature) + 12 + int(binary.BigEndian.Uint32(b[len(pngSignature):]))
+		res := append([]byte{}, b[:ihdr]...)
+		res = append(res, chunk...)
+		return append(res, b[ihdr:]...)
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8}):
+
+		// Insert APP2 segments after SOI.
+
+		const max = 0xffff - 2 - 14
+		count := (len(profile) + max - 1) / max
+		res := append([]byte{}, b[:2]...)
+		for i := 0; i < count; i++ {
+			data := profile[i*max:]
+			if len(data) > max {
+				data = data[:max]
+			}
+			res = append(res, 0xff, 0xe2)
+			res = binary.BigEndian.AppendUint16(res, uint16(2+len(iccPrefix)+2+len(data)))
+			res = append(res, iccPrefix...)
+			res = append(res, byte(i+1), byte(count))
+			res = append(res, data...)
+		}
+		return append(res, b[2:]...)
+	}
+	return b
+}
+
+func toLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func fromLinear(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func p3Luma(src image.Image) ([][]float32, func(out *mat.Matrix, dst *image.RGBA) *image.RGBA) {
+
+	// Convert to linear light and compute the luminance from the P3
+	// primaries. The network is given the gamma encoded luminance, and the
+	// colors are restored by scaling the linear values to the
+	// reconstructed luminance, so the chromaticity is kept.
+
+	bounds := src.Bounds()
+	lin := make([][][3]float64, bounds.Dy())
+	y := make([][]float32, bounds.Dy())
+	for i := range lin {
+		lin[i] = make([][3]float64, bounds.Dx())
+		y[i] = make([]float32, bounds.Dx())
+		for j := range lin[i] {
+			r, g, b, _ := src.At(bounds.Min.X+j, bounds.Min.Y+i).RGBA()
+			c := [3]float64{toLinear(float64(r) / 0xffff), toLinear(float64(g) / 0xffff), toLinear(float64(b) / 0xffff)}
+			lin[i][j] = c
+			y[i][j] = float32(255 * fromLinear(p3R*c[0]+p3G*c[1]+p3B*c[2]))
+		}
+	}
+
+	return y, func(out *mat.Matrix, dst *image.RGBA) *image.RGBA {
+		dst = outputImage(dst, bounds.Dx(), bounds.Dy())
+		for i := range out.M {
+			for j := range out.M[i] {
+				c := lin[i][j]
+				l := toLinear(float64(out.M[i][j]) / 255)
+				if old := p3R*c[0] + p3G*c[1] + p3B*c[2]; old > 0 {
+					ratio := l / old
+					c = [3]float64{c[0] * ratio, c[1] * ratio, c[2] * ratio}
+				} else {
+					c = [3]float64{l, l, l}
+				}
+				var rgb [3]uint8
+				for k, v := range c {
+					rgb[k] = uint8(math.Round(255 * fromLinear(math.Min(math.Max(v, 0), 1))))
+				}
+				dst.SetRGBA(j, i, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+			}
+		}
+		return dst
+	}
+}
+
+func linearLuma(m *mat.Matrix) (*mat.Matrix, func(*mat.Matrix) *mat.Matrix) {
+
+	// Convert the gamma encoded luma in [0, 255] to linear light with the
+	// sRGB transfer function, and return the function converting back.
+
+	lin := m.BroadcastFunc(func(v float32, _ ...interface{}) float32 {
+		return float32(255 * toLinear(float64(v)/255))
+	})
+	return lin, func(out *mat.Matrix) *mat.Matrix {
+		return out.BroadcastFunc(func(v float32, _ ...interface{}) float32 {
+			return float32(255 * fromLinear(float64(v)/255))
+		})
+	}
+}
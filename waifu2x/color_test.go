@@ -0,0 +1,130 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Truncated profile with a v4 description of Display P3.
+var p3Profile = append([]byte("\x00\x00\x02\x1cappl\x04\x00\x00\x00mntrRGB XYZ descmluc"), []byte("\x00D\x00i\x00s\x00p\x00l\x00a\x00y\x00 \x00P\x003")...)
+
+func p3Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	colors := []color.RGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255},
+		{200, 30, 120, 255}, {10, 180, 200, 255}, {128, 128, 128, 255}, {40, 40, 220, 255},
+	}
+	for i, c := range colors {
+		img.SetRGBA(i%4, i/4, c)
+	}
+	return img
+}
+
+func TestColorManagedP3(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p3.png")
+	if err := os.WriteFile(path, embedProfile(encodePNG(t, p3Image()), p3Profile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWaifu2x(writeModel(t, []Model{identityModel()}), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.colorSpace != displayP3 {
+		t.Fatal("the image isn't detected as Display P3")
+	}
+	w.ColorManaged = true
+
+	// The luma of the P3 green is computed from its luminance rather than
+	// the BT.601 coefficients of sRGB.
+	y, _ := w.luma(p3Image())
+	if g := y[0][1]; g < 215 || g > 220 {
+		t.Errorf("luma of the P3 green = %.1f, want about 218", g)
+	}
+
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	src := p3Image()
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			a := src.RGBAAt(x/2, y/2)
+			b := w.dst.RGBAAt(x, y)
+			for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)} {
+				if d < -2 || d > 2 {
+					t.Errorf("(%d, %d) = %v, want %v", x, y, b, a)
+				}
+			}
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "dst.png")
+	if err := w.SaveImage(out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(iccProfile(b), p3Profile) {
+		t.Error("the profile isn't preserved")
+	}
+}
+
+func TestJPEGProfile(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(4, 4)}
+	w.SetProfile(p3Profile)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "dst.jpg")
+	if err := w.SaveImage(out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(iccProfile(b), p3Profile) {
+		t.Error("the profile isn't preserved")
+	}
+}
+
+func TestExecLinear(t *testing.T) {
+	gray := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	draw.Draw(gray, gray.Bounds(), image.NewUniform(color.RGBA{128, 128, 128, 255}), image.Point{}, draw.Src)
+	w := &Waifu2x{models: []Model{identityModel()}, src: gray, Linear: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range w.Result().Pix {
+		if d := int(v) - 128; i%4 != 3 && (d < -1 || d > 1) {
+			t.Fatalf("got %d for mid-gray, want 128", v)
+		}
+	}
+
+	models := randomModel(rand.New(rand.NewSource(9)), 1, 4, 1)
+	gamma := &Waifu2x{models: models, src: testImage(9, 7)}
+	if err := gamma.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	linear := &Waifu2x{models: models, src: testImage(9, 7), Linear: true}
+	if err := linear.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if linear.Result().Bounds() != gamma.Result().Bounds() {
+		t.Fatalf("got bounds %v, want %v", linear.Result().Bounds(), gamma.Result().Bounds())
+	}
+	if bytes.Equal(linear.Result().Pix, gamma.Result().Pix) {
+		t.Error("got the same result in linear light")
+	}
+}
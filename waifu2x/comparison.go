@@ -0,0 +1,68 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/png"
+	"io"
+)
+
+var comparisonTemplate = template.Must(template.New("comparison").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>waifu2x comparison</title>
+<style>
+body { margin: 0; background: #222; color: #eee; font-family: sans-serif; }
+.compare { position: relative; display: inline-block; }
+.compare img { display: block; image-rendering: pixelated; }
+.after { position: absolute; top: 0; left: 0; width: 100%; height: 100%; overflow: hidden; clip-path: inset(0 0 0 50%); }
+input { width: {{.Width}}px; }
+</style>
+</head>
+<body>
+<div class="compare">
+<img src="{{.Before}}" width="{{.Width}}" height="{{.Height}}" alt="before">
+<div class="after" id="after"><img src="{{.After}}" width="{{.Width}}" height="{{.Height}}" alt="after"></div>
+</div>
+<div><input type="range" min="0" max="100" value="50" id="slider"></div>
+<script>
+document.getElementById("slider").addEventListener("input", function (e) {
+  document.getElementById("after").style.clipPath = "inset(0 0 0 " + e.target.value + "%)";
+});
+</script>
+</body>
+</html>
+`))
+
+func dataURL(img image.Image) (template.URL, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// WriteComparison writes a self-contained HTML page comparing the input
+// resized by nearest neighbor and the reconstructed image with a slider.
+// Exec must be called before.
+func (w *Waifu2x) WriteComparison(out io.Writer) error {
+	naive, err := w.naive()
+	if err != nil {
+		return err
+	}
+	before, err := dataURL(naive)
+	if err != nil {
+		return err
+	}
+	after, err := dataURL(w.dst)
+	if err != nil {
+		return err
+	}
+	return comparisonTemplate.Execute(out, struct {
+		Before, After template.URL
+		Width, Height int
+	}{before, after, w.dst.Bounds().Dx(), w.dst.Bounds().Dy()})
+}
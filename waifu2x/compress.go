@@ -0,0 +1,52 @@
+package waifu2x
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// maxModelSize bounds the size of a decompressed model, so that a small
+// crafted file can't exhaust the memory.
+const maxModelSize = 1 << 30
+
+// decompressModel decompresses gzip and zstd compressed models, detected by
+// their magic bytes, and returns other models as they are.
+func decompressModel(b []byte) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidModel, err)
+		}
+		defer zr.Close()
+		r = zr
+	case bytes.HasPrefix(b, zstdMagic):
+		zr, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidModel, err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return b, nil
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxModelSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidModel, err)
+	}
+	if len(out) > maxModelSize {
+		return nil, fmt.Errorf("%w: decompressed to more than %d bytes", ErrInvalidModel, maxModelSize)
+	}
+	return out, nil
+}
@@ -0,0 +1,60 @@
+package waifu2x
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressedModel(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 1)
+	b, err := json.Marshal(models)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newWriter := range map[string]func(io.Writer) (io.WriteCloser, error){
+		"model.json.gz": func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"model.json.zst": func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+	} {
+		var buf bytes.Buffer
+		zw, err := newWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := zw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewWaifu2x(path, "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(w.models, models) {
+			t.Errorf("%s: got models %v, want %v", name, w.models, models)
+		}
+
+		// A truncated stream is an invalid model.
+		if _, err := parseModel(buf.Bytes()[:buf.Len()/2]); !errors.Is(err, ErrInvalidModel) {
+			t.Errorf("%s: got %v for a truncated stream, want %v", name, err, ErrInvalidModel)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package waifu2x
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/lon9/mat"
+)
+
+// errSoftDeadline stops the network of a tile when SoftDeadline passes.
+var errSoftDeadline = errors.New("waifu2x: soft deadline passed")
+
+// pastDeadline is checked by the networks before each layer.
+func (w *Waifu2x) pastDeadline() bool {
+	return !w.SoftDeadline.IsZero() && time.Now().After(w.SoftDeadline)
+}
+
+// skippedTile returns the pixels of the tile in the padded plane, i.e. the
+// resized image without the model, for a tile stopped by SoftDeadline.
+func (w *Waifu2x) skippedTile(padded *mat.Matrix, padding, x0, y0, width, height int) *mat.Matrix {
+	atomic.StoreInt32(&w.approximate, 1)
+	rows := make([][]float32, height)
+	for y := range rows {
+		rows[y] = append([]float32(nil), padded.M[y0+padding+y][x0+padding:x0+padding+width]...)
+	}
+	return mat.NewMatrix(rows)
+}
@@ -0,0 +1,44 @@
+package waifu2x
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestExecSoftDeadline(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(12)), 1, 4, 1)
+	src := testImage(12, 8)
+	resized := &Waifu2x{models: []Model{identityModel()}, src: src, Interpolation: NearestNeighbor}
+	if err := resized.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Second)
+	for _, w := range []*Waifu2x{
+		{SoftDeadline: past},
+		{SoftDeadline: past, TileSize: 4},
+		{SoftDeadline: past, Half: true},
+		{SoftDeadline: past, Accumulate64: true},
+	} {
+		w.models, w.src, w.Interpolation = models, src, NearestNeighbor
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if !w.Stats().Approximate {
+			t.Error("the result isn't approximate after the deadline")
+		}
+		// The tiles are only resized, like by the identity.
+		if max, _, err := Compare(w.Result(), resized.Result()); err != nil || max > 1 {
+			t.Errorf("tile size %d: differs from the resized image by %d: %v", w.TileSize, max, err)
+		}
+	}
+
+	w := &Waifu2x{models: models, src: src, SoftDeadline: time.Now().Add(time.Hour)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Stats().Approximate {
+		t.Error("the result is approximate before the deadline")
+	}
+}
@@ -0,0 +1,14 @@
+package waifu2x
+
+import _ "embed"
+
+// defaultModel is the scale model used when no model is given, a gzip
+// compressed JSON model of 4 layers trained by default_model_gen.go on line
+// art it draws itself, so it's under the MIT license of this repository
+// with no third-party data. It is small enough to embed, but a model
+// trained on real images, like the vgg_7 models of waifu2x, gives better
+// results on photos.
+//
+//go:generate go run default_model_gen.go
+//go:embed default_model.json.gz
+var defaultModel []byte
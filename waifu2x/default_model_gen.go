@@ -0,0 +1,412 @@
+//go:build ignore
+
+// This program trains the default scale model of default.go and writes it to
+// default_model.json.gz. It draws random line art, shrinks it with
+// Downscale and trains the model to restore the luma from the nearest
+// neighbor and the bicubic upscale of the shrunk image, like Exec gives it.
+// The seed is fixed, so the model is the same on every run.
+//
+//	go run default_model_gen.go
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lon9/waifu2x-go/waifu2x"
+	"github.com/nfnt/resize"
+)
+
+// planes are the planes between the 3x3 layers.
+var planes = []int{1, 16, 16, 16, 1}
+
+const (
+	imageSize  = 128
+	images     = 256
+	patch      = 32
+	batch      = 4
+	iterations = 4000
+)
+
+func main() {
+	rng := rand.New(rand.NewSource(1))
+	var pairs []pair
+	for i := 0; i < images; i++ {
+		hr := drawArt(rng, imageSize, imageSize)
+		lr := waifu2x.Downscale(hr, 2)
+		target := luma(hr)
+		for _, f := range []resize.InterpolationFunction{resize.NearestNeighbor, resize.Bicubic} {
+			pairs = append(pairs, pair{luma(resize.Resize(uint(imageSize), uint(imageSize), lr, f)), target})
+		}
+	}
+
+	net := newNetwork(rng)
+	start := time.Now()
+	var loss float64
+	for it := 1; it <= iterations; it++ {
+		lr := 1e-3
+		if it > iterations*3/4 {
+			lr = 1e-4
+		}
+		net.zeroGrad()
+		for b := 0; b < batch; b++ {
+			in, target := pairs[rng.Intn(len(pairs))].crop(rng)
+			loss += net.step(in, target)
+		}
+		net.adam(lr, it)
+		if it%500 == 0 {
+			log.Printf("%d: loss %.6f, %v", it, loss/float64(500*batch), time.Since(start))
+			loss = 0
+		}
+	}
+
+	b, err := json.Marshal(net.models())
+	if err != nil {
+		log.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(b)
+	zw.Close()
+	if err := os.WriteFile("default_model.json.gz", buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type plane struct {
+	w, h int
+	v    []float32
+}
+
+func newPlane(w, h int) plane {
+	return plane{w, h, make([]float32, w*h)}
+}
+
+func luma(img image.Image) plane {
+	b := img.Bounds()
+	p := newPlane(b.Dx(), b.Dy())
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			l, _, _ := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+			p.v[y*p.w+x] = float32(l) / 255
+		}
+	}
+	return p
+}
+
+// pair is the upscaled luma given to the model and the luma it should
+// output.
+type pair struct {
+	in, target plane
+}
+
+func (p pair) crop(rng *rand.Rand) (plane, plane) {
+	field := len(planes) - 1
+	size := patch + 2*field
+	x0, y0 := rng.Intn(p.in.w-size+1), rng.Intn(p.in.h-size+1)
+	in, target := newPlane(size, size), newPlane(patch, patch)
+	for y := 0; y < size; y++ {
+		copy(in.v[y*size:(y+1)*size], p.in.v[(y0+y)*p.in.w+x0:])
+	}
+	for y := 0; y < patch; y++ {
+		copy(target.v[y*patch:(y+1)*patch], p.target.v[(y0+field+y)*p.target.w+x0+field:])
+	}
+	return in, target
+}
+
+// layer is a 3x3 convolution followed by the LeakyReLU of Exec.
+type layer struct {
+	in, out int
+	w, b    []float32 // [out][in][3][3] and [out]
+	gw, gb  []float32
+	mw, vw  []float32 // the moments of Adam
+	mb, vb  []float32
+}
+
+type network []*layer
+
+func newNetwork(rng *rand.Rand) network {
+	var net network
+	for i := 1; i < len(planes); i++ {
+		l := &layer{in: planes[i-1], out: planes[i]}
+		n := l.in * l.out * 9
+		l.w, l.gw, l.mw, l.vw = make([]float32, n), make([]float32, n), make([]float32, n), make([]float32, n)
+		l.b, l.gb, l.mb, l.vb = make([]float32, l.out), make([]float32, l.out), make([]float32, l.out), make([]float32, l.out)
+		std := math.Sqrt(2 / (1.01 * float64(l.in*9)))
+		for j := range l.w {
+			l.w[j] = float32(rng.NormFloat64() * std)
+		}
+		net = append(net, l)
+	}
+	return net
+}
+
+func (net network) zeroGrad() {
+	for _, l := range net {
+		for i := range l.gw {
+			l.gw[i] = 0
+		}
+		for i := range l.gb {
+			l.gb[i] = 0
+		}
+	}
+}
+
+// forward returns the pre-activations of the layer.
+func (l *layer) forward(in []plane) []plane {
+	w, h := in[0].w-2, in[0].h-2
+	out := make([]plane, l.out)
+	for o := range out {
+		out[o] = newPlane(w, h)
+		for i := range out[o].v {
+			out[o].v[i] = l.b[o]
+		}
+		for i, p := range in {
+			k := l.w[(o*l.in+i)*9:]
+			for ky := 0; ky < 3; ky++ {
+				for kx := 0; kx < 3; kx++ {
+					kv := k[ky*3+kx]
+					for y := 0; y < h; y++ {
+						src := p.v[(y+ky)*p.w+kx : (y+ky)*p.w+kx+w]
+						dst := out[o].v[y*w : (y+1)*w]
+						for x, v := range src {
+							dst[x] += kv * v
+						}
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+func activate(z []plane) []plane {
+	a := make([]plane, len(z))
+	for o, p := range z {
+		a[o] = newPlane(p.w, p.h)
+		for i, v := range p.v {
+			if v < 0 {
+				v *= 0.1
+			}
+			a[o].v[i] = v
+		}
+	}
+	return a
+}
+
+// backward accumulates the gradients of the layer from the gradient of its
+// pre-activations, and returns the gradient of its input.
+func (l *layer) backward(in []plane, gz []plane) []plane {
+	w, h := gz[0].w, gz[0].h
+	gin := make([]plane, l.in)
+	for i := range gin {
+		gin[i] = newPlane(in[i].w, in[i].h)
+	}
+	for o, g := range gz {
+		for _, v := range g.v {
+			l.gb[o] += v
+		}
+		for i, p := range in {
+			k := l.w[(o*l.in+i)*9:]
+			gk := l.gw[(o*l.in+i)*9:]
+			for ky := 0; ky < 3; ky++ {
+				for kx := 0; kx < 3; kx++ {
+					kv := k[ky*3+kx]
+					var sum float32
+					for y := 0; y < h; y++ {
+						src := p.v[(y+ky)*p.w+kx : (y+ky)*p.w+kx+w]
+						gsrc := gin[i].v[(y+ky)*p.w+kx : (y+ky)*p.w+kx+w]
+						for x, gv := range g.v[y*w : (y+1)*w] {
+							sum += gv * src[x]
+							gsrc[x] += gv * kv
+						}
+					}
+					gk[ky*3+kx] += sum
+				}
+			}
+		}
+	}
+	return gin
+}
+
+// step adds the gradients of the mean squared error of a patch and returns
+// the error.
+func (net network) step(in, target plane) float64 {
+	inputs := [][]plane{{in}}
+	var zs [][]plane
+	for _, l := range net {
+		z := l.forward(inputs[len(inputs)-1])
+		zs = append(zs, z)
+		inputs = append(inputs, activate(z))
+	}
+	out := inputs[len(inputs)-1][0]
+	g := newPlane(out.w, out.h)
+	var loss float64
+	n := float32(len(out.v) * batch)
+	for i, v := range out.v {
+		d := v - target.v[i]
+		loss += float64(d * d)
+		g.v[i] = 2 * d / n
+	}
+	gs := []plane{g}
+	for li := len(net) - 1; li >= 0; li-- {
+		for o, z := range zs[li] {
+			for i, v := range z.v {
+				if v < 0 {
+					gs[o].v[i] *= 0.1
+				}
+			}
+		}
+		gs = net[li].backward(inputs[li], gs)
+	}
+	return loss / float64(len(out.v))
+}
+
+func (net network) adam(lr float64, t int) {
+	const b1, b2, eps = 0.9, 0.999, 1e-8
+	c1 := 1 - math.Pow(b1, float64(t))
+	c2 := 1 - math.Pow(b2, float64(t))
+	update := func(p, g, m, v []float32) {
+		for i := range p {
+			m[i] = float32(b1*float64(m[i]) + (1-b1)*float64(g[i]))
+			v[i] = float32(b2*float64(v[i]) + (1-b2)*float64(g[i])*float64(g[i]))
+			p[i] -= float32(lr * float64(m[i]) / c1 / (math.Sqrt(float64(v[i])/c2) + eps))
+		}
+	}
+	for _, l := range net {
+		update(l.w, l.gw, l.mw, l.vw)
+		update(l.b, l.gb, l.mb, l.vb)
+	}
+}
+
+// models returns the layers in the JSON format of waifu2x, rounded to 6
+// digits.
+func (net network) models() []waifu2x.Model {
+	round := func(v float32) float32 {
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', 6, 32), 32)
+		return float32(f)
+	}
+	var models []waifu2x.Model
+	for _, l := range net {
+		m := waifu2x.Model{NInputPlane: l.in, NOutputPlane: l.out, KW: 3, KH: 3}
+		for o := 0; o < l.out; o++ {
+			m.Bias = append(m.Bias, round(l.b[o]))
+			var kernels [][][]float32
+			for i := 0; i < l.in; i++ {
+				k := l.w[(o*l.in+i)*9:]
+				kernels = append(kernels, [][]float32{
+					{round(k[0]), round(k[1]), round(k[2])},
+					{round(k[3]), round(k[4]), round(k[5])},
+					{round(k[6]), round(k[7]), round(k[8])},
+				})
+			}
+			m.Weight = append(m.Weight, kernels)
+		}
+		models = append(models, m)
+	}
+	return models
+}
+
+// drawArt draws flat shapes with dark outlines and strokes over a gradient,
+// supersampled 4 times per axis for antialiased edges.
+func drawArt(rng *rand.Rand, width, height int) *image.RGBA {
+	randColor := func() [3]float64 {
+		return [3]float64{rng.Float64(), rng.Float64(), rng.Float64()}
+	}
+	bg0, bg1 := randColor(), randColor()
+	angle := rng.Float64() * 2 * math.Pi
+	dx, dy := math.Cos(angle)/float64(width), math.Sin(angle)/float64(height)
+
+	var shapes []shape
+	for n := 6 + rng.Intn(10); n > 0; n-- {
+		s := shape{
+			kind:    rng.Intn(4),
+			cx:      rng.Float64() * float64(width),
+			cy:      rng.Float64() * float64(height),
+			rx:      4 + rng.Float64()*float64(width)/4,
+			ry:      4 + rng.Float64()*float64(height)/4,
+			angle:   rng.Float64() * math.Pi,
+			fill:    randColor(),
+			line:    [3]float64{rng.Float64() * 0.2, rng.Float64() * 0.2, rng.Float64() * 0.2},
+			outline: 0.5 + rng.Float64()*2.5,
+		}
+		if rng.Intn(4) == 0 {
+			s.outline = 0
+		}
+		shapes = append(shapes, s)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	const ss = 4
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [3]float64
+			for sy := 0; sy < ss; sy++ {
+				for sx := 0; sx < ss; sx++ {
+					px, py := float64(x)+(float64(sx)+0.5)/ss, float64(y)+(float64(sy)+0.5)/ss
+					t := math.Min(math.Max(0.5+(px-float64(width)/2)*dx+(py-float64(height)/2)*dy, 0), 1)
+					c := [3]float64{bg0[0] + t*(bg1[0]-bg0[0]), bg0[1] + t*(bg1[1]-bg0[1]), bg0[2] + t*(bg1[2]-bg0[2])}
+					for _, s := range shapes {
+						if col, ok := s.color(px, py); ok {
+							c = col
+						}
+					}
+					for k := range sum {
+						sum[k] += c[k]
+					}
+				}
+			}
+			img.SetRGBA(x, y, color.RGBA{
+				uint8(math.Round(255 * sum[0] / ss / ss)),
+				uint8(math.Round(255 * sum[1] / ss / ss)),
+				uint8(math.Round(255 * sum[2] / ss / ss)),
+				255,
+			})
+		}
+	}
+	return img
+}
+
+type shape struct {
+	kind           int // ellipse, rectangle, triangle or stroke
+	cx, cy, rx, ry float64
+	angle          float64
+	fill, line     [3]float64
+	outline        float64
+}
+
+// color returns the color of the shape at the point, if it covers it.
+func (s shape) color(px, py float64) ([3]float64, bool) {
+	sin, cos := math.Sincos(s.angle)
+	x, y := (px-s.cx)*cos+(py-s.cy)*sin, -(px-s.cx)*sin+(py-s.cy)*cos
+	var d float64 // signed distance to the border, negative inside
+	switch s.kind {
+	case 0:
+		r := math.Min(s.rx, s.ry)
+		d = (math.Hypot(x/s.rx, y/s.ry) - 1) * r
+	case 1:
+		d = math.Max(math.Abs(x)-s.rx, math.Abs(y)-s.ry)
+	case 2:
+		d = math.Max(math.Max(-y-s.ry/2, y*0.5+x*0.866-s.ry/2), y*0.5-x*0.866-s.ry/2)
+	case 3:
+		t := math.Min(math.Max(x, -s.rx), s.rx)
+		return s.line, math.Hypot(x-t, y) <= s.outline/2+0.25
+	}
+	switch {
+	case d <= -s.outline:
+		return s.fill, true
+	case d <= 0:
+		return s.line, true
+	}
+	return [3]float64{}, false
+}
@@ -0,0 +1,81 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestNewWaifu2xDefaultModel(t *testing.T) {
+	w, err := NewWaifu2x("", writeImage(t, 12, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if size := w.Result().Bounds().Size(); size != image.Pt(24, 18) {
+		t.Errorf("got %v, want (24,18)", size)
+	}
+}
+
+// grayArt draws antialiased gray rings and bars, unlike the drawings the
+// default model is trained on.
+func grayArt(size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	const ss = 4
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			var sum float64
+			for sy := 0; sy < ss; sy++ {
+				for sx := 0; sx < ss; sx++ {
+					px, py := float64(x)+(float64(sx)+0.5)/ss, float64(y)+(float64(sy)+0.5)/ss
+					v := 0.3 + 0.4*px/float64(size)
+					if r := math.Hypot(px-0.35*float64(size), py-0.4*float64(size)); r < 0.25*float64(size) {
+						v = 0.9
+						if r > 0.25*float64(size)-2 {
+							v = 0.1
+						}
+					}
+					if math.Abs(px+py-1.2*float64(size)) < 1.5 || (px > 0.6*float64(size) && px < 0.8*float64(size) && py > 0.55*float64(size) && py < 0.9*float64(size)) {
+						v = 0.15
+					}
+					sum += v
+				}
+			}
+			g := uint8(math.Round(255 * sum / ss / ss))
+			img.SetRGBA(x, y, color.RGBA{g, g, g, 255})
+		}
+	}
+	return img
+}
+
+func TestDefaultModelQuality(t *testing.T) {
+
+	// The default model restores a shrunk drawing better than the bicubic
+	// upscale alone.
+
+	hr := grayArt(96)
+	lr := Downscale(hr, 2)
+	psnr := func(w *Waifu2x) float64 {
+		w.SetImage(lr)
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		psnr, err := PSNR(w.Result(), hr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return psnr
+	}
+	w, err := NewWaifu2x("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := psnr(w)
+	bicubic := psnr(&Waifu2x{models: []Model{identityModel()}, Interpolation: Bicubic})
+	if got < bicubic+1 {
+		t.Errorf("PSNR of the default model = %.2f dB, want at least 1 dB above the %.2f dB of bicubic", got, bicubic)
+	}
+}
@@ -0,0 +1,70 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// Diff returns the absolute difference of the luma between the input resized
+// by nearest neighbor and the reconstructed image. It is scaled so that the
+// largest difference is white. Exec must be called before.
+func (w *Waifu2x) Diff() (*image.Gray, error) {
+	naive, err := w.naive()
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := w.dst.Bounds()
+	diff := make([]int, bounds.Dx()*bounds.Dy())
+	max := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			d := int(luma(w.dst.At(x, y))) - int(luma(naive.At(x, y)))
+			if d < 0 {
+				d = -d
+			}
+			if d > max {
+				max = d
+			}
+			diff[(y-bounds.Min.Y)*bounds.Dx()+x-bounds.Min.X] = d
+		}
+	}
+
+	res := image.NewGray(bounds)
+	if max == 0 {
+		return res, nil
+	}
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			res.Pix[y*res.Stride+x] = uint8(diff[y*bounds.Dx()+x] * 255 / max)
+		}
+	}
+	return res, nil
+}
+
+func (w *Waifu2x) naive() (*image.RGBA, error) {
+
+	// Resize the input by nearest neighbor to the output, which shows the
+	// image without the model.
+
+	if w.src == nil || w.dst == nil {
+		return nil, ErrEmptyImage
+	}
+	_, cw, ch, err := w.outputSize(w.src.Bounds().Dx(), w.src.Bounds().Dy())
+	if err != nil {
+		return nil, err
+	}
+	resized := resize.Resize(uint(cw), uint(ch), w.src, resize.NearestNeighbor)
+	naive := image.NewRGBA(resized.Bounds())
+	draw.Draw(naive, naive.Bounds(), resized, image.Point{}, draw.Src)
+	return w.fit(naive, cw, ch, nil), nil
+}
+
+func luma(c color.Color) uint8 {
+	r, g, b, _ := c.RGBA()
+	y, _, _ := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	return y
+}
@@ -0,0 +1,61 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func boxModel() Model {
+	k := float32(1.0 / 9)
+	return Model{
+		Weight: [][][][]float32{{{
+			{k, k, k},
+			{k, k, k},
+			{k, k, k},
+		}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{0},
+		NInputPlane:  1,
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	// Black on the left and white on the right, so the blur only changes
+	// pixels next to the edge at x = 8 of the output.
+	src := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 4; x < 8; x++ {
+			src.SetGray(x, y, color.Gray{255})
+		}
+	}
+	w := &Waifu2x{models: []Model{boxModel()}, src: src}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := w.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := diff.Bounds().Size(); size != image.Pt(16, 8) {
+		t.Fatalf("got size %v, want (16,8)", size)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			d := diff.GrayAt(x, y).Y
+			switch {
+			case x == 7 || x == 8:
+				if d < 200 {
+					t.Errorf("(%d, %d) = %d next to the edge, want bright", x, y, d)
+				}
+			case x < 6 || x > 9:
+				if d != 0 {
+					t.Errorf("(%d, %d) = %d in a flat region, want 0", x, y, d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package waifu2x
+
+import (
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+// DitherMode is flag for how the planes are quantized to 8 bits.
+type DitherMode int
+
+const (
+	// NoDither rounds the values by Rounding.
+	NoDither DitherMode = iota
+	// OrderedDither adds a 4x4 Bayer threshold map before rounding down.
+	OrderedDither
+	// ErrorDiffusion rounds the values and spreads the error to the next
+	// pixels (Floyd-Steinberg).
+	ErrorDiffusion
+)
+
+// RoundingMode is flag for how the planes are quantized to 8 bits with
+// NoDither.
+type RoundingMode int
+
+const (
+	// RoundNearest rounds the values to the nearest integer.
+	RoundNearest RoundingMode = iota
+	// Truncate rounds the values down, as earlier versions did, which
+	// darkens the image by half a level on average.
+	Truncate
+)
+
+var bayer4 = [4][4]float32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// dither quantizes the plane in [0, 255] to integers in place, so that the
+// conversion to uint8 doesn't truncate them.
+func dither(m *mat.Matrix, mode DitherMode) *mat.Matrix {
+	switch mode {
+	case OrderedDither:
+		for y, row := range m.M {
+			for x, v := range row {
+				t := (bayer4[y%4][x%4] + 0.5) / 16
+				row[x] = clamp255(float32(math.Floor(float64(v + t))))
+			}
+		}
+	case ErrorDiffusion:
+		for y, row := range m.M {
+			for x, v := range row {
+				q := clamp255(float32(math.Floor(float64(v) + 0.5)))
+				row[x] = q
+				e := v - q
+				if x+1 < len(row) {
+					row[x+1] += e * 7 / 16
+				}
+				if y+1 < len(m.M) {
+					next := m.M[y+1]
+					if x > 0 {
+						next[x-1] += e * 3 / 16
+					}
+					next[x] += e * 5 / 16
+					if x+1 < len(next) {
+						next[x+1] += e * 1 / 16
+					}
+				}
+			}
+		}
+	}
+	return m
+}
+
+func clamp255(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// roundPlane rounds the plane in [0, 255] to the nearest integers in place.
+func roundPlane(m *mat.Matrix) *mat.Matrix {
+	for _, row := range m.M {
+		for x, v := range row {
+			row[x] = float32(math.Round(float64(v)))
+		}
+	}
+	return m
+}
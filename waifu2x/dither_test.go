@@ -0,0 +1,118 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/lon9/mat"
+)
+
+func TestDither(t *testing.T) {
+	for _, mode := range []DitherMode{OrderedDither, ErrorDiffusion} {
+		y := make([][]float32, 16)
+		var sum float64
+		for i := range y {
+			y[i] = make([]float32, 64)
+			for j := range y[i] {
+				y[i][j] = 100 + float32(j)/64
+				sum += float64(y[i][j])
+			}
+		}
+		out := dither(mat.NewMatrix(y), mode)
+
+		var got float64
+		for _, row := range out.M {
+			for _, v := range row {
+				if v != 100 && v != 101 {
+					t.Fatalf("mode %d: got %v, want 100 or 101", mode, v)
+				}
+				got += float64(v)
+			}
+		}
+		// The dithered plane keeps the mean of the gradient.
+		if d := math.Abs(got-sum) / (16 * 64); d > 0.05 {
+			t.Errorf("mode %d: the mean is off by %v", mode, d)
+		}
+	}
+}
+
+func TestExecDither(t *testing.T) {
+
+	// The model darkens a gradient by 8, so truncation leaves flat bands
+	// of 8 columns.
+
+	src := image.NewGray(image.Rect(0, 0, 64, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 64; x++ {
+			src.SetGray(x, y, color.Gray{uint8(x * 4)})
+		}
+	}
+	dark := identityModel()
+	dark.Weight[0][0][1][1] = 1.0 / 8
+
+	distinct := func(mode DitherMode) int {
+		w := &Waifu2x{models: []Model{dark}, src: src, Denoise: true, Dither: mode, Rounding: Truncate}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		values := map[uint8]bool{}
+		for y := 0; y < 8; y++ {
+			for x := 16; x < 24; x++ {
+				values[w.Result().RGBAAt(x, y).R] = true
+			}
+		}
+		return len(values)
+	}
+	flat := distinct(NoDither)
+	for _, mode := range []DitherMode{OrderedDither, ErrorDiffusion} {
+		if n := distinct(mode); n <= flat {
+			t.Errorf("mode %d: got %d distinct values in a flat band, want more than %d", mode, n, flat)
+		}
+	}
+}
+
+func TestExecRounding(t *testing.T) {
+	scaled := func(src image.Image, k float32, rounding RoundingMode) *image.RGBA {
+		m := identityModel()
+		m.Weight[0][0][1][1] = k
+		w := &Waifu2x{models: []Model{m}, src: src, Denoise: true, Rounding: rounding}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result()
+	}
+
+	// 200 is scaled to 127.9.
+	flat := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range flat.Pix {
+		flat.Pix[i] = 200
+	}
+	flat.Pix[0] = 0 // not a uniform luma, which isn't given to the model
+	if got := scaled(flat, 127.9/200, RoundNearest).RGBAAt(2, 2).R; got != 128 {
+		t.Errorf("rounded 127.9 to %d, want 128", got)
+	}
+	if got := scaled(flat, 127.9/200, Truncate).RGBAAt(2, 2).R; got != 127 {
+		t.Errorf("truncated 127.9 to %d, want 127", got)
+	}
+
+	// Truncation darkens a gradient by about half a level.
+	gradient := image.NewGray(image.Rect(0, 0, 64, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 64; x++ {
+			gradient.SetGray(x, y, color.Gray{uint8(x * 4)})
+		}
+	}
+	mean := func(img *image.RGBA) float64 {
+		var sum float64
+		for i := 0; i < len(img.Pix); i += 4 {
+			sum += float64(img.Pix[i])
+		}
+		return sum / float64(len(img.Pix)/4)
+	}
+	rounded, truncated := mean(scaled(gradient, 0.7, RoundNearest)), mean(scaled(gradient, 0.7, Truncate))
+	if d := rounded - truncated; d < 0.3 || d > 0.7 {
+		t.Errorf("the rounded image is brighter by %v, want about 0.5", d)
+	}
+}
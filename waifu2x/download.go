@@ -0,0 +1,180 @@
+package waifu2x
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDownloadRetries is the number of retries of a failed download
+// unless WithDownloadRetry is given.
+const defaultDownloadRetries = 3
+
+// downloadBackoff is the wait before the first retry. It doubles at each
+// retry unless the server sends Retry-After.
+var downloadBackoff = 500 * time.Millisecond
+
+// WithDownloadRetry sets how many times a model download failing with a
+// network error, 429 or a 5xx status is retried, and the timeout of the
+// download including all the retries. A zero timeout means no timeout.
+func WithDownloadRetry(retries int, timeout time.Duration) Option {
+	return func(w *Waifu2x) {
+		w.downloadRetries = retries
+		w.downloadTimeout = timeout
+	}
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+func (w *Waifu2x) verifyModel(b []byte) error {
+	if w.modelSHA256 == "" {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	if actual := hex.EncodeToString(sum[:]); actual != w.modelSHA256 {
+		return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, actual, w.modelSHA256)
+	}
+	return nil
+}
+
+func (w *Waifu2x) modelCachePath(url string) (string, error) {
+	dir := w.cacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(userDir, "waifu2x-go")
+	}
+	key := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, hex.EncodeToString(key[:])+filepath.Ext(url)), nil
+}
+
+func (w *Waifu2x) downloadModel(url string) ([]byte, error) {
+
+	// Download the model, or read it from the cache. The verified checksum
+	// is stored next to the cached model so that it is not hashed again.
+
+	path, err := w.modelCachePath(url)
+	if err != nil {
+		return nil, err
+	}
+	if b, err := ioutil.ReadFile(path); err == nil {
+		sum, err := ioutil.ReadFile(path + ".sha256")
+		if w.modelSHA256 == "" || (err == nil && string(sum) == w.modelSHA256) {
+			return b, nil
+		}
+	}
+
+	b, err := w.fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.verifyModel(b); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := w.writeFile(path, b, 0644); err != nil {
+		return nil, err
+	}
+	if w.modelSHA256 != "" {
+		if err := w.writeFile(path+".sha256", []byte(w.modelSHA256), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (w *Waifu2x) fetch(url string) ([]byte, error) {
+	ctx := context.Background()
+	if w.downloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.downloadTimeout)
+		defer cancel()
+	}
+
+	backoff := downloadBackoff
+	for retry := 0; ; retry++ {
+		b, wait, err := get(ctx, url)
+		if err == nil {
+			return b, nil
+		}
+		if wait < 0 || retry >= w.downloadRetries {
+			return nil, err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("waifu2x: downloading %s: %v after %v", url, ctx.Err(), err)
+		}
+	}
+}
+
+// get downloads url once. On failure, wait is negative when the request
+// shouldn't be retried, the Retry-After of the response if any, or zero.
+func get(ctx context.Context, url string) ([]byte, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, err
+		}
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err := fmt.Errorf("waifu2x: downloading %s: %s", url, res.Status)
+		switch {
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+			return nil, retryAfter(res.Header.Get("Retry-After")), err
+		case res.StatusCode >= 500:
+			return nil, 0, err
+		}
+		return nil, -1, err
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, 0, nil
+}
+
+// retryAfter parses Retry-After given in seconds or as a date. It returns
+// zero when the header is missing or invalid, and at least a nanosecond
+// otherwise so that an immediate retry is not taken for the backoff.
+func retryAfter(v string) time.Duration {
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	} else {
+		return 0
+	}
+	if d <= 0 {
+		return time.Nanosecond
+	}
+	return d
+}
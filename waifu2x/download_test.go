@@ -0,0 +1,127 @@
+package waifu2x
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDownloadModel(t *testing.T) {
+	b, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		rw.Write(b)
+	}))
+	defer ts.Close()
+
+	img := writeImage(t, 4, 4)
+	cacheDir := t.TempDir()
+	url := ts.URL + "/scale2.0x_model.json"
+
+	if _, err := NewWaifu2x(url, img, WithCacheDir(cacheDir), WithModelSHA256(hash[1:]+"0")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("got %v, want ErrChecksumMismatch", err)
+	}
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("model with a mismatched checksum is cached: %v", entries)
+	}
+
+	for i := 0; i < 2; i++ {
+		w, err := NewWaifu2x(url, img, WithCacheDir(cacheDir), WithModelSHA256(hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(w.models) != 1 {
+			t.Errorf("got %d layers, want 1", len(w.models))
+		}
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want 2 (the last load should be cached)", requests)
+	}
+}
+
+func TestDownloadModelRetry(t *testing.T) {
+	defer func(d time.Duration) { downloadBackoff = d }(downloadBackoff)
+	downloadBackoff = time.Millisecond
+
+	b, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			rw.Header().Set("Retry-After", "0")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			rw.WriteHeader(http.StatusInternalServerError)
+		default:
+			rw.Write(b)
+		}
+	}))
+	defer ts.Close()
+
+	img := writeImage(t, 4, 4)
+	url := ts.URL + "/scale2.0x_model.json"
+	if _, err := NewWaifu2x(url, img, WithCacheDir(t.TempDir()), WithDownloadRetry(1, 0)); err == nil {
+		t.Error("download succeeded with too few retries")
+	}
+
+	requests = 0
+	w, err := NewWaifu2x(url, img, WithCacheDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.models) != 1 {
+		t.Errorf("got %d layers, want 1", len(w.models))
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+}
+
+func TestDownloadModelNotFound(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(rw, r)
+	}))
+	defer ts.Close()
+
+	if _, err := NewWaifu2x(ts.URL+"/model.json", writeImage(t, 4, 4), WithCacheDir(t.TempDir())); err == nil {
+		t.Error("got no error")
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1 (404 should not be retried)", requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"":    0,
+		"abc": 0,
+		"0":   time.Nanosecond,
+		"2":   2 * time.Second,
+	} {
+		if got := retryAfter(v); got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", v, got, want)
+		}
+	}
+	if got := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("got %v for a date an hour later", got)
+	}
+}
@@ -0,0 +1,29 @@
+package waifu2x
+
+import "errors"
+
+var (
+	// ErrUnsupportedFormat is returned when the image format is not supported.
+	ErrUnsupportedFormat = errors.New("waifu2x: unsupported image format")
+	// ErrInvalidModel is returned when the model can't be loaded or applied.
+	ErrInvalidModel = errors.New("waifu2x: invalid model")
+	// ErrImageTooLarge is returned when the image exceeds MaxPixels or
+	// MaxOutputDim.
+	ErrImageTooLarge = errors.New("waifu2x: image too large")
+	// ErrEmptyImage is returned when there is no image or it has no pixels.
+	ErrEmptyImage = errors.New("waifu2x: empty image")
+	// ErrInvalidBounds is returned when the destination image doesn't have
+	// the bounds of the output.
+	ErrInvalidBounds = errors.New("waifu2x: invalid destination bounds")
+	// ErrSizeMismatch is returned when compared images have different sizes.
+	ErrSizeMismatch = errors.New("waifu2x: image sizes differ")
+	// ErrChecksumMismatch is returned when the model doesn't match the
+	// expected checksum.
+	ErrChecksumMismatch = errors.New("waifu2x: model checksum mismatch")
+	// ErrOutOfMemory is returned when a tile can't be allocated, even after
+	// retrying with smaller tiles.
+	ErrOutOfMemory = errors.New("waifu2x: out of memory")
+	// ErrUnsupportedProfile is returned when SRGBOutput is set and the
+	// result can't be converted from the ICC profile of the input.
+	ErrUnsupportedProfile = errors.New("waifu2x: unsupported ICC profile")
+)
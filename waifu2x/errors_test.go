@@ -0,0 +1,154 @@
+package waifu2x
+
+import (
+	"encoding/json"
+	"errors"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func writeModel(t *testing.T, models []Model) string {
+	t.Helper()
+	b, err := json.Marshal(models)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeImage(t *testing.T, width, height int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "image.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, testImage(width, height)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestErrUnsupportedFormat(t *testing.T) {
+	model := writeModel(t, []Model{identityModel()})
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWaifu2x(model, path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+	if _, _, err := ReadImage(path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("ReadImage: got %v, want ErrUnsupportedFormat", err)
+	}
+
+	w, err := NewWaifu2x(model, writeImage(t, 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SaveImage(filepath.Join(t.TempDir(), "dst.bmp")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestErrInvalidModel(t *testing.T) {
+	img := writeImage(t, 4, 4)
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewWaifu2x(path, img)
+	if !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("got %v, want ErrInvalidModel", err)
+	}
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("got %v, want the cause to be *json.SyntaxError", err)
+	}
+
+	// An empty model would leave the image upscaled without the model.
+	if err := os.WriteFile(path, []byte("[]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWaifu2x(path, img); !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("got %v for an empty model, want ErrInvalidModel", err)
+	}
+
+	// Two output planes can't be converted back to an image.
+	m := identityModel()
+	m.Weight = append(m.Weight, m.Weight[0])
+	m.Bias = append(m.Bias, 0)
+	m.NOutputPlane = 2
+	w, err := NewWaifu2x(writeModel(t, []Model{m}), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("got %v, want ErrInvalidModel", err)
+	}
+
+	// An RGB model of 3 input planes fails before the convolutions with a
+	// message telling why.
+	rgb := randomModel(rand.New(rand.NewSource(1)), 3, 4, 1)
+	w, err = NewWaifu2x(writeModel(t, rgb), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Exec()
+	if !errors.Is(err, ErrInvalidModel) || !strings.Contains(err.Error(), "takes 3 input planes") {
+		t.Errorf("got %v, want ErrInvalidModel about the 3 input planes", err)
+	}
+}
+
+func TestErrImageTooLarge(t *testing.T) {
+	w, err := NewWaifu2x(writeModel(t, []Model{identityModel()}), writeImage(t, 4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.MaxPixels = 63
+	if err := w.Exec(); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+	w.MaxPixels = 64
+	if err := w.Exec(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaxOutputDim(t *testing.T) {
+
+	// 4x1 scaled by 2^20 is within MaxPixels, but far too wide.
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(4, 1), Passes: 20, MaxPixels: 1 << 50, MaxOutputDim: 4096}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := w.Exec()
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("allocated %d bytes before failing", n)
+	}
+
+	w.Passes, w.MaxOutputDim = 2, 16
+	if err := w.Exec(); err != nil {
+		t.Error(err)
+	}
+	w.MaxOutputDim = 15
+	if err := w.Exec(); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v for 16x4 with a limit of 15, want ErrImageTooLarge", err)
+	}
+}
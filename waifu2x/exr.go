@@ -0,0 +1,464 @@
+package waifu2x
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// exrMagic is the magic number at the start of OpenEXR files.
+const exrMagic = "v/1\x01"
+
+// OpenEXR compression methods.
+const (
+	exrNone = 0
+	exrZIPS = 2
+	exrZIP  = 3
+)
+
+// OpenEXR pixel types.
+const (
+	exrUint  = 0
+	exrHalf  = 1
+	exrFloat = 2
+)
+
+func init() {
+	image.RegisterFormat("exr", exrMagic, func(r io.Reader) (image.Image, error) {
+		return DecodeEXR(r)
+	}, decodeEXRConfig)
+}
+
+// FloatImage is an in-memory image of linear, premultiplied RGBA float32
+// values, which may exceed 1. At returns the tone mapped sRGB colors, so it
+// can be used where an 8-bit image is expected.
+type FloatImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewFloatImage returns a new FloatImage with the given bounds.
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// ColorModel returns color.RGBA64Model.
+func (p *FloatImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Bounds returns the bounds of the image.
+func (p *FloatImage) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// PixOffset returns the index of the first element of Pix of the pixel at
+// (x, y).
+func (p *FloatImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// At returns the tone mapped color of the pixel at (x, y).
+func (p *FloatImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.RGBA64{}
+	}
+	i := p.PixOffset(x, y)
+	a := clamp01(float64(p.Pix[i+3]))
+	if a == 0 {
+		return color.RGBA64{}
+	}
+	var c [3]uint16
+	for k := range c {
+		c[k] = uint16(math.Round(toneMap(float64(p.Pix[i+k])/a) * a * 0xffff))
+	}
+	return color.RGBA64{c[0], c[1], c[2], uint16(math.Round(a * 0xffff))}
+}
+
+// toneMap maps a linear value in [0, inf) to a gamma encoded value in
+// [0, 1). It is inverted by inverseToneMap.
+func toneMap(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+	return fromLinear(v / (1 + v))
+}
+
+func inverseToneMap(v float64) float64 {
+	l := math.Min(toLinear(v), hdrMaxLinear)
+	if l <= 0 {
+		return 0
+	}
+	return l / (1 - l)
+}
+
+// hdrMaxLinear bounds the tone mapped linear value in inverseToneMap, which
+// is infinite at 1.
+const hdrMaxLinear = 1 - 1.0/(1<<16)
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+type exrChannel struct {
+	name      string
+	pixelType int32
+}
+
+type exrHeader struct {
+	channels    []exrChannel
+	compression byte
+	dataWindow  image.Rectangle
+}
+
+func exrPixelSize(pixelType int32) int {
+	if pixelType == exrHalf {
+		return 2
+	}
+	return 4
+}
+
+func exrLinesPerChunk(compression byte) int {
+	if compression == exrZIP {
+		return 16
+	}
+	return 1
+}
+
+func readEXRHeader(b []byte) (*exrHeader, int, error) {
+
+	// Read the attributes up to the empty name. Only single part scan line
+	// images are supported.
+
+	if len(b) < 8 || string(b[:4]) != exrMagic {
+		return nil, 0, fmt.Errorf("%w: not an OpenEXR file", ErrUnsupportedFormat)
+	}
+	version := binary.LittleEndian.Uint32(b[4:])
+	if version&0xff != 2 || version&0x1a00 != 0 {
+		return nil, 0, fmt.Errorf("%w: OpenEXR version %#x", ErrUnsupportedFormat, version)
+	}
+
+	var h exrHeader
+	hasWindow := false
+	off := 8
+	readString := func() (string, error) {
+		i := bytes.IndexByte(b[off:], 0)
+		if i < 0 {
+			return "", fmt.Errorf("%w: truncated OpenEXR header", ErrUnsupportedFormat)
+		}
+		s := string(b[off : off+i])
+		off += i + 1
+		return s, nil
+	}
+	for {
+		name, err := readString()
+		if err != nil {
+			return nil, 0, err
+		}
+		if name == "" {
+			break
+		}
+		typ, err := readString()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(b)-off < 4 {
+			return nil, 0, fmt.Errorf("%w: truncated OpenEXR header", ErrUnsupportedFormat)
+		}
+		size := int(int32(binary.LittleEndian.Uint32(b[off:])))
+		off += 4
+		if size < 0 || len(b)-off < size {
+			return nil, 0, fmt.Errorf("%w: truncated OpenEXR header", ErrUnsupportedFormat)
+		}
+		value := b[off : off+size]
+		off += size
+
+		switch {
+		case name == "channels" && typ == "chlist":
+			for len(value) > 1 {
+				i := bytes.IndexByte(value, 0)
+				if i < 0 || len(value)-i-1 < 16 {
+					return nil, 0, fmt.Errorf("%w: invalid OpenEXR channel list", ErrUnsupportedFormat)
+				}
+				c := exrChannel{name: string(value[:i])}
+				value = value[i+1:]
+				c.pixelType = int32(binary.LittleEndian.Uint32(value))
+				xs := binary.LittleEndian.Uint32(value[8:])
+				ys := binary.LittleEndian.Uint32(value[12:])
+				if c.pixelType < exrUint || c.pixelType > exrFloat || xs != 1 || ys != 1 {
+					return nil, 0, fmt.Errorf("%w: OpenEXR channel %s", ErrUnsupportedFormat, c.name)
+				}
+				h.channels = append(h.channels, c)
+				value = value[16:]
+			}
+		case name == "compression" && typ == "compression" && size == 1:
+			h.compression = value[0]
+		case name == "dataWindow" && typ == "box2i" && size == 16:
+			var box [4]int32
+			for i := range box {
+				box[i] = int32(binary.LittleEndian.Uint32(value[i*4:]))
+			}
+			h.dataWindow = image.Rect(int(box[0]), int(box[1]), int(box[2])+1, int(box[3])+1)
+			hasWindow = true
+		}
+	}
+
+	switch h.compression {
+	case exrNone, exrZIPS, exrZIP:
+	default:
+		return nil, 0, fmt.Errorf("%w: OpenEXR compression %d", ErrUnsupportedFormat, h.compression)
+	}
+	if !hasWindow || len(h.channels) == 0 {
+		return nil, 0, fmt.Errorf("%w: OpenEXR header without data window or channels", ErrUnsupportedFormat)
+	}
+	return &h, off, nil
+}
+
+func decodeEXRConfig(r io.Reader) (image.Config, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	h, _, err := readEXRHeader(b)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBA64Model,
+		Width:      h.dataWindow.Dx(),
+		Height:     h.dataWindow.Dy(),
+	}, nil
+}
+
+// DecodeEXR decodes a single part scan line OpenEXR image, uncompressed or
+// compressed by ZIP. The R, G, B and A channels are read, or Y for gray
+// images, and the other channels are ignored.
+func DecodeEXR(r io.Reader) (*FloatImage, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	h, off, err := readEXRHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := h.dataWindow.Dx(), h.dataWindow.Dy()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("%w: %dx%d", ErrEmptyImage, width, height)
+	}
+	lineSize := 0
+	for _, c := range h.channels {
+		lineSize += exrPixelSize(c.pixelType)
+	}
+	lineSize *= width
+
+	// zlib can't inflate data more than 1032 times, so a crafted header
+	// can't make the decoder allocate much more than the file size.
+	if float64(lineSize)*float64(height) > 1032*float64(len(b)) {
+		return nil, fmt.Errorf("%w: OpenEXR data window %v exceeds the data", ErrUnsupportedFormat, h.dataWindow)
+	}
+	lines := exrLinesPerChunk(h.compression)
+	chunks := (height + lines - 1) / lines
+	if len(b)-off < chunks*8 {
+		return nil, fmt.Errorf("%w: truncated OpenEXR offset table", ErrUnsupportedFormat)
+	}
+
+	// Each channel goes to the index of its component in the pixel, or is
+	// skipped.
+	index := make([]int, len(h.channels))
+	gray := false
+	for i, c := range h.channels {
+		switch c.name {
+		case "R":
+			index[i] = 0
+		case "G":
+			index[i] = 1
+		case "B":
+			index[i] = 2
+		case "A":
+			index[i] = 3
+		case "Y":
+			index[i] = 0
+			gray = true
+		default:
+			index[i] = -1
+		}
+	}
+
+	img := NewFloatImage(image.Rect(0, 0, width, height))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 1
+	}
+	for i := 0; i < chunks; i++ {
+		pos := binary.LittleEndian.Uint64(b[off+i*8:])
+		if pos > uint64(len(b)-8) {
+			return nil, fmt.Errorf("%w: invalid OpenEXR chunk offset", ErrUnsupportedFormat)
+		}
+		y := int(int32(binary.LittleEndian.Uint32(b[pos:]))) - h.dataWindow.Min.Y
+		size := int(int32(binary.LittleEndian.Uint32(b[pos+4:])))
+		if y < 0 || y >= height || size < 0 || uint64(size) > uint64(len(b))-pos-8 {
+			return nil, fmt.Errorf("%w: invalid OpenEXR chunk", ErrUnsupportedFormat)
+		}
+		n := lines
+		if height-y < n {
+			n = height - y
+		}
+		data, err := exrUncompress(b[pos+8:pos+8+uint64(size)], n*lineSize, h.compression)
+		if err != nil {
+			return nil, err
+		}
+
+		for l := 0; l < n; l++ {
+			for c, ch := range h.channels {
+				ps := exrPixelSize(ch.pixelType)
+				for x := 0; x < width; x++ {
+					v := exrValue(data[x*ps:], ch.pixelType)
+					if index[c] >= 0 {
+						img.Pix[img.PixOffset(x, y+l)+index[c]] = v
+					}
+				}
+				data = data[width*ps:]
+			}
+		}
+	}
+	if gray {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i+1], img.Pix[i+2] = img.Pix[i], img.Pix[i]
+		}
+	}
+	return img, nil
+}
+
+func exrUncompress(b []byte, size int, compression byte) ([]byte, error) {
+
+	// Chunks which don't get smaller by compression are stored as is.
+
+	if compression == exrNone || len(b) == size {
+		if len(b) != size {
+			return nil, fmt.Errorf("%w: invalid OpenEXR chunk size", ErrUnsupportedFormat)
+		}
+		return b, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	t := make([]byte, size)
+	if _, err := io.ReadFull(zr, t); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+
+	// Undo the predictor, then interleave the two halves of the bytes.
+	for i := 1; i < len(t); i++ {
+		t[i] = t[i-1] + t[i] - 128
+	}
+	res := make([]byte, size)
+	half := (size + 1) / 2
+	for i := range res {
+		if i%2 == 0 {
+			res[i] = t[i/2]
+		} else {
+			res[i] = t[half+i/2]
+		}
+	}
+	return res, nil
+}
+
+func exrValue(b []byte, pixelType int32) float32 {
+	switch pixelType {
+	case exrHalf:
+		return halfToFloat(binary.LittleEndian.Uint16(b))
+	case exrFloat:
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+	return float32(binary.LittleEndian.Uint32(b))
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := int(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	case exp == 0:
+		// Zero or subnormal.
+		v := float32(frac) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | uint32(exp+127-15)<<23 | frac<<13)
+}
+
+// EncodeEXR writes the image as an uncompressed OpenEXR image of float
+// R, G, B and A channels.
+func EncodeEXR(w io.Writer, img *FloatImage) error {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	b := []byte(exrMagic)
+	b = binary.LittleEndian.AppendUint32(b, 2)
+
+	attr := func(name, typ string, value []byte) {
+		b = append(b, name...)
+		b = append(b, 0)
+		b = append(b, typ...)
+		b = append(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+		b = append(b, value...)
+	}
+	// The channels are stored in alphabetical order.
+	var chlist []byte
+	for _, name := range []string{"A", "B", "G", "R"} {
+		chlist = append(chlist, name...)
+		chlist = append(chlist, 0)
+		chlist = binary.LittleEndian.AppendUint32(chlist, exrFloat)
+		chlist = append(chlist, 0, 0, 0, 0)
+		chlist = binary.LittleEndian.AppendUint32(chlist, 1)
+		chlist = binary.LittleEndian.AppendUint32(chlist, 1)
+	}
+	chlist = append(chlist, 0)
+	var box []byte
+	for _, v := range []int{0, 0, width - 1, height - 1} {
+		box = binary.LittleEndian.AppendUint32(box, uint32(int32(v)))
+	}
+	attr("channels", "chlist", chlist)
+	attr("compression", "compression", []byte{exrNone})
+	attr("dataWindow", "box2i", box)
+	attr("displayWindow", "box2i", box)
+	attr("lineOrder", "lineOrder", []byte{0})
+	attr("pixelAspectRatio", "float", binary.LittleEndian.AppendUint32(nil, math.Float32bits(1)))
+	attr("screenWindowCenter", "v2f", make([]byte, 8))
+	attr("screenWindowWidth", "float", binary.LittleEndian.AppendUint32(nil, math.Float32bits(1)))
+	b = append(b, 0)
+
+	lineSize := 4 * 4 * width
+	pos := uint64(len(b) + 8*height)
+	for y := 0; y < height; y++ {
+		b = binary.LittleEndian.AppendUint64(b, pos)
+		pos += uint64(8 + lineSize)
+	}
+	for y := 0; y < height; y++ {
+		b = binary.LittleEndian.AppendUint32(b, uint32(y))
+		b = binary.LittleEndian.AppendUint32(b, uint32(lineSize))
+		for _, k := range []int{3, 2, 1, 0} {
+			for x := 0; x < width; x++ {
+				v := img.Pix[img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)+k]
+				b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+			}
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
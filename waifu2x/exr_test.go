@@ -0,0 +1,143 @@
+package waifu2x
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func zipEXR(b []byte) []byte {
+	t := make([]byte, len(b))
+	half := (len(b) + 1) / 2
+	for i := range b {
+		if i%2 == 0 {
+			t[i/2] = b[i]
+		} else {
+			t[half+i/2] = b[i]
+		}
+	}
+	for i := len(t) - 1; i > 0; i-- {
+		t[i] = t[i] - t[i-1] + 128
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(t)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// testEXR returns a ZIP compressed OpenEXR image of half B, G and R
+// channels, where the red of the pixel at (x, y) is x+y.
+func testEXR(width, height int) []byte {
+	b := []byte(exrMagic)
+	b = binary.LittleEndian.AppendUint32(b, 2)
+	attr := func(name, typ string, value []byte) {
+		b = append(b, name+"\x00"+typ+"\x00"...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+		b = append(b, value...)
+	}
+	var chlist []byte
+	for _, name := range []string{"B", "G", "R"} {
+		chlist = append(chlist, name+"\x00"...)
+		chlist = binary.LittleEndian.AppendUint32(chlist, exrHalf)
+		chlist = append(chlist, 0, 0, 0, 0)
+		chlist = binary.LittleEndian.AppendUint32(chlist, 1)
+		chlist = binary.LittleEndian.AppendUint32(chlist, 1)
+	}
+	var box []byte
+	for _, v := range []int{0, 0, width - 1, height - 1} {
+		box = binary.LittleEndian.AppendUint32(box, uint32(v))
+	}
+	attr("channels", "chlist", append(chlist, 0))
+	attr("compression", "compression", []byte{exrZIP})
+	attr("dataWindow", "box2i", box)
+	attr("displayWindow", "box2i", box)
+	b = append(b, 0)
+
+	var chunks [][]byte
+	for y0 := 0; y0 < height; y0 += 16 {
+		var raw []byte
+		for y := y0; y < y0+16 && y < height; y++ {
+			for _, c := range []float32{0.25, 0.5} {
+				for x := 0; x < width; x++ {
+					raw = binary.LittleEndian.AppendUint16(raw, floatToHalf(c))
+				}
+			}
+			for x := 0; x < width; x++ {
+				raw = binary.LittleEndian.AppendUint16(raw, floatToHalf(float32(x+y)))
+			}
+		}
+		chunk := binary.LittleEndian.AppendUint32(nil, uint32(y0))
+		data := zipEXR(raw)
+		chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+		chunks = append(chunks, append(chunk, data...))
+	}
+	pos := len(b) + 8*len(chunks)
+	for _, c := range chunks {
+		b = binary.LittleEndian.AppendUint64(b, uint64(pos))
+		pos += len(c)
+	}
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func TestDecodeEXR(t *testing.T) {
+	img, format, err := image.Decode(bytes.NewReader(testEXR(5, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "exr" {
+		t.Errorf("got format %q, want exr", format)
+	}
+	f := img.(*FloatImage)
+	if f.Bounds() != image.Rect(0, 0, 5, 20) {
+		t.Fatalf("got bounds %v", f.Bounds())
+	}
+	for _, p := range []image.Point{{0, 0}, {4, 3}, {2, 19}} {
+		got := f.Pix[f.PixOffset(p.X, p.Y):][:4]
+		want := []float32{float32(p.X + p.Y), 0.5, 0.25, 1}
+		for k := range want {
+			if got[k] != want[k] {
+				t.Errorf("%v: got %v, want %v", p, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestEncodeEXR(t *testing.T) {
+	img := NewFloatImage(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = float32(i) / 4
+	}
+	var buf bytes.Buffer
+	if err := EncodeEXR(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeEXR(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Rect != img.Rect {
+		t.Fatalf("got bounds %v, want %v", got.Rect, img.Rect)
+	}
+	for i := range img.Pix {
+		if got.Pix[i] != img.Pix[i] {
+			t.Fatalf("got %v, want %v", got.Pix, img.Pix)
+		}
+	}
+}
+
+func TestDecodeEXRConfig(t *testing.T) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(testEXR(7, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "exr" || config.Width != 7 || config.Height != 3 {
+		t.Errorf("got %s %dx%d, want exr 7x3", format, config.Width, config.Height)
+	}
+}
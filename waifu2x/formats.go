@@ -0,0 +1,92 @@
+package waifu2x
+
+import (
+	"image"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Format is an image format registered by RegisterFormat.
+type Format struct {
+	// Name is the name of the format given to image.RegisterFormat.
+	Name string
+
+	// Extensions are the file extensions of the format with the dot, e.g.
+	// ".webp".
+	Extensions []string
+
+	// Magic, Decode and DecodeConfig are given to image.RegisterFormat.
+	// A nil Decode makes the format write only.
+	Magic        string
+	Decode       func(io.Reader) (image.Image, error)
+	DecodeConfig func(io.Reader) (image.Config, error)
+
+	// Encode writes the result saved to the extensions. Nil makes the
+	// format read only.
+	Encode func(io.Writer, image.Image) error
+}
+
+var (
+	formatsMu sync.RWMutex
+
+	// The formats built in, read by image.Decode and written by save.
+	inputFormats  = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".exr": true, ".tif": true, ".tiff": true}
+	outputFormats = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".exr": true, ".tif": true, ".tiff": true}
+	encoders      = map[string]func(io.Writer, image.Image) error{}
+)
+
+// RegisterFormat registers an image format, so that its images are loaded
+// and saved by the extensions, and listed by SupportedInputFormats and
+// SupportedOutputFormats. The built-in formats can't be replaced.
+func RegisterFormat(f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f.Decode != nil {
+		image.RegisterFormat(f.Name, f.Magic, f.Decode, f.DecodeConfig)
+	}
+	for _, ext := range f.Extensions {
+		ext = strings.ToLower(ext)
+		if f.Decode != nil {
+			inputFormats[ext] = true
+		}
+		if f.Encode != nil && !outputFormats[ext] {
+			outputFormats[ext] = true
+			encoders[ext] = f.Encode
+		}
+	}
+}
+
+// SupportedInputFormats returns the sorted extensions of the images that can
+// be loaded.
+func SupportedInputFormats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return sortedKeys(inputFormats)
+}
+
+// SupportedOutputFormats returns the sorted extensions of the images that can
+// be saved.
+func SupportedOutputFormats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return sortedKeys(outputFormats)
+}
+
+// encoder returns the encoder registered for the extension, or nil for the
+// built-in formats and unknown extensions.
+func encoder(ext string) func(io.Writer, image.Image) error {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return encoders[strings.ToLower(ext)]
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,91 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSupportedFormats(t *testing.T) {
+	for _, ext := range []string{".png", ".jpg", ".jpeg"} {
+		if !contains(SupportedInputFormats(), ext) || !contains(SupportedOutputFormats(), ext) {
+			t.Errorf("%s isn't listed in %v and %v", ext, SupportedInputFormats(), SupportedOutputFormats())
+		}
+	}
+
+	// A format storing the width, the height and the gray pixels after
+	// a magic string.
+	const magic = "W2XT"
+	decode := func(r io.Reader) (image.Image, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		img := image.NewGray(image.Rect(0, 0, int(b[4]), int(b[5])))
+		copy(img.Pix, b[6:])
+		return img, nil
+	}
+	RegisterFormat(Format{
+		Name:       "w2xtest",
+		Extensions: []string{".w2xt"},
+		Magic:      magic,
+		Decode:     decode,
+		DecodeConfig: func(r io.Reader) (image.Config, error) {
+			img, err := decode(r)
+			if err != nil {
+				return image.Config{}, err
+			}
+			return image.Config{ColorModel: color.GrayModel, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+		},
+		Encode: func(w io.Writer, img image.Image) error {
+			b := img.Bounds()
+			buf := []byte{'W', '2', 'X', 'T', byte(b.Dx()), byte(b.Dy())}
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					buf = append(buf, color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+				}
+			}
+			_, err := w.Write(buf)
+			return err
+		},
+	})
+	if !contains(SupportedInputFormats(), ".w2xt") || !contains(SupportedOutputFormats(), ".w2xt") {
+		t.Fatalf("the registered format isn't listed in %v and %v", SupportedInputFormats(), SupportedOutputFormats())
+	}
+
+	// The images of the format are saved and loaded.
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(3, 2)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.w2xt")
+	if err := w.SaveImage(out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(magic)) {
+		t.Fatalf("got %q, want the registered encoding", b)
+	}
+	if err := w.LoadImage(out); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Image().Bounds(); got != image.Rect(0, 0, 6, 4) {
+		t.Errorf("got %v loading the saved image, want 6x4", got)
+	}
+}
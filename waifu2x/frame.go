@@ -0,0 +1,37 @@
+package waifu2x
+
+import "image"
+
+// FrameProcessor processes a sequence of frames, e.g. of a video, with the
+// model and the settings of a Waifu2x. The result is written into an output
+// buffer reused while the frames have the same size; the planes given to the
+// model are still allocated for each frame.
+//
+// A FrameProcessor isn't safe for concurrent use; use one for each
+// goroutine, each with its own Waifu2x. The image returned by ProcessFrame
+// is overwritten by the next call, so copy it to keep it.
+type FrameProcessor struct {
+	w   *Waifu2x
+	buf *image.RGBA
+}
+
+// NewFrameProcessor returns a FrameProcessor processing frames with w.
+func NewFrameProcessor(w *Waifu2x) *FrameProcessor {
+	return &FrameProcessor{w: w}
+}
+
+// ProcessFrame reconstructs the frame into the reused output buffer.
+func (p *FrameProcessor) ProcessFrame(img image.Image) (image.Image, error) {
+	p.w.SetImage(img)
+	bounds, err := p.w.OutputBounds()
+	if err != nil {
+		return nil, err
+	}
+	if p.buf == nil || p.buf.Bounds() != bounds {
+		p.buf = image.NewRGBA(bounds)
+	}
+	if err := p.w.ExecInto(p.buf); err != nil {
+		return nil, err
+	}
+	return p.buf, nil
+}
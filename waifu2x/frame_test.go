@@ -0,0 +1,40 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestFrameProcessor(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(4)), 1, 4, 1)
+	p := NewFrameProcessor(&Waifu2x{models: models})
+
+	var prev image.Image
+	for i := 0; i < 5; i++ {
+		frame := image.NewRGBA(image.Rect(0, 0, 7, 5))
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 7; x++ {
+				frame.Set(x, y, color.RGBA{uint8(x*30 + i*40), uint8(y * 50), uint8(i * 60), 255})
+			}
+		}
+		got, err := p.ProcessFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev != nil && got != prev {
+			t.Errorf("frame %d: the output buffer isn't reused", i)
+		}
+		prev = got
+
+		want := &Waifu2x{models: models, src: frame}
+		if err := want.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != want.Result().Bounds() || !bytes.Equal(got.(*image.RGBA).Pix, want.Result().Pix) {
+			t.Errorf("frame %d: differs from processing the frame alone", i)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package waifu2x
+
+import (
+	"io/fs"
+	"io/ioutil"
+)
+
+// NewWaifu2xFS is like NewWaifu2x, but reads the model, the chroma model and
+// the image from fsys, e.g. an embed.FS or a zip.Reader. The paths are those
+// of fs.ValidPath. Models given by a URL are still downloaded.
+func NewWaifu2xFS(fsys fs.FS, modelPath, inputImgPath string, opts ...Option) (*Waifu2x, error) {
+	return newWaifu2x(fsys, modelPath, inputImgPath, opts)
+}
+
+// readFile reads a file of the model or the image from the file system of
+// NewWaifu2xFS, or from the OS.
+func (w *Waifu2x) readFile(name string) ([]byte, error) {
+	if w.fsys != nil {
+		return fs.ReadFile(w.fsys, name)
+	}
+	return ioutil.ReadFile(name)
+}
@@ -0,0 +1,39 @@
+package waifu2x
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewWaifu2xFS(t *testing.T) {
+	model, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"models/scale2.0x_model.json": {Data: model},
+		"images/in.png":               {Data: encodePNG(t, testImage(6, 4))},
+	}
+	w, err := NewWaifu2xFS(fsys, "models/scale2.0x_model.json", "images/in.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Result().Bounds(); got != image.Rect(0, 0, 12, 8) {
+		t.Errorf("got %v, want 12x8", got)
+	}
+
+	// Later images are read from the file system too.
+	if err := w.LoadImage("in.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got %v for a path outside the file system, want %v", err, fs.ErrNotExist)
+	}
+	if err := w.LoadImage("images/in.png"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,85 @@
+package waifu2x
+
+import (
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+// The window and the sigmas of GuidedChroma, in pixels of the output and
+// levels of the luma in [0, 255].
+const (
+	guidedRadius       = 2
+	guidedSigmaSpatial = 1.0
+	guidedSigmaRange   = 10.0
+)
+
+// guidedChroma filters the Cb and Cr planes upscaled twice with nearest
+// neighbor by a joint bilateral upsampling guided by the reconstructed luma of
+// the same size. Each 2x2 block is a sample of the chroma at half the size,
+// compared by the mean luma of the block, so a sample across an edge of the
+// luma gets little weight on either side, and the chroma edges move to those
+// of the luma.
+func guidedChroma(cb, cr, guide *mat.Matrix) (*mat.Matrix, *mat.Matrix) {
+	var spatial [2*guidedRadius + 1][2*guidedRadius + 1]float64
+	for dy := -guidedRadius; dy <= guidedRadius; dy++ {
+		for dx := -guidedRadius; dx <= guidedRadius; dx++ {
+			spatial[dy+guidedRadius][dx+guidedRadius] = math.Exp(-float64(dx*dx+dy*dy) / (2 * guidedSigmaSpatial * guidedSigmaSpatial))
+		}
+	}
+	var similarity [256]float64
+	for d := range similarity {
+		similarity[d] = math.Exp(-float64(d*d) / (2 * guidedSigmaRange * guidedSigmaRange))
+	}
+
+	height := len(guide.M)
+	blocks := make([][]float32, height)
+	for y := range guide.M {
+		blocks[y] = make([]float32, len(guide.M[y]))
+		for x := range guide.M[y] {
+			y0, x0 := y&^1, x&^1
+			y1, x1 := clampIndex(y0+1, height), clampIndex(x0+1, len(guide.M[y]))
+			blocks[y][x] = (guide.M[y0][x0] + guide.M[y0][x1] + guide.M[y1][x0] + guide.M[y1][x1]) / 4
+		}
+	}
+	outCb, outCr := make([][]float32, height), make([][]float32, height)
+	for y := range guide.M {
+		width := len(guide.M[y])
+		outCb[y], outCr[y] = make([]float32, width), make([]float32, width)
+		for x, g := range guide.M[y] {
+			var sumCb, sumCr, sum float64
+			for dy := -guidedRadius; dy <= guidedRadius; dy++ {
+				yy := clampIndex(y+dy, height)
+				for dx := -guidedRadius; dx <= guidedRadius; dx++ {
+					xx := clampIndex(x+dx, width)
+					d := int(math.Abs(float64(blocks[yy][xx]-g)) + 0.5)
+					if d > 255 {
+						d = 255
+					}
+					wt := spatial[dy+guidedRadius][dx+guidedRadius] * similarity[d]
+					sumCb += wt * float64(cb.M[yy][xx])
+					sumCr += wt * float64(cr.M[yy][xx])
+					sum += wt
+				}
+			}
+
+			// Keep the chroma where no sample is close in luma.
+			if sum < 1e-6 {
+				outCb[y][x], outCr[y][x] = cb.M[y][x], cr.M[y][x]
+				continue
+			}
+			outCb[y][x], outCr[y][x] = float32(sumCb/sum), float32(sumCr/sum)
+		}
+	}
+	return mat.NewMatrix(outCb), mat.NewMatrix(outCr)
+}
+
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
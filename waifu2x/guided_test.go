@@ -0,0 +1,90 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/lon9/mat"
+	"github.com/nfnt/resize"
+)
+
+func TestGuidedChroma(t *testing.T) {
+
+	// A sharp edge between two colors at an odd column, so it falls inside
+	// a pixel of the image at half the size.
+	const width, height, edge = 32, 8, 9
+	plane := func(left, right float32) [][]float32 {
+		p := make([][]float32, height)
+		for y := range p {
+			p[y] = make([]float32, width)
+			for x := range p[y] {
+				p[y][x] = left
+				if x >= edge {
+					p[y][x] = right
+				}
+			}
+		}
+		return p
+	}
+	luma, truth := plane(76, 29), plane(90, 240)
+
+	// The chroma at half the size, upscaled with nearest neighbor and with
+	// bicubic interpolation.
+	small := image.NewGray(image.Rect(0, 0, width/2, height/2))
+	for y := 0; y < height/2; y++ {
+		for x := 0; x < width/2; x++ {
+			v := (truth[2*y][2*x] + truth[2*y][2*x+1]) / 2
+			small.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+	nearest := make([][]float32, height)
+	for y := range nearest {
+		nearest[y] = make([]float32, width)
+		for x := range nearest[y] {
+			nearest[y][x] = float32(small.GrayAt(x/2, y/2).Y)
+		}
+	}
+	bicubic := resize.Resize(width, height, small, resize.Bicubic)
+
+	guided, _ := guidedChroma(mat.NewMatrix(nearest), mat.NewMatrix(nearest), mat.NewMatrix(luma))
+	var errGuided, errBicubic float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			g, _, _, _ := bicubic.At(x, y).RGBA()
+			errGuided += math.Abs(float64(guided.M[y][x] - truth[y][x]))
+			errBicubic += math.Abs(float64(g>>8) - float64(truth[y][x]))
+		}
+	}
+	if errGuided >= errBicubic/2 {
+		t.Errorf("got a chroma error of %v guided by the luma, want well below %v of bicubic", errGuided, errBicubic)
+	}
+
+	// The guided chroma changes at the edge of the luma.
+	for y := 0; y < height; y++ {
+		if l, r := guided.M[y][edge-1], guided.M[y][edge]; math.Abs(float64(l-truth[y][edge-1])) > 10 || math.Abs(float64(r-truth[y][edge])) > 10 {
+			t.Errorf("row %d: got %v and %v across the edge, want about %v and %v", y, l, r, truth[y][edge-1], truth[y][edge])
+		}
+	}
+}
+
+func TestExecGuidedChroma(t *testing.T) {
+
+	// A flat color keeps its chroma.
+	src := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	for i := 0; i < len(src.Pix); i += 4 {
+		copy(src.Pix[i:], []uint8{200, 40, 90, 255})
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, src: src, GuidedChroma: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	plain := &Waifu2x{models: []Model{identityModel()}, src: src}
+	if err := plain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if max, _, err := Compare(w.Result(), plain.Result()); err != nil || max > 1 {
+		t.Errorf("got a difference of %d (%v) from the plain chroma of a flat color", max, err)
+	}
+}
@@ -0,0 +1,78 @@
+package waifu2x
+
+import (
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+// halfMatrix is a matrix of float16 values.
+type halfMatrix struct {
+	rows, cols int
+	v          []uint16
+}
+
+func toHalf(m *mat.Matrix) halfMatrix {
+	h := halfMatrix{rows: int(m.Rows), cols: int(m.Cols), v: make([]uint16, m.Rows*m.Cols)}
+	for i, row := range m.M {
+		for j, v := range row {
+			h.v[i*h.cols+j] = floatToHalf(v)
+		}
+	}
+	return h
+}
+
+// halfPlanes keeps the planes in float16 for Half, expanding each plane
+// to float32 when it is convolved.
+type halfPlanes []halfMatrix
+
+func (p halfPlanes) len() int                 { return len(p) }
+func (p halfPlanes) plane(j int) *mat.Matrix  { return p[j].matrix() }
+func (p halfPlanes) set(i int, m *mat.Matrix) { p[i] = toHalf(m) }
+func (p halfPlanes) alloc(n int) planeStore   { return make(halfPlanes, n) }
+
+func (h halfMatrix) matrix() *mat.Matrix {
+	m := make([][]float32, h.rows)
+	for i := range m {
+		m[i] = make([]float32, h.cols)
+		for j := range m[i] {
+			m[i][j] = halfToFloat(h.v[i*h.cols+j])
+		}
+	}
+	return mat.NewMatrix(m)
+}
+
+func floatToHalf(f float32) uint16 {
+
+	// Round to the nearest even, flushing values too small for subnormals
+	// to zero and too large to infinity.
+
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	frac := bits & 0x7fffff
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		frac |= 0x800000
+		shift := uint(14 - exp)
+		h := frac >> shift
+		rem, halfway := frac&(1<<shift-1), uint32(1)<<(shift-1)
+		if rem > halfway || (rem == halfway && h&1 == 1) {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	h := uint32(exp)<<10 | frac>>13
+	rem := frac & 0x1fff
+	if rem > 0x1000 || (rem == 0x1000 && h&1 == 1) {
+		h++
+	}
+	return sign | uint16(h)
+}
@@ -0,0 +1,92 @@
+package waifu2x
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFloatToHalf(t *testing.T) {
+	for _, f := range []float32{0, 1, -2, 0.5, 65504, 1.0 / (1 << 24), 0.1, 3.14159} {
+		got := halfToFloat(floatToHalf(f))
+		if math.Abs(float64(got-f)) > math.Abs(float64(f))/1024 {
+			t.Errorf("%v: got %v", f, got)
+		}
+	}
+	if h := floatToHalf(1e6); h != 0x7c00 {
+		t.Errorf("got %#x for an overflow, want infinity", h)
+	}
+}
+
+func TestExecHalf(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(5)), 1, 8, 8, 1)
+	src := testImage(13, 9)
+
+	full := &Waifu2x{models: models, src: src}
+	if err := full.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	half := &Waifu2x{models: models, src: src, Half: true}
+	if err := half.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	max, _, err := Compare(full.Result(), half.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max > 2 {
+		t.Errorf("got max diff %d in half precision", max)
+	}
+}
+
+// peakHeap returns the peak of the heap sampled while f runs.
+func peakHeap(f func()) uint64 {
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var m runtime.MemStats
+		max := uint64(0)
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > max {
+				max = m.HeapInuse
+			}
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	return <-peak
+}
+
+func BenchmarkExecMemory(b *testing.B) {
+	models := randomModel(rand.New(rand.NewSource(6)), 1, 16, 16, 1)
+	src := testImage(64, 64)
+	for _, bc := range []struct {
+		name string
+		half bool
+	}{{"float32", false}, {"float16", true}} {
+		b.Run(bc.name, func(b *testing.B) {
+			peak := uint64(0)
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				w := &Waifu2x{models: models, src: src, Half: bc.half}
+				if p := peakHeap(func() {
+					if err := w.Exec(); err != nil {
+						b.Fatal(err)
+					}
+				}); p > peak {
+					peak = p
+				}
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package waifu2x
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/lon9/mat"
+)
+
+// Linear luminance of the sRGB primaries, which OpenEXR images use by
+// default.
+const (
+	hdrLumaR = 0.2126
+	hdrLumaG = 0.7152
+	hdrLumaB = 0.0722
+)
+
+// HDRResult returns the linear result of an HDR image, or nil if the image
+// wasn't processed in HDR. It isn't fitted to the target size. Exec must be
+// called before.
+func (w *Waifu2x) HDRResult() *FloatImage {
+	return w.hdrDst
+}
+
+func (w *Waifu2x) execHDR(ctx context.Context, src *FloatImage, passes int) (*image.RGBA, error) {
+
+	// The network expects values in [0, 1], so it is given the tone mapped
+	// luminance, and the linear colors are scaled to the inverse of the
+	// reconstructed luminance.
+
+	img := src
+	for i := 0; i < passes; i++ {
+		if !w.Denoise {
+			img = upscaleFloat(img)
+		}
+		y, lum := hdrLuma(img)
+		// The planes are restored in floating point, so they aren't
+		// rounded.
+		out, err := w.reconstructLuma(ctx, y, Truncate)
+		if err != nil {
+			return nil, err
+		}
+		img = restoreHDR(img, lum, out)
+	}
+	w.hdrDst = img
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Rect.Min, draw.Src)
+	return dst, nil
+}
+
+func upscaleFloat(img *FloatImage) *FloatImage {
+	res := NewFloatImage(image.Rect(0, 0, img.Rect.Dx()*2, img.Rect.Dy()*2))
+	for y := 0; y < res.Rect.Dy(); y++ {
+		for x := 0; x < res.Rect.Dx(); x++ {
+			i := img.PixOffset(img.Rect.Min.X+x/2, img.Rect.Min.Y+y/2)
+			copy(res.Pix[res.PixOffset(x, y):], img.Pix[i:i+4])
+		}
+	}
+	return res
+}
+
+func hdrLuma(img *FloatImage) ([][]float32, [][]float64) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	y := make([][]float32, height)
+	lum := make([][]float64, height)
+	for i := range y {
+		y[i] = make([]float32, width)
+		lum[i] = make([]float64, width)
+		for j := range y[i] {
+			p := img.Pix[img.PixOffset(img.Rect.Min.X+j, img.Rect.Min.Y+i):]
+			l := math.Max(0, hdrLumaR*float64(p[0])+hdrLumaG*float64(p[1])+hdrLumaB*float64(p[2]))
+			lum[i][j] = l
+			y[i][j] = float32(toneMap(l) * 255)
+		}
+	}
+	return y, lum
+}
+
+func restoreHDR(img *FloatImage, lum [][]float64, out *mat.Matrix) *FloatImage {
+	res := NewFloatImage(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()))
+	for i := range out.M {
+		for j := range out.M[i] {
+			p := img.Pix[img.PixOffset(img.Rect.Min.X+j, img.Rect.Min.Y+i):]
+			q := res.Pix[res.PixOffset(j, i):]
+			l := inverseToneMap(float64(out.M[i][j]) / 255)
+			if lum[i][j] == 0 {
+				q[0], q[1], q[2] = float32(l), float32(l), float32(l)
+			} else {
+				ratio := l / lum[i][j]
+				for k := 0; k < 3; k++ {
+					q[k] = float32(float64(p[k]) * ratio)
+				}
+			}
+			q[3] = p[3]
+		}
+	}
+	return res
+}
+
+func floatImage(img image.Image) *FloatImage {
+
+	// Convert the sRGB image to linear values.
+
+	bounds := img.Bounds()
+	res := NewFloatImage(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA64)
+			a := float64(c.A) / 0xffff
+			p := res.Pix[res.PixOffset(x, y):]
+			for k, v := range []uint16{c.R, c.G, c.B} {
+				p[k] = float32(toLinear(float64(v)/0xffff) * a)
+			}
+			p[3] = float32(a)
+		}
+	}
+	return res
+}
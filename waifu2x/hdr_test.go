@@ -0,0 +1,70 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecHDR(t *testing.T) {
+	src, err := DecodeEXR(bytes.NewReader(testEXR(6, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, src: src, HDR: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	hdr := w.HDRResult()
+	if hdr == nil || hdr.Bounds() != image.Rect(0, 0, 12, 8) {
+		t.Fatalf("got HDR result %v", hdr)
+	}
+	if w.Result().Bounds() != hdr.Bounds() {
+		t.Errorf("got result bounds %v, want %v", w.Result().Bounds(), hdr.Bounds())
+	}
+
+	// The identity model keeps the linear values, including those above 1.
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			got := hdr.Pix[hdr.PixOffset(x, y)]
+			want := float32(x/2 + y/2)
+			if math.Abs(float64(got-want)) > 1e-3*math.Max(1, float64(want)) {
+				t.Fatalf("(%d, %d): got red %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "out.exr")
+	if err := w.SaveImage(path); err != nil {
+		t.Fatal(err)
+	}
+	w2 := &Waifu2x{}
+	if err := w2.LoadImage(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w2.Image().(*FloatImage); !ok || w2.Image().Bounds() != hdr.Bounds() {
+		t.Errorf("got %T %v from the saved image", w2.Image(), w2.Image().Bounds())
+	}
+}
+
+func TestExecEXRWithoutHDR(t *testing.T) {
+
+	// Without HDR, the tone mapped colors are processed.
+
+	src, err := DecodeEXR(bytes.NewReader(testEXR(6, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, src: src}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if w.HDRResult() != nil {
+		t.Error("got an HDR result without HDR")
+	}
+	if w.Result().Bounds() != image.Rect(0, 0, 12, 8) {
+		t.Errorf("got bounds %v", w.Result().Bounds())
+	}
+}
@@ -0,0 +1,146 @@
+package waifu2x
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// rgbProfile is an ICC profile of the matrix/TRC kind, like sRGB, Adobe RGB,
+// ProPhoto RGB and Display P3.
+type rgbProfile struct {
+	// trc converts the encoded values of each channel in [0, 1] to linear
+	// light.
+	trc [3]func(float64) float64
+	// primaries are the XYZ coordinates of red, green and blue, adapted to
+	// the D50 white point of the PCS.
+	primaries [3][3]float64
+}
+
+// displayP3RGB is taken for the profiles identified as Display P3 by their
+// description but without the tags parseRGBProfile reads.
+var displayP3RGB = rgbProfile{
+	trc: [3]func(float64) float64{toLinear, toLinear, toLinear},
+	primaries: [3][3]float64{
+		{0.5151215, 0.2411957, -0.0010529},
+		{0.2919769, 0.6922455, 0.0418854},
+		{0.1571045, 0.0665741, 0.7840729},
+	},
+}
+
+// parseRGBProfile reads the primaries and the tone curves of an RGB profile.
+// Profiles of other color spaces, or with only lookup tables, aren't
+// supported.
+func parseRGBProfile(profile []byte) (*rgbProfile, error) {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil, fmt.Errorf("%w: not an ICC profile", ErrUnsupportedProfile)
+	}
+	if space := string(profile[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("%w: color space %q", ErrUnsupportedProfile, space)
+	}
+	tags := make(map[string][]byte)
+	count := binary.BigEndian.Uint32(profile[128:])
+	for i := uint64(0); i < uint64(count); i++ {
+		entry := 132 + 12*i
+		if entry+12 > uint64(len(profile)) {
+			return nil, fmt.Errorf("%w: truncated tag table", ErrUnsupportedProfile)
+		}
+		offset := uint64(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := uint64(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset+size > uint64(len(profile)) {
+			return nil, fmt.Errorf("%w: truncated tag", ErrUnsupportedProfile)
+		}
+		tags[string(profile[entry:entry+4])] = profile[offset : offset+size]
+	}
+
+	var p rgbProfile
+	for k, channel := range []string{"r", "g", "b"} {
+		xyz := tags[channel+"XYZ"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, fmt.Errorf("%w: no %sXYZ tag", ErrUnsupportedProfile, channel)
+		}
+		for j := range p.primaries[k] {
+			p.primaries[k][j] = s15Fixed16(xyz[8+4*j:])
+		}
+		trc, err := parseCurve(tags[channel+"TRC"])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %sTRC: %v", ErrUnsupportedProfile, channel, err)
+		}
+		p.trc[k] = trc
+	}
+	return &p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseCurve reads a curv or para tag.
+func parseCurve(t []byte) (func(float64) float64, error) {
+	if len(t) < 12 {
+		return nil, fmt.Errorf("missing or truncated")
+	}
+	switch string(t[:4]) {
+	case "curv":
+		n := uint64(binary.BigEndian.Uint32(t[8:]))
+		if uint64(len(t)) < 12+2*n {
+			return nil, fmt.Errorf("truncated curve of %d entries", n)
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(t[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(t[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			x := math.Min(math.Max(v, 0), 1) * float64(len(table)-1)
+			i := int(x)
+			if i >= len(table)-1 {
+				return table[len(table)-1]
+			}
+			return table[i] + (x-float64(i))*(table[i+1]-table[i])
+		}, nil
+	case "para":
+
+		// The five functions of ICC are special cases of the last,
+		// (a*x+b)^g+e from d and c*x+f below.
+
+		typ := binary.BigEndian.Uint16(t[8:])
+		if typ > 4 {
+			return nil, fmt.Errorf("parametric curve of type %d", typ)
+		}
+		n := []int{1, 3, 4, 5, 7}[typ]
+		if len(t) < 12+4*n {
+			return nil, fmt.Errorf("truncated parametric curve")
+		}
+		params := make([]float64, 7)
+		for i := 0; i < n; i++ {
+			params[i] = s15Fixed16(t[12+4*i:])
+		}
+		g, a, b, c, d, e, f := params[0], params[1], params[2], params[3], params[4], params[5], params[6]
+		switch typ {
+		case 0:
+			a = 1
+		case 1, 2:
+			if a == 0 {
+				return nil, fmt.Errorf("parametric curve with a = 0")
+			}
+			d = -b / a
+			if typ == 2 {
+				e, f, c = c, c, 0
+			}
+		}
+		return func(v float64) float64 {
+			if v >= d {
+				return math.Pow(math.Max(a*v+b, 0), g) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, fmt.Errorf("curve type %q", t[:4])
+}
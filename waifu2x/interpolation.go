@@ -0,0 +1,121 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+)
+
+// InterpolationMode is flag for how the image is resized to twice its size
+// before the model is applied.
+type InterpolationMode int
+
+const (
+	// AutoInterpolation picks NearestNeighbor for flat colored images, e.g.
+	// cartoons, and Bicubic for photographic ones by the gradients of the
+	// luma.
+	AutoInterpolation InterpolationMode = iota
+	// NearestNeighbor repeats the pixels, keeping flat colors and hard
+	// edges.
+	NearestNeighbor
+	// Bicubic interpolates the pixels, which suits the smooth shading of
+	// photographs.
+	Bicubic
+	// Bilinear interpolates the pixels more softly and faster than Bicubic.
+	Bilinear
+	// Lanczos interpolates the pixels by Lanczos3, the sharpest and the
+	// slowest, with some ringing at hard edges.
+	Lanczos
+)
+
+// The gradients of a flat colored image are mostly zero, with a few of the
+// edges. Photographs have small gradients of shading and noise almost
+// everywhere.
+const (
+	// smallGradient is the largest gradient of the luma, in 8 bits, of
+	// shading rather than an edge.
+	smallGradient = 32
+	// photoGradients is the fraction of the pixels with small nonzero
+	// gradients above which an image is photographic.
+	photoGradients = 0.25
+)
+
+// detectInterpolation picks the interpolation for the image from the
+// histogram of the gradients of its luma.
+func detectInterpolation(img image.Image) InterpolationMode {
+	bounds := img.Bounds()
+	if bounds.Dx() < 2 || bounds.Dy() < 2 {
+		return NearestNeighbor
+	}
+	luma := make([][]int, bounds.Dy())
+	for y := range luma {
+		luma[y] = make([]int, bounds.Dx())
+		for x := range luma[y] {
+			luma[y][x] = int(color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+
+	// Count the forward differences by their size.
+	var zero, small, large int
+	for y := 0; y < len(luma)-1; y++ {
+		for x := 0; x < len(luma[y])-1; x++ {
+			g := abs(luma[y][x+1]-luma[y][x]) + abs(luma[y+1][x]-luma[y][x])
+			switch {
+			case g == 0:
+				zero++
+			case g <= smallGradient:
+				small++
+			default:
+				large++
+			}
+		}
+	}
+	if float64(small) > photoGradients*float64(zero+small+large) {
+		return Bicubic
+	}
+	return NearestNeighbor
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// interpolation returns the interpolation of the upscaling of the image,
+// detecting it with AutoInterpolation.
+func (w *Waifu2x) interpolation(img image.Image) InterpolationMode {
+	if w.Interpolation == AutoInterpolation {
+		return detectInterpolation(img)
+	}
+	return w.Interpolation
+}
+
+func (m InterpolationMode) function() resize.InterpolationFunction {
+	switch m {
+	case Bicubic:
+		return resize.Bicubic
+	case Bilinear:
+		return resize.Bilinear
+	case Lanczos:
+		return resize.Lanczos3
+	}
+	return resize.NearestNeighbor
+}
+
+// reach returns the radius of the kernel of the interpolation, how far the
+// pixels of an upscaled image are interpolated from, in the pixels of the
+// image.
+func (m InterpolationMode) reach() int {
+	switch m {
+	case Bilinear:
+		return 1
+	case Bicubic:
+		return 2
+	case Lanczos:
+		return 3
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+func TestDetectInterpolation(t *testing.T) {
+
+	// A shaded gradient with noise, like a photograph.
+	rng := rand.New(rand.NewSource(15))
+	photo := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(60 + 3*x + 2*y + rng.Intn(5))
+			photo.Set(x, y, color.RGBA{v, v / 2, 200 - v/2, 255})
+		}
+	}
+	if got := detectInterpolation(photo); got != Bicubic {
+		t.Errorf("got %d for a photograph, want Bicubic", got)
+	}
+
+	// Flat colored blocks with hard edges, like a cartoon.
+	flat := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	colors := []color.RGBA{{255, 255, 255, 255}, {230, 40, 40, 255}, {20, 20, 20, 255}}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			flat.Set(x, y, colors[(x/8+y/12)%len(colors)])
+		}
+	}
+	if got := detectInterpolation(flat); got != NearestNeighbor {
+		t.Errorf("got %d for flat colors, want NearestNeighbor", got)
+	}
+
+	// An explicit interpolation isn't detected.
+	w := &Waifu2x{Interpolation: NearestNeighbor}
+	if got := w.interpolation(photo); got != NearestNeighbor {
+		t.Errorf("got %d, want the explicit NearestNeighbor", got)
+	}
+	w.Interpolation = AutoInterpolation
+	if got := w.interpolation(photo); got != Bicubic {
+		t.Errorf("got %d, want the detected Bicubic", got)
+	}
+}
+
+func TestExecInterpolationPasses(t *testing.T) {
+
+	// A checkerboard of 2x2 squares is flat, but the box blur of the first
+	// pass leaves the shading of a photograph.
+	src := image.NewGray(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			src.SetGray(x, y, color.Gray{uint8(100 + 60*((x/2+y/2)%2))})
+		}
+	}
+	var functions []resize.InterpolationFunction
+	resizeImage = func(width, height uint, img image.Image, f resize.InterpolationFunction) image.Image {
+		functions = append(functions, f)
+		return resize.Resize(width, height, img, f)
+	}
+	defer func() { resizeImage = resize.Resize }()
+
+	w := &Waifu2x{models: []Model{boxModel()}, src: src, Passes: 2}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []resize.InterpolationFunction{resize.NearestNeighbor, resize.NearestNeighbor}; !reflect.DeepEqual(functions, want) {
+		t.Errorf("got the interpolations %v, want %v", functions, want)
+	}
+}
+
+func BenchmarkUpscale(b *testing.B) {
+	src := testImage(256, 256)
+	for _, bc := range []struct {
+		name   string
+		interp InterpolationMode
+	}{
+		{"nearest", NearestNeighbor},
+		{"bilinear", Bilinear},
+		{"bicubic", Bicubic},
+		{"lanczos", Lanczos},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			w := &Waifu2x{Interpolation: bc.interp}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := w.upscale(src, bc.interp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package waifu2x
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// jpegUnzig maps the zig-zag order to the natural order of the coefficients.
+var jpegUnzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// jpegQuant are the quantization tables of section K.1 of the JPEG spec for
+// the luminance and the chrominance, in zig-zag order.
+var jpegQuant = [2][64]byte{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// jpegHuffman are the Huffman tables of section K.3 of the JPEG spec: the
+// number of codes of each length and the values. They are in the order of
+// the luminance DC and AC, then the chrominance DC and AC.
+var jpegHuffman = [4]struct {
+	count [16]byte
+	value []byte
+}{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{
+			0, 1, 2, 3, 4, 5, 6, 7,
+			8, 9, 10, 11,
+		},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{
+			0, 1, 2, 3, 4, 5, 6, 7,
+			8, 9, 10, 11,
+		},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// jpegCos[u][x] is the factor of the sample x in the coefficient u of the
+// 8-point DCT.
+var jpegCos [8][8]float64
+
+func init() {
+	for u := range jpegCos {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := range jpegCos[u] {
+			jpegCos[u][x] = c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+}
+
+type jpegCode struct {
+	code uint32
+	size uint
+}
+
+type jpegEncoder struct {
+	w     *bufio.Writer
+	bits  uint32
+	nBits uint
+	quant [2][64]byte
+	codes [4][256]jpegCode
+}
+
+func (e *jpegEncoder) emit(bits uint32, n uint) {
+
+	// Write the bits, stuffing a zero byte after each 0xff.
+
+	e.bits = e.bits<<n | bits&(1<<n-1)
+	e.nBits += n
+	for e.nBits >= 8 {
+		b := byte(e.bits >> (e.nBits - 8))
+		e.w.WriteByte(b)
+		if b == 0xff {
+			e.w.WriteByte(0)
+		}
+		e.nBits -= 8
+	}
+}
+
+func (e *jpegEncoder) emitHuffman(table int, v byte) {
+	c := e.codes[table][v]
+	e.emit(c.code, c.size)
+}
+
+func (e *jpegEncoder) marker(m byte, data []byte) {
+	e.w.Write([]byte{0xff, m, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+	e.w.Write(data)
+}
+
+// jpegCategory returns the number of bits of v and the bits written for it.
+func jpegCategory(v int) (uint32, uint) {
+	a := v
+	if a < 0 {
+		a = -a
+		v--
+	}
+	n := uint(0)
+	for a > 0 {
+		n++
+		a >>= 1
+	}
+	return uint32(v), n
+}
+
+func (e *jpegEncoder) block(b *[64]float64, quant int, prevDC *int) {
+
+	// Transform, quantize and write a block of samples. The luminance
+	// tables are 0 and 1, and the chrominance tables are 2 and 3.
+
+	var tmp, coef [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			s := 0.0
+			for x := 0; x < 8; x++ {
+				s += jpegCos[u][x] * b[y*8+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			s := 0.0
+			for y := 0; y < 8; y++ {
+				s += jpegCos[v][y] * tmp[y*8+u]
+			}
+			coef[v*8+u] = s
+		}
+	}
+
+	dcTable, acTable := quant*2, quant*2+1
+	dc := int(math.Round(coef[0] / float64(e.quant[quant][0])))
+	bits, n := jpegCategory(dc - *prevDC)
+	*prevDC = dc
+	e.emitHuffman(dcTable, byte(n))
+	e.emit(bits, n)
+
+	run := 0
+	for i := 1; i < 64; i++ {
+		ac := int(math.Round(coef[jpegUnzig[i]] / float64(e.quant[quant][i])))
+		if ac == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			e.emitHuffman(acTable, 0xf0)
+		}
+		bits, n := jpegCategory(ac)
+		e.emitHuffman(acTable, byte(run<<4)|byte(n))
+		e.emit(bits, n)
+		run = 0
+	}
+	if run > 0 {
+		e.emitHuffman(acTable, 0)
+	}
+}
+
+// encodeJPEG444 encodes the image as a baseline JPEG with the chroma at full
+// resolution, which image/jpeg can't do. The quality is the same as in
+// jpeg.Options.
+func encodeJPEG444(w io.Writer, img image.Image, quality int) error {
+	e := &jpegEncoder{w: bufio.NewWriter(w)}
+
+	// Scale the quantization tables like image/jpeg.
+	quality = int(math.Max(1, math.Min(100, float64(quality))))
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	for i := range e.quant {
+		for j, v := range jpegQuant[i] {
+			e.quant[i][j] = byte(math.Max(1, math.Min(255, float64((int(v)*scale+50)/100))))
+		}
+	}
+	for i, h := range jpegHuffman {
+		code, k := uint32(0), 0
+		for size, count := range h.count {
+			for j := 0; j < int(count); j++ {
+				e.codes[i][h.value[k]] = jpegCode{code, uint(size + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	e.w.Write([]byte{0xff, 0xd8})
+	dqt := []byte{}
+	for i := range e.quant {
+		dqt = append(dqt, byte(i))
+		dqt = append(dqt, e.quant[i][:]...)
+	}
+	e.marker(0xdb, dqt)
+	// Three components sampled 1x1 with the luminance and the chrominance
+	// quantization tables.
+	e.marker(0xc0, []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		1, 0x11, 0, 2, 0x11, 1, 3, 0x11, 1,
+	})
+	dht := []byte{}
+	for i, h := range jpegHuffman {
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, h.count[:]...)
+		dht = append(dht, h.value...)
+	}
+	e.marker(0xc4, dht)
+	e.marker(0xda, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+
+	// The blocks at the right and the bottom are filled with the edge
+	// pixels.
+	var prevDC [3]int
+	var blocks [3][64]float64
+	for by := 0; by < height; by += 8 {
+		for bx := 0; bx < width; bx += 8 {
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					px := bounds.Min.X + int(math.Min(float64(bx+x), float64(width-1)))
+					py := bounds.Min.Y + int(math.Min(float64(by+y), float64(height-1)))
+					r, g, b, _ := img.At(px, py).RGBA()
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+					blocks[0][y*8+x] = float64(yy) - 128
+					blocks[1][y*8+x] = float64(cb) - 128
+					blocks[2][y*8+x] = float64(cr) - 128
+				}
+			}
+			e.block(&blocks[0], 0, &prevDC[0])
+			e.block(&blocks[1], 1, &prevDC[1])
+			e.block(&blocks[2], 1, &prevDC[2])
+		}
+	}
+
+	// Pad the last byte with ones.
+	e.emit(0x7f, 7)
+	e.w.Write([]byte{0xff, 0xd9})
+	return e.w.Flush()
+}
@@ -0,0 +1,73 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// chromaError returns the mean absolute difference of Cb and Cr.
+func chromaError(a, b image.Image) float64 {
+	sum := 0
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ca := color.YCbCrModel.Convert(a.At(x, y)).(color.YCbCr)
+			cb := color.YCbCrModel.Convert(b.At(x, y)).(color.YCbCr)
+			for _, d := range []int{int(ca.Cb) - int(cb.Cb), int(ca.Cr) - int(cb.Cr)} {
+				if d < 0 {
+					d = -d
+				}
+				sum += d
+			}
+		}
+	}
+	return float64(sum) / float64(2*bounds.Dx()*bounds.Dy())
+}
+
+func TestEncodeJPEG444(t *testing.T) {
+
+	// Columns of alternating red and blue have detail only in the chroma.
+
+	img := image.NewRGBA(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			if x%2 == 0 {
+				img.Set(x, y, color.RGBA{200, 40, 60, 255})
+			} else {
+				img.Set(x, y, color.RGBA{40, 80, 220, 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeJPEG444(&buf, img, jpeg.DefaultQuality); err != nil {
+		t.Fatal(err)
+	}
+	full, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Bounds() != img.Bounds() {
+		t.Fatalf("got bounds %v, want %v", full.Bounds(), img.Bounds())
+	}
+	if ycc, ok := full.(*image.YCbCr); !ok || ycc.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("got %T, want a 4:4:4 image", full)
+	}
+
+	buf.Reset()
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpeg.DefaultQuality}); err != nil {
+		t.Fatal(err)
+	}
+	subsampled, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e444, e420 := chromaError(img, full), chromaError(img, subsampled)
+	if e444 >= e420/2 {
+		t.Errorf("got chroma error %.2f without subsampling, %.2f with it", e444, e420)
+	}
+}
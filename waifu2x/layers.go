@@ -0,0 +1,95 @@
+package waifu2x
+
+// LayerInfo describes a layer of the model.
+type LayerInfo struct {
+	KW, KH       int
+	NInputPlane  int
+	NOutputPlane int
+	Biases       int
+	Params       int
+
+	// MACs is the number of multiply-accumulates of the layer for the
+	// image size given to Layers.
+	MACs int64
+}
+
+// EstimateOps returns the multiply-accumulates of Exec for an image of width x
+// height, across the passes, the chroma model and Preview.
+func (w *Waifu2x) EstimateOps(width, height int) (int64, error) {
+	passes, _, _, err := w.outputSize(width, height)
+	if err != nil {
+		return 0, err
+	}
+	if w.previewing(width, height) {
+		width, height = width/w.Preview, height/w.Preview
+	}
+	chroma := &Waifu2x{models: w.chromaModels}
+	var ops int64
+	for i := 0; i < passes; i++ {
+		if !w.Denoise {
+			width, height = width*2, height*2
+		}
+		for _, l := range w.Layers(width, height) {
+			ops += l.MACs
+		}
+		if w.hasChromaModel() {
+			for _, l := range chroma.Layers(width, height) {
+				ops += 2 * l.MACs
+			}
+		}
+	}
+	return ops, nil
+}
+
+// ReceptiveField returns the number of pixels around each pixel of the image
+// that the output pixel depends on, the sum of the half kernel sizes of the
+// layers, the larger of the horizontal and the vertical one for kernels of
+// KW != KH. Tiles with the overlap are seamless.
+func (w *Waifu2x) ReceptiveField() int {
+	return receptiveField(w.models)
+}
+
+func receptiveField(models []Model) int {
+	x, y := receptiveFieldXY(models)
+	if y > x {
+		return y
+	}
+	return x
+}
+
+// receptiveFieldXY returns the horizontal and the vertical receptive field.
+func receptiveFieldXY(models []Model) (int, int) {
+	x, y := 0, 0
+	for _, m := range models {
+		x += (m.KW - 1) / 2
+		y += (m.KH - 1) / 2
+	}
+	return x, y
+}
+
+// Layers describes the layers of the model. The multiply-accumulates are
+// counted for an image of width x height given to the model, i.e. after
+// upscaling.
+func (w *Waifu2x) Layers(width, height int) []LayerInfo {
+
+	// The image is padded by the receptive field, and each convolution
+	// removes the kernel size minus one.
+
+	res := make([]LayerInfo, len(w.models))
+	rf := w.ReceptiveField()
+	ow, oh := width+2*rf, height+2*rf
+	for i, m := range w.models {
+		ow, oh = ow-m.KW+1, oh-m.KH+1
+		weights := m.KW * m.KH * m.NInputPlane * m.NOutputPlane
+		res[i] = LayerInfo{
+			KW:           m.KW,
+			KH:           m.KH,
+			NInputPlane:  m.NInputPlane,
+			NOutputPlane: m.NOutputPlane,
+			Biases:       len(m.Bias),
+			Params:       weights + len(m.Bias),
+			MACs:         int64(weights) * int64(ow) * int64(oh),
+		}
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package waifu2x
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	w := &Waifu2x{models: randomModel(rand.New(rand.NewSource(7)), 1, 4, 1)}
+	layers := w.Layers(10, 6)
+	want := []LayerInfo{
+		{KW: 3, KH: 3, NInputPlane: 1, NOutputPlane: 4, Biases: 4, Params: 40, MACs: 36 * 12 * 8},
+		{KW: 3, KH: 3, NInputPlane: 4, NOutputPlane: 1, Biases: 1, Params: 37, MACs: 36 * 10 * 6},
+	}
+	if len(layers) != len(want) {
+		t.Fatalf("got %d layers, want %d", len(layers), len(want))
+	}
+	for i := range want {
+		if layers[i] != want[i] {
+			t.Errorf("layer %d: got %+v, want %+v", i+1, layers[i], want[i])
+		}
+	}
+}
+
+func TestEstimateOps(t *testing.T) {
+	w := &Waifu2x{models: randomModel(rand.New(rand.NewSource(7)), 1, 4, 1), Passes: 2}
+	full, err := w.EstimateOps(16, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want int64
+	for _, size := range [][2]int{{32, 24}, {64, 48}} {
+		for _, l := range w.Layers(size[0], size[1]) {
+			want += l.MACs
+		}
+	}
+	if full != want {
+		t.Errorf("got %d ops for 2 passes, want %d", full, want)
+	}
+
+	w.Preview = 4
+	preview, err := w.EstimateOps(16, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview*8 > full {
+		t.Errorf("got %d ops with a preview shrunk by 4, want far fewer than %d", preview, full)
+	}
+}
+
+func TestExecAsymmetricKernel(t *testing.T) {
+
+	// A horizontal 3x1 average of columns alternating black and white.
+	third := float32(1) / 3
+	models := []Model{{
+		Weight:       [][][][]float32{{{{third, third, third}}}},
+		Bias:         []float32{0},
+		KW:           3,
+		KH:           1,
+		NInputPlane:  1,
+		NOutputPlane: 1,
+	}}
+	src := image.NewGray(image.Rect(0, 0, 6, 4))
+	for y := 0; y < 4; y++ {
+		for x := 1; x < 6; x += 2 {
+			src.SetGray(x, y, color.Gray{255})
+		}
+	}
+	want := []int{85, 85, 170, 85, 170, 170}
+
+	for _, w := range []*Waifu2x{
+		{},
+		{TileSize: 4},
+		{Half: true},
+		{Accumulate64: true},
+	} {
+		w.models, w.src, w.Denoise = models, src, true
+		if got := w.ReceptiveField(); got != 1 {
+			t.Errorf("got receptive field %d, want 1", got)
+		}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Result().Bounds(); got != src.Bounds() {
+			t.Fatalf("got bounds %v, want %v", got, src.Bounds())
+		}
+		for y := 0; y < 4; y++ {
+			for x, v := range want {
+				if got := int(w.Result().RGBAAt(x, y).G); got < v-1 || got > v+1 {
+					t.Errorf("tile size %d: got %d at (%d, %d), want %d", w.TileSize, got, x, y, v)
+				}
+			}
+		}
+	}
+
+	// Even sizes have no center pixel.
+	models[0].KW = 2
+	if err := validateModels(models); !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("got %v for a 2x1 kernel, want %v", err, ErrInvalidModel)
+	}
+}
@@ -0,0 +1,63 @@
+package waifu2x
+
+import "fmt"
+
+// The layouts of Model.Weight.
+const (
+	// LayoutOIHW is [out][in][kh][kw], the layout of the waifu2x models.
+	LayoutOIHW = "oihw"
+	// LayoutOHWI is [out][kh][kw][in], e.g. of models converted from
+	// TensorFlow.
+	LayoutOHWI = "ohwi"
+)
+
+func (m *Model) canonicalLayout() error {
+
+	// Transpose the weights to LayoutOIHW, checking the shape first so that
+	// a malformed model doesn't index out of range.
+
+	switch m.Layout {
+	case "", LayoutOIHW:
+		m.Layout = ""
+		return nil
+	case LayoutOHWI:
+	default:
+		return fmt.Errorf("%w: unknown layout %q", ErrInvalidModel, m.Layout)
+	}
+	if m.KH <= 0 || m.KW <= 0 || m.NInputPlane <= 0 {
+		return fmt.Errorf("%w: %dx%d kernels of %d input planes", ErrInvalidModel, m.KW, m.KH, m.NInputPlane)
+	}
+	for _, wgt := range m.Weight {
+		if len(wgt) != m.KH {
+			return fmt.Errorf("%w: %d kernel rows, want %d", ErrInvalidModel, len(wgt), m.KH)
+		}
+		for _, row := range wgt {
+			if len(row) != m.KW {
+				return fmt.Errorf("%w: %d kernel columns, want %d", ErrInvalidModel, len(row), m.KW)
+			}
+			for _, in := range row {
+				if len(in) != m.NInputPlane {
+					return fmt.Errorf("%w: %d input planes, want %d", ErrInvalidModel, len(in), m.NInputPlane)
+				}
+			}
+		}
+	}
+
+	weight := make([][][][]float32, len(m.Weight))
+	for o, wgt := range m.Weight {
+		weight[o] = make([][][]float32, m.NInputPlane)
+		for i := range weight[o] {
+			k := make([][]float32, m.KH)
+			for y := range k {
+				k[y] = make([]float32, m.KW)
+				for x := range k[y] {
+					k[y][x] = wgt[y][x][i]
+				}
+			}
+			weight[o][i] = k
+		}
+	}
+	m.Weight = weight
+	m.Layout = ""
+	return nil
+}
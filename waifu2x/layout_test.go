@@ -0,0 +1,68 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestModelLayout(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 3, 2, 1)
+
+	// The same models with the weights in [out][kh][kw][in].
+	transposed := make([]Model, len(models))
+	for l, m := range models {
+		m.Layout = LayoutOHWI
+		weight := make([][][][]float32, m.NOutputPlane)
+		for o := range weight {
+			weight[o] = make([][][]float32, m.KH)
+			for y := range weight[o] {
+				weight[o][y] = make([][]float32, m.KW)
+				for x := range weight[o][y] {
+					weight[o][y][x] = make([]float32, m.NInputPlane)
+					for i := range weight[o][y][x] {
+						weight[o][y][x][i] = m.Weight[o][i][y][x]
+					}
+				}
+			}
+		}
+		m.Weight = weight
+		transposed[l] = m
+	}
+	b, err := json.Marshal(transposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := parseModel(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, models) {
+		t.Errorf("got %v, want %v", loaded, models)
+	}
+
+	src := testImage(6, 5)
+	canonical := &Waifu2x{models: models, src: src}
+	if err := canonical.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: loaded, src: src}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.dst.Pix, canonical.dst.Pix) {
+		t.Error("output of the transposed model differs from the canonical one")
+	}
+
+	for _, data := range []string{
+		`[{"weight":[[[[1]]]],"bias":[0],"nInputPlane":1,"nOutputPlane":1,"kW":3,"kH":3,"layout":"ohwi"}]`,
+		`[{"weight":[[[[1]]]],"bias":[0],"nInputPlane":1,"nOutputPlane":1,"kW":1,"kH":1,"layout":"hwio"}]`,
+	} {
+		if _, err := parseModel([]byte(data)); !errors.Is(err, ErrInvalidModel) {
+			t.Errorf("%s: got %v, want %v", data, err, ErrInvalidModel)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package waifu2x
+
+import (
+	"math"
+	"sort"
+
+	"github.com/lon9/mat"
+)
+
+func autoLevels(m *mat.Matrix, percentile float64) (*mat.Matrix, func(*mat.Matrix) *mat.Matrix) {
+
+	// Stretch the luma in [0, 255] so that the range between the
+	// percentiles spans [0, 255], and return the function to restore the
+	// original range. Values out of the percentiles are clipped.
+
+	var values []float32
+	for _, row := range m.M {
+		values = append(values, row...)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	k := int(math.Min(percentile/100*float64(len(values)), float64(len(values)-1)/2))
+	lo, hi := values[k], values[len(values)-1-k]
+	if hi <= lo {
+		return m, func(out *mat.Matrix) *mat.Matrix { return out }
+	}
+
+	scale := 255 / (hi - lo)
+	stretched := m.BroadcastAdd(-lo).BroadcastMul(scale).Clip(0, 255)
+	return stretched, func(out *mat.Matrix) *mat.Matrix {
+		return out.BroadcastDiv(scale).BroadcastAdd(lo)
+	}
+}
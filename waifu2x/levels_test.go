@@ -0,0 +1,83 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/lon9/mat"
+)
+
+func TestAutoLevels(t *testing.T) {
+	y := make([][]float32, 4)
+	for i := range y {
+		y[i] = make([]float32, 5)
+		for j := range y[i] {
+			y[i][j] = 100 + float32(i*5+j)
+		}
+	}
+	m := mat.NewMatrix(y)
+	stretched, restore := autoLevels(m, 0)
+
+	min, max := float32(255), float32(0)
+	for _, row := range stretched.M {
+		for _, v := range row {
+			min = minimum(v, min)
+			max = maximum(v, max)
+		}
+	}
+	if min != 0 || max != 255 {
+		t.Errorf("got the range [%v, %v], want [0, 255]", min, max)
+	}
+	restored := restore(stretched)
+	for i := range y {
+		for j := range y[i] {
+			if math.Abs(float64(restored.M[i][j]-y[i][j])) > 1e-3 {
+				t.Fatalf("got %v, want %v", restored.M, y)
+			}
+		}
+	}
+}
+
+func TestAutoLevelsPercentile(t *testing.T) {
+	y := [][]float32{make([]float32, 100)}
+	for j := range y[0] {
+		y[0][j] = 100 + float32(j)/10
+	}
+	y[0][0], y[0][99] = 0, 255
+	stretched, _ := autoLevels(mat.NewMatrix(y), 1)
+	if v := stretched.M[0][1]; v != 0 {
+		t.Errorf("got %v at the 1st percentile, want 0", v)
+	}
+	if v := stretched.M[0][98]; v != 255 {
+		t.Errorf("got %v at the 99th percentile, want 255", v)
+	}
+}
+
+func TestExecAutoLevels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			v := uint8(120 + x + y)
+			src.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	plain := &Waifu2x{models: []Model{identityModel()}, src: src}
+	if err := plain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, src: src, AutoLevels: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity model gives back the original range.
+	max, _, err := Compare(plain.Result(), w.Result())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max > 1 {
+		t.Errorf("got max diff %d with auto levels", max)
+	}
+}
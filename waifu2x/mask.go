@@ -0,0 +1,42 @@
+package waifu2x
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+// toRGBA copies the image to an RGBA image at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	res := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(res, res.Bounds(), img, img.Bounds().Min, draw.Src)
+	return res
+}
+
+// applyMask keeps the result where Mask isn't black and replaces the rest with
+// the input resized to the output, both fitted like the result.
+func (w *Waifu2x) applyMask(dst *image.RGBA) (*image.RGBA, error) {
+	_, cw, ch, err := w.outputSize(w.src.Bounds().Dx(), w.src.Bounds().Dy())
+	if err != nil {
+		return nil, err
+	}
+	background := w.fit(toRGBA(w.src), cw, ch, nil)
+	// Resize the mask to the nearest pixels, since interpolating would
+	// spread it to the neighbours.
+	mask := w.fit(toRGBA(resize.Resize(uint(cw), uint(ch), w.Mask, resize.NearestNeighbor)), cw, ch, nil)
+	if mask.Bounds() != dst.Bounds() || background.Bounds() != dst.Bounds() {
+		return nil, ErrSizeMismatch
+	}
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			m := mask.Pix[mask.PixOffset(x, y):]
+			if m[0] != 0 || m[1] != 0 || m[2] != 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			copy(dst.Pix[i:i+4], background.Pix[i:i+4])
+		}
+	}
+	return dst, nil
+}
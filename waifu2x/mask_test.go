@@ -0,0 +1,40 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+func TestExecMask(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(10)), 1, 4, 1)
+	src := testImage(16, 8)
+	full := &Waifu2x{models: models, src: src}
+	if err := full.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The left half of the mask is white.
+	mask := image.NewGray(image.Rect(0, 0, 16, 8))
+	draw.Draw(mask, image.Rect(0, 0, 8, 8), image.NewUniform(color.White), image.Point{}, draw.Src)
+	w := &Waifu2x{models: models, src: src, Mask: mask}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	plain := toRGBA(resize.Resize(32, 16, src, resize.Lanczos3))
+
+	left, right := image.Rect(0, 0, 16, 16), image.Rect(16, 0, 32, 16)
+	if !sameImage(w.dst.SubImage(left), full.dst.SubImage(left)) {
+		t.Error("the masked half isn't reconstructed")
+	}
+	if sameImage(w.dst.SubImage(left), plain.SubImage(left)) {
+		t.Error("the masked half is the same as a plain resize")
+	}
+	if !sameImage(w.dst.SubImage(right), plain.SubImage(right)) {
+		t.Error("the unmasked half isn't a plain resize")
+	}
+}
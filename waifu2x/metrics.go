@@ -0,0 +1,181 @@
+package waifu2x
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// Compare returns the maximum and the mean absolute difference of the 8-bit
+// channels of a and b.
+func Compare(a, b image.Image) (max int, mean float64, err error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return 0, 0, fmt.Errorf("%w: %v and %v", ErrSizeMismatch, ab.Size(), bb.Size())
+	}
+	sum := 0
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []int{
+				int(r1>>8) - int(r2>>8),
+				int(g1>>8) - int(g2>>8),
+				int(b1>>8) - int(b2>>8),
+				int(a1>>8) - int(a2>>8),
+			} {
+				if d < 0 {
+					d = -d
+				}
+				if d > max {
+					max = d
+				}
+				sum += d
+			}
+		}
+	}
+	if n := ab.Dx() * ab.Dy() * 4; n > 0 {
+		mean = float64(sum) / float64(n)
+	}
+	return max, mean, nil
+}
+
+// PSNR returns the peak signal-to-noise ratio in dB of the RGB channels of a
+// against b. It is +Inf for identical images.
+func PSNR(a, b image.Image) (float64, error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return 0, fmt.Errorf("%w: %v and %v", ErrSizeMismatch, ab.Size(), bb.Size())
+	}
+	if ab.Empty() {
+		return 0, ErrEmptyImage
+	}
+	sum := 0.0
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	mse := sum / float64(ab.Dx()*ab.Dy()*3)
+	return 10 * math.Log10(255*255/mse), nil
+}
+
+// Downscale shrinks the image by n with bilinear interpolation, e.g. to make
+// the input of a super-resolution benchmark from a ground truth image.
+func Downscale(img image.Image, n int) image.Image {
+	if n <= 1 {
+		return img
+	}
+	return resize.Resize(uint(img.Bounds().Dx()/n), uint(img.Bounds().Dy()/n), img, resize.Bilinear)
+}
+
+// DownscaleLinear shrinks the image by n averaging each n x n block in linear
+// light. Unlike Downscale, which averages the sRGB values, fine patterns keep
+// their brightness, so the shrunk image is a fairer input of a benchmark.
+func DownscaleLinear(img image.Image, n int) image.Image {
+	if n <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx()/n, b.Dy()/n))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+
+			// The colors are weighted by the alpha.
+			var sum [3]float64
+			var alpha float64
+			for i := 0; i < n; i++ {
+				for j := 0; j < n; j++ {
+					c := color.NRGBAModel.Convert(img.At(b.Min.X+x*n+j, b.Min.Y+y*n+i)).(color.NRGBA)
+					a := float64(c.A) / 255
+					for k, v := range [3]uint8{c.R, c.G, c.B} {
+						sum[k] += a * toLinear(float64(v)/255)
+					}
+					alpha += a
+				}
+			}
+			i := dst.PixOffset(x, y)
+			if alpha > 0 {
+				for k := range sum {
+					dst.Pix[i+k] = uint8(math.Round(255 * fromLinear(sum[k]/alpha)))
+				}
+			}
+			dst.Pix[i+3] = uint8(math.Round(255 * alpha / float64(n*n)))
+		}
+	}
+	return dst
+}
+
+// SSIM returns the mean structural similarity of the luma of a and b,
+// computed with an 11x11 Gaussian window. It is 1 for identical images.
+func SSIM(a, b image.Image) (float64, error) {
+	ab, bb := a.Bounds(), b.Bounds()
+	if ab.Size() != bb.Size() {
+		return 0, fmt.Errorf("%w: %v and %v", ErrSizeMismatch, ab.Size(), bb.Size())
+	}
+	if ab.Empty() {
+		return 0, ErrEmptyImage
+	}
+	var w Waifu2x
+	ya := w.extY(w.convertYCbCr(a))
+	yb := w.extY(w.convertYCbCr(b))
+
+	// The window is shrunk for images smaller than it.
+	size := 11
+	if ab.Dx() < size {
+		size = ab.Dx()
+	}
+	if ab.Dy() < size {
+		size = ab.Dy()
+	}
+	window := make([][]float64, size)
+	total := 0.0
+	for i := range window {
+		window[i] = make([]float64, size)
+		for j := range window[i] {
+			dy, dx := float64(i-size/2), float64(j-size/2)
+			window[i][j] = math.Exp(-(dx*dx + dy*dy) / (2 * 1.5 * 1.5))
+			total += window[i][j]
+		}
+	}
+
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	sum := 0.0
+	count := 0
+	for y := 0; y+size <= ab.Dy(); y++ {
+		for x := 0; x+size <= ab.Dx(); x++ {
+			var ma, mb, va, vb, cov float64
+			for i := 0; i < size; i++ {
+				for j := 0; j < size; j++ {
+					g := window[i][j] / total
+					pa, pb := float64(ya[y+i][x+j]), float64(yb[y+i][x+j])
+					ma += g * pa
+					mb += g * pb
+					va += g * pa * pa
+					vb += g * pb * pb
+					cov += g * pa * pb
+				}
+			}
+			va -= ma * ma
+			vb -= mb * mb
+			cov -= ma * mb
+			sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			count++
+		}
+	}
+	return sum / float64(count), nil
+}
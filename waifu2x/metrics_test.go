@@ -0,0 +1,141 @@
+package waifu2x
+
+import (
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	a := testImage(4, 4)
+	if max, mean, err := Compare(a, a); err != nil || max != 0 || mean != 0 {
+		t.Errorf("got %d, %f, %v, want 0, 0, nil", max, mean, err)
+	}
+
+	b := image.NewRGBA(a.Bounds())
+	copy(b.Pix, a.Pix)
+	c := b.RGBAAt(1, 2)
+	c.R += 8
+	b.SetRGBA(1, 2, c)
+	max, mean, err := Compare(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max != 8 || mean != 8.0/64 {
+		t.Errorf("got %d, %f, want 8, %f", max, mean, 8.0/64)
+	}
+
+	if _, _, err := Compare(a, image.NewGray(image.Rect(0, 0, 4, 3))); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want ErrSizeMismatch", err)
+	}
+
+	// Only the size matters, not the origin.
+	if _, _, err := Compare(image.NewGray(image.Rect(2, 2, 6, 6)), image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPSNR(t *testing.T) {
+	a := testImage(8, 8)
+	if psnr, err := PSNR(a, a); err != nil || !math.IsInf(psnr, 1) {
+		t.Errorf("got %f, %v, want +Inf", psnr, err)
+	}
+
+	// An error of 1 in every channel gives 10 * log10(255^2).
+	b := image.NewRGBA(a.Bounds())
+	for i := range a.Pix {
+		b.Pix[i] = a.Pix[i] ^ 1
+	}
+	psnr, err := PSNR(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 20 * math.Log10(255); math.Abs(psnr-expected) > 1e-9 {
+		t.Errorf("got %f, want %f", psnr, expected)
+	}
+
+	if _, err := PSNR(a, testImage(8, 4)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want ErrSizeMismatch", err)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	if size := Downscale(testImage(9, 6), 3).Bounds().Size(); size != image.Pt(3, 2) {
+		t.Errorf("got size %v, want (3,2)", size)
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if (x/4+y/4)%2 == 0 {
+				a.Pix[y*a.Stride+x] = 230
+			} else {
+				a.Pix[y*a.Stride+x] = 20
+			}
+		}
+	}
+	if ssim, err := SSIM(a, a); err != nil || math.Abs(ssim-1) > 1e-9 {
+		t.Errorf("got %f, %v, want 1", ssim, err)
+	}
+
+	// Box blur.
+	blurred := image.NewGray(a.Bounds())
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			sum, n := 0, 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if p := image.Pt(x+dx, y+dy); p.In(a.Bounds()) {
+						sum += int(a.GrayAt(p.X, p.Y).Y)
+						n++
+					}
+				}
+			}
+			blurred.Pix[y*blurred.Stride+x] = uint8(sum / n)
+		}
+	}
+	ssim, err := SSIM(a, blurred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssim >= 1 || ssim <= 0 {
+		t.Errorf("got %f for the blurred copy, want in (0, 1)", ssim)
+	}
+
+	if _, err := SSIM(a, testImage(8, 4)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want ErrSizeMismatch", err)
+	}
+}
+
+func TestDownscaleLinear(t *testing.T) {
+	checker := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				checker.Pix[y*checker.Stride+x] = 255
+			}
+		}
+	}
+
+	// Half of the light is mid-gray in sRGB, 188, while averaging the
+	// encoded values gives a darker 128.
+	linear := DownscaleLinear(checker, 2)
+	naive := Downscale(checker, 2)
+	if linear.Bounds().Size() != image.Pt(4, 4) {
+		t.Fatalf("got %v, want 4x4", linear.Bounds().Size())
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			r, _, _, a := linear.At(x, y).RGBA()
+			if v := r >> 8; v < 186 || v > 190 || a != 0xffff {
+				t.Fatalf("(%d, %d): got %d, want about 188 and opaque", x, y, v)
+			}
+			if r2, _, _, _ := naive.At(x, y).RGBA(); r2>>8 >= r>>8-40 {
+				t.Errorf("(%d, %d): got %d downscaled naively, want the darker value", x, y, r2>>8)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package waifu2x
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"path/filepath"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// SaveMipmaps saves the result shrunk to a half, a quarter and so on down to
+// 1x1, with Lanczos filtering. Each level is saved in the format of name,
+// with its size before the extension, e.g. dst_256x128.png. It returns the
+// names of the saved files, largest first.
+func (w *Waifu2x) SaveMipmaps(name string) ([]string, error) {
+	if w.dst == nil {
+		return nil, ErrEmptyImage
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	var names []string
+	for _, level := range mipmaps(w.dst) {
+		size := level.Bounds().Size()
+		levelName := fmt.Sprintf("%s_%dx%d%s", base, size.X, size.Y, ext)
+		if err := w.save(levelName, level, nil); err != nil {
+			return names, err
+		}
+		names = append(names, levelName)
+	}
+	return names, nil
+}
+
+func mipmaps(img *image.RGBA) []*image.RGBA {
+
+	// Each level is shrunk from the previous one, halving each side until
+	// both are 1.
+
+	var levels []*image.RGBA
+	for size := img.Bounds().Size(); size.X > 1 || size.Y > 1; {
+		size = image.Pt(size.X/2, size.Y/2)
+		if size.X < 1 {
+			size.X = 1
+		}
+		if size.Y < 1 {
+			size.Y = 1
+		}
+		shrunk := resize.Resize(uint(size.X), uint(size.Y), img, resize.Lanczos3)
+		level, ok := shrunk.(*image.RGBA)
+		if !ok {
+			level = image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+			draw.Draw(level, level.Bounds(), shrunk, shrunk.Bounds().Min, draw.Src)
+		}
+		levels = append(levels, level)
+		img = level
+	}
+	return levels
+}
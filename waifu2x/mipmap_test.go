@@ -0,0 +1,48 @@
+package waifu2x
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveMipmaps(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(10, 4)}
+	if _, err := w.SaveMipmaps("dst.png"); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("got %v before Exec, want %v", err, ErrEmptyImage)
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	names, err := w.SaveMipmaps(filepath.Join(dir, "dst.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := []image.Point{{10, 4}, {5, 2}, {2, 1}, {1, 1}}
+	var want []string
+	for _, n := range []string{"dst_10x4.png", "dst_5x2.png", "dst_2x1.png", "dst_1x1.png"} {
+		want = append(want, filepath.Join(dir, n))
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	for i, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := image.Pt(cfg.Width, cfg.Height); s != sizes[i] {
+			t.Errorf("%s: got size %v, want %v", name, s, sizes[i])
+		}
+	}
+}
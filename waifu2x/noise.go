@@ -0,0 +1,139 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"math"
+)
+
+// jpegLumaTable is the luminance quantization table of the JPEG standard,
+// which encoders scale by the quality.
+var jpegLumaTable = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// EstimateNoise returns the standard deviation of the noise of the luma in
+// [0, 255], estimated by Immerkaer's method: the mean absolute response to a
+// kernel which cancels out edges and gradients.
+func EstimateNoise(img image.Image) float64 {
+	var w Waifu2x
+	y := w.extY(w.convertYCbCr(img))
+	height := len(y)
+	if height < 3 || len(y[0]) < 3 {
+		return 0
+	}
+	width := len(y[0])
+	kernel := [3][3]float64{{1, -2, 1}, {-2, 4, -2}, {1, -2, 1}}
+	sum := 0.0
+	for i := 1; i < height-1; i++ {
+		for j := 1; j < width-1; j++ {
+			v := 0.0
+			for ki := 0; ki < 3; ki++ {
+				for kj := 0; kj < 3; kj++ {
+					v += kernel[ki][kj] * float64(y[i+ki-1][j+kj-1])
+				}
+			}
+			sum += math.Abs(v)
+		}
+	}
+	return math.Sqrt(math.Pi/2) * sum / (6 * float64((width-2)*(height-2)))
+}
+
+// JPEGQuality estimates the quality the JPEG file was saved with from its
+// luminance quantization table, on the scale of libjpeg. It returns false if
+// b isn't a JPEG file or has no such table.
+func JPEGQuality(b []byte) (int, bool) {
+	if !bytes.HasPrefix(b, []byte{0xff, 0xd8}) {
+		return 0, false
+	}
+
+	// Find the table 0 in the DQT segments before the scan.
+	for i := 2; i+4 <= len(b) && b[i] == 0xff; {
+		marker := b[i+1]
+		length := int(b[i+2])<<8 | int(b[i+3])
+		if marker == 0xda || i+2+length > len(b) {
+			break
+		}
+		if marker == 0xdb {
+			seg := b[i+4 : i+2+length]
+			for len(seg) > 0 {
+				precision, id := seg[0]>>4, seg[0]&0xf
+				size := 64
+				if precision != 0 {
+					size = 128
+				}
+				if len(seg) < 1+size {
+					break
+				}
+				if id == 0 {
+					return quality(seg[1:1+size], precision != 0), true
+				}
+				seg = seg[1+size:]
+			}
+		}
+		i += 2 + length
+	}
+	return 0, false
+}
+
+func quality(table []byte, wide bool) int {
+
+	// The tables are the standard one scaled by 5000/q percent below 50 and
+	// 200-2q percent above. The order of the values doesn't matter for the
+	// sums.
+
+	sum, std := 0, 0
+	for i := 0; i < 64; i++ {
+		v := int(table[i])
+		if wide {
+			v = int(table[2*i])<<8 | int(table[2*i+1])
+		}
+		sum += v
+		std += jpegLumaTable[i]
+	}
+	scale := float64(sum) * 100 / float64(std)
+	var q float64
+	if scale <= 100 {
+		q = (200 - scale) / 2
+	} else {
+		q = 5000 / scale
+	}
+	return int(math.Round(math.Max(1, math.Min(100, q))))
+}
+
+// NoiseLevel returns the noise level of the denoising model suitable for
+// the image, from 0 for no denoising to 3. data is the encoded image, whose
+// JPEG quantization table, if any, is taken into account.
+func NoiseLevel(img image.Image, data []byte) int {
+	level := 0
+	switch sigma := EstimateNoise(img); {
+	case sigma >= 6:
+		level = 3
+	case sigma >= 3:
+		level = 2
+	case sigma >= 1.5:
+		level = 1
+	}
+	if q, ok := JPEGQuality(data); ok {
+		jpegLevel := 0
+		switch {
+		case q < 70:
+			jpegLevel = 3
+		case q < 85:
+			jpegLevel = 2
+		case q < 95:
+			jpegLevel = 1
+		}
+		if jpegLevel > level {
+			level = jpegLevel
+		}
+	}
+	return level
+}
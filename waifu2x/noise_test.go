@@ -0,0 +1,75 @@
+package waifu2x
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func noisyImage(width, height int, sigma float64) *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := testImage(width, height)
+	for i := range img.Pix {
+		if i%4 == 3 {
+			continue
+		}
+		v := float64(img.Pix[i]) + rng.NormFloat64()*sigma
+		img.Pix[i] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	return img
+}
+
+func TestEstimateNoise(t *testing.T) {
+	if sigma := EstimateNoise(testImage(64, 64)); sigma >= 1.5 {
+		t.Errorf("got %.2f for a clean image, want < 1.5", sigma)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 64, 64))
+	rng := rand.New(rand.NewSource(1))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(128 + math.Round(rng.NormFloat64()*8))
+	}
+	if sigma := EstimateNoise(gray); math.Abs(sigma-8) > 1 {
+		t.Errorf("got %.2f for noise of 8, want about 8", sigma)
+	}
+	if sigma := EstimateNoise(image.NewGray(image.Rect(0, 0, 2, 2))); sigma != 0 {
+		t.Errorf("got %.2f for a 2x2 image, want 0", sigma)
+	}
+}
+
+func TestJPEGQuality(t *testing.T) {
+	for _, want := range []int{30, 50, 75, 90} {
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, testImage(16, 16), &jpeg.Options{Quality: want}); err != nil {
+			t.Fatal(err)
+		}
+		q, ok := JPEGQuality(b.Bytes())
+		if !ok || q < want-1 || q > want+1 {
+			t.Errorf("got %d, %v, want %d", q, ok, want)
+		}
+	}
+	if _, ok := JPEGQuality(encodePNG(t, testImage(4, 4))); ok {
+		t.Error("got a quality for a PNG image")
+	}
+}
+
+func TestNoiseLevel(t *testing.T) {
+	clean := testImage(64, 64)
+	if level := NoiseLevel(clean, nil); level != 0 {
+		t.Errorf("got level %d for a clean image, want 0", level)
+	}
+	if level := NoiseLevel(noisyImage(64, 64, 20), nil); level != 3 {
+		t.Errorf("got level %d for a noisy image, want 3", level)
+	}
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, clean, &jpeg.Options{Quality: 80}); err != nil {
+		t.Fatal(err)
+	}
+	if level := NoiseLevel(clean, b.Bytes()); level != 2 {
+		t.Errorf("got level %d for JPEG quality 80, want 2", level)
+	}
+}
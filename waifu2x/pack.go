@@ -0,0 +1,116 @@
+package waifu2x
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+)
+
+// PackManifest is the manifest.json of a .w2xpack file.
+type PackManifest struct {
+	// Scale is the scale of the output, a power of 2. Zero means 2.
+	Scale int `json:"scale"`
+	// Noise is the noise level to reduce. Zero means no denoising.
+	Noise int `json:"noise"`
+	// Models are the models in the pack.
+	Models []PackModel `json:"models"`
+}
+
+// PackModel is a model in a .w2xpack file.
+type PackModel struct {
+	// File is the name of the model file in the pack.
+	File string `json:"file"`
+	// Noise is the noise level the model reduces, or zero for scale models.
+	Noise int `json:"noise"`
+}
+
+// Pack is a bundle of models and the settings to apply them.
+type Pack struct {
+	Scale int
+	Noise int
+
+	noise []Model
+	scale []Model
+}
+
+// LoadPack loads a .w2xpack file, which is a zip file of models and
+// manifest.json specifying the scale and the noise level.
+func LoadPack(path string) (*Pack, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+	readFile := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in %s", ErrInvalidModel, name, path)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	b, err := readFile("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var manifest PackManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidModel, err)
+	}
+	if manifest.Scale == 0 {
+		manifest.Scale = 2
+	}
+	if manifest.Scale < 2 || bits.OnesCount(uint(manifest.Scale)) != 1 {
+		return nil, fmt.Errorf("%w: scale %d is not a power of 2", ErrInvalidModel, manifest.Scale)
+	}
+
+	// Select the scale model and the model of the noise level.
+	p := &Pack{Scale: manifest.Scale, Noise: manifest.Noise}
+	for _, m := range manifest.Models {
+		if m.Noise != 0 && m.Noise != manifest.Noise {
+			continue
+		}
+		b, err := readFile(m.File)
+		if err != nil {
+			return nil, err
+		}
+		models, err := parseModel(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.File, err)
+		}
+		if m.Noise == 0 {
+			p.scale = models
+		} else {
+			p.noise = models
+		}
+	}
+	if p.scale == nil {
+		return nil, fmt.Errorf("%w: no scale model in %s", ErrInvalidModel, path)
+	}
+	if p.Noise != 0 && p.noise == nil {
+		return nil, fmt.Errorf("%w: no model of noise level %d in %s", ErrInvalidModel, p.Noise, path)
+	}
+	return p, nil
+}
+
+// Stages returns Waifu2x configured for each model of the pack in the order
+// to apply them. The image is given to the first one.
+func (p *Pack) Stages() []*Waifu2x {
+	var stages []*Waifu2x
+	if p.noise != nil {
+		stages = append(stages, &Waifu2x{models: p.noise, Denoise: true})
+	}
+	return append(stages, &Waifu2x{models: p.scale, Passes: bits.TrailingZeros(uint(p.Scale))})
+}
@@ -0,0 +1,95 @@
+package waifu2x
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePack(t *testing.T, manifest string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "model.w2xpack")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	b, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"manifest.json":        []byte(manifest),
+		"noise1_model.json":    b,
+		"noise2_model.json":    b,
+		"scale2.0x_model.json": b,
+	}
+	for name, data := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPack(t *testing.T) {
+	p, err := LoadPack(writePack(t, `{
+		"scale": 4,
+		"noise": 2,
+		"models": [
+			{"file": "noise1_model.json", "noise": 1},
+			{"file": "noise2_model.json", "noise": 2},
+			{"file": "scale2.0x_model.json"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Scale != 4 || p.Noise != 2 {
+		t.Errorf("got scale %d and noise %d, want 4 and 2", p.Scale, p.Noise)
+	}
+	stages := p.Stages()
+	if len(stages) != 2 {
+		t.Fatalf("got %d stages, want 2", len(stages))
+	}
+	if !stages[0].Denoise || stages[1].Denoise || stages[1].Passes != 2 {
+		t.Errorf("got stages %+v, want denoising and 2 passes of scaling", stages)
+	}
+
+	stages[0].SetImage(testImage(3, 2))
+	for i, w := range stages {
+		if i > 0 {
+			w.SetImage(stages[i-1].Result())
+		}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := stages[1].Result().Bounds().Size(); size.X != 12 || size.Y != 8 {
+		t.Errorf("got size %v, want (12,8)", size)
+	}
+}
+
+func TestLoadPackInvalid(t *testing.T) {
+	for _, manifest := range []string{
+		`{"scale": 3, "models": [{"file": "scale2.0x_model.json"}]}`,
+		`{"noise": 3, "models": [{"file": "noise1_model.json", "noise": 1}, {"file": "scale2.0x_model.json"}]}`,
+		`{"models": [{"file": "missing.json"}]}`,
+		`{`,
+	} {
+		if _, err := LoadPack(writePack(t, manifest)); !errors.Is(err, ErrInvalidModel) {
+			t.Errorf("%s: got %v, want ErrInvalidModel", manifest, err)
+		}
+	}
+}
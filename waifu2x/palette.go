@@ -0,0 +1,137 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// maxPaletteColors is the largest palette of an indexed PNG image.
+const maxPaletteColors = 256
+
+// paletteColor is a color of the image and the number of its pixels.
+type paletteColor struct {
+	c     [4]uint8
+	count int
+}
+
+// Quantize reduces the image to at most n colors by median cut, splitting
+// the box of colors with the widest channel at the median pixel until there
+// are n boxes, and maps each pixel to the mean of its box. n is clamped to
+// [1, 256], the palette of an indexed PNG image.
+func Quantize(img image.Image, n int) *image.Paletted {
+	if n < 1 {
+		n = 1
+	}
+	if n > maxPaletteColors {
+		n = maxPaletteColors
+	}
+
+	// Count the distinct colors.
+	bounds := img.Bounds()
+	counts := map[[4]uint8]int{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			counts[[4]uint8{c.R, c.G, c.B, c.A}]++
+		}
+	}
+	colors := make([]paletteColor, 0, len(counts))
+	for c, count := range counts {
+		colors = append(colors, paletteColor{c, count})
+	}
+
+	// Sort for a reproducible palette, since the order of a map isn't.
+	sort.Slice(colors, func(i, j int) bool {
+		a, b := colors[i].c, colors[j].c
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	boxes := [][]paletteColor{colors}
+	for len(boxes) < n {
+		i, channel := widestBox(boxes)
+		if i < 0 {
+			break
+		}
+		lo, hi := splitBox(boxes[i], channel)
+		boxes[i] = lo
+		boxes = append(boxes, hi)
+	}
+
+	palette := make(color.Palette, len(boxes))
+	index := make(map[[4]uint8]uint8, len(colors))
+	for i, box := range boxes {
+		var sum [4]int
+		total := 0
+		for _, pc := range box {
+			for k := range sum {
+				sum[k] += int(pc.c[k]) * pc.count
+			}
+			total += pc.count
+			index[pc.c] = uint8(i)
+		}
+		var mean [4]uint8
+		for k := range sum {
+			mean[k] = uint8((sum[k] + total/2) / total)
+		}
+		palette[i] = color.RGBA{mean[0], mean[1], mean[2], mean[3]}
+	}
+
+	dst := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			dst.SetColorIndex(x, y, index[[4]uint8{c.R, c.G, c.B, c.A}])
+		}
+	}
+	return dst
+}
+
+// widestBox returns the box with the widest range of a channel and the
+// channel, or -1 when every box has a single color.
+func widestBox(boxes [][]paletteColor) (int, int) {
+	best, channel, width := -1, 0, 0
+	for i, box := range boxes {
+		if len(box) < 2 {
+			continue
+		}
+		for k := 0; k < 4; k++ {
+			lo, hi := box[0].c[k], box[0].c[k]
+			for _, pc := range box {
+				if pc.c[k] < lo {
+					lo = pc.c[k]
+				}
+				if pc.c[k] > hi {
+					hi = pc.c[k]
+				}
+			}
+			if d := int(hi-lo) + 1; d > width {
+				best, channel, width = i, k, d
+			}
+		}
+	}
+	return best, channel
+}
+
+// splitBox sorts the box by the channel and splits it at the median pixel,
+// keeping at least one color on each side.
+func splitBox(box []paletteColor, channel int) ([]paletteColor, []paletteColor) {
+	sort.SliceStable(box, func(i, j int) bool { return box[i].c[channel] < box[j].c[channel] })
+	total := 0
+	for _, pc := range box {
+		total += pc.count
+	}
+	half, i := 0, 0
+	for ; i < len(box)-1; i++ {
+		half += box[i].count
+		if 2*half >= total {
+			break
+		}
+	}
+	return box[: i+1 : i+1], box[i+1:]
+}
@@ -0,0 +1,63 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavePalette(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(17, 11), Palette: 8}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "out.png")
+	if err := w.SaveImage(name); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("got %T, want an indexed PNG", img)
+	}
+	colors := map[color.Color]bool{}
+	for y := 0; y < p.Bounds().Dy(); y++ {
+		for x := 0; x < p.Bounds().Dx(); x++ {
+			colors[p.At(x, y)] = true
+		}
+	}
+	if len(colors) > 8 || len(p.Palette) > 8 {
+		t.Errorf("got %d colors and a palette of %d, want at most 8", len(colors), len(p.Palette))
+	}
+	if p.Bounds().Size() != image.Pt(34, 22) {
+		t.Errorf("got %v, want 34x22", p.Bounds().Size())
+	}
+}
+
+func TestQuantizeKeepsFewColors(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 128, 0, 255}, {10, 20, 30, 255}}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			img.SetRGBA(x, y, colors[(x+y)%len(colors)])
+		}
+	}
+	p := Quantize(img, 4)
+	if len(p.Palette) != len(colors) {
+		t.Errorf("got a palette of %d, want %d", len(p.Palette), len(colors))
+	}
+	if !sameImage(p, img) {
+		t.Error("the colors of the image changed")
+	}
+}
@@ -0,0 +1,52 @@
+package waifu2x
+
+import "image"
+
+// Planes returns the Y, Cb and Cr channels of the reconstructed image as
+// gray images. Exec must be called before.
+func (w *Waifu2x) Planes() (y, cb, cr *image.Gray, err error) {
+	if w.dst == nil {
+		return nil, nil, nil, ErrEmptyImage
+	}
+	c := w.convertYCbCr(w.dst)
+	bounds := image.Rect(0, 0, w.dst.Bounds().Dx(), w.dst.Bounds().Dy())
+	y, cb, cr = image.NewGray(bounds), image.NewGray(bounds), image.NewGray(bounds)
+	for i := range c {
+		for j, v := range c[i] {
+			y.Pix[i*y.Stride+j] = v.Y
+			cb.Pix[i*cb.Stride+j] = v.Cb
+			cr.Pix[i*cr.Stride+j] = v.Cr
+		}
+	}
+	return y, cb, cr, nil
+}
+
+// SourceChroma returns the Cb and Cr channels of the image given to the model,
+// at its resolution, e.g. to be combined with the result of LumaOnly
+// downstream.
+func (w *Waifu2x) SourceChroma() (cb, cr *image.Gray, err error) {
+	if w.src == nil {
+		return nil, nil, ErrEmptyImage
+	}
+	c := w.convertYCbCr(w.src)
+	bounds := image.Rect(0, 0, w.src.Bounds().Dx(), w.src.Bounds().Dy())
+	cb, cr = image.NewGray(bounds), image.NewGray(bounds)
+	for i := range c {
+		for j, v := range c[i] {
+			cb.Pix[i*cb.Stride+j] = v.Cb
+			cr.Pix[i*cr.Stride+j] = v.Cr
+		}
+	}
+	return cb, cr, nil
+}
+
+func (w *Waifu2x) lumaImage(img *image.RGBA) *image.Gray {
+	c := w.convertYCbCr(img)
+	gray := image.NewGray(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	for i := range c {
+		for j, v := range c[i] {
+			gray.Pix[i*gray.Stride+j] = v.Y
+		}
+	}
+	return gray
+}
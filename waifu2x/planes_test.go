@@ -0,0 +1,110 @@
+package waifu2x
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanes(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}}
+	if _, _, _, err := w.Planes(); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("got %v before Exec, want %v", err, ErrEmptyImage)
+	}
+
+	w.src = testImage(5, 3)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	y, cb, cr, err := w.Planes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*image.Gray{y, cb, cr} {
+		if p.Bounds() != image.Rect(0, 0, 10, 6) {
+			t.Errorf("got bounds %v, want (0,0)-(10,6)", p.Bounds())
+		}
+	}
+	r, g, b, _ := w.Result().At(3, 2).RGBA()
+	wy, wcb, wcr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	if y.GrayAt(3, 2).Y != wy || cb.GrayAt(3, 2).Y != wcb || cr.GrayAt(3, 2).Y != wcr {
+		t.Errorf("got (%d, %d, %d), want (%d, %d, %d)", y.GrayAt(3, 2).Y, cb.GrayAt(3, 2).Y, cr.GrayAt(3, 2).Y, wy, wcb, wcr)
+	}
+}
+
+func TestSaveLumaOnly(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(32, 32)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	y, _, _, err := w.Planes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ext := range []string{".jpg", ".png"} {
+		colorName := filepath.Join(dir, "color"+ext)
+		grayName := filepath.Join(dir, "gray"+ext)
+		w.LumaOnly = false
+		if err := w.SaveImage(colorName); err != nil {
+			t.Fatal(err)
+		}
+		w.LumaOnly = true
+		if err := w.SaveImage(grayName); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(grayName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, ok := img.(*image.Gray)
+		if !ok {
+			t.Fatalf("%s: decoded %T, want *image.Gray", ext, img)
+		}
+		if ext == ".png" && !bytes.Equal(g.Pix, y.Pix) {
+			t.Errorf("%s: the pixels differ from the luma", ext)
+		}
+
+		colorInfo, _ := os.Stat(colorName)
+		grayInfo, _ := os.Stat(grayName)
+		if grayInfo.Size() >= colorInfo.Size() {
+			t.Errorf("%s: got %d bytes, want less than %d of the colorName image", ext, grayInfo.Size(), colorInfo.Size())
+		}
+	}
+}
+
+func TestSourceChroma(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}}
+	if _, _, err := w.SourceChroma(); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("got %v without an image, want %v", err, ErrEmptyImage)
+	}
+	w.SetImage(testImage(6, 4))
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	cb, cr, err := w.SourceChroma()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*image.Gray{cb, cr} {
+		if p.Bounds() != image.Rect(0, 0, 6, 4) {
+			t.Errorf("got chroma of %v, want the size of the source", p.Bounds())
+		}
+	}
+	want := w.convertYCbCr(testImage(6, 4))[2][3]
+	if cb.GrayAt(3, 2).Y != want.Cb || cr.GrayAt(3, 2).Y != want.Cr {
+		t.Errorf("got Cb %d and Cr %d, want %d and %d", cb.GrayAt(3, 2).Y, cr.GrayAt(3, 2).Y, want.Cb, want.Cr)
+	}
+}
@@ -0,0 +1,96 @@
+package waifu2x
+
+import (
+	"image"
+	"sync"
+)
+
+// progress counts the work done as the pixels each convolution is computed
+// for, so the fraction is the same across tiles, passes and stages of
+// different sizes.
+type progress struct {
+	mu     sync.Mutex
+	done   int64
+	total  int64
+	report func(fraction float64)
+}
+
+func newProgress(total int64, report func(float64)) *progress {
+	if report == nil {
+		report = func(float64) {}
+	}
+	return &progress{total: total, report: report}
+}
+
+// The methods do nothing on nil, for planes reconstructed outside Exec.
+func (p *progress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done += n; p.done > p.total {
+		p.done = p.total
+	}
+	if p.total > 0 {
+		p.report(float64(p.done) / float64(p.total))
+	}
+}
+
+// position and rewind undo the work of tiles that are retried.
+func (p *progress) position() int64 {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+func (p *progress) rewind(done int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = done
+}
+
+// convolutions returns the number of convolutions of a pixel by the models.
+func convolutions(models []Model) int64 {
+	var n int64
+	for _, m := range models {
+		n += int64(m.NInputPlane * m.NOutputPlane)
+	}
+	return n
+}
+
+// work returns the work of Exec for an image of the size in the unit of
+// progress, and the size of the result. hdr tells whether the image is
+// processed in floating point, in which case there is no chroma model nor
+// preview.
+func (w *Waifu2x) work(size image.Point, hdr bool) (int64, image.Point, error) {
+	passes, cw, ch, err := w.outputSize(size.X, size.Y)
+	if err != nil {
+		return 0, image.Point{}, err
+	}
+	if w.previewing(size.X, size.Y) && !hdr {
+		size = size.Div(w.Preview)
+	}
+	perPixel := convolutions(w.models)
+	if !hdr && w.hasChromaModel() {
+		perPixel += 2 * convolutions(w.chromaModels)
+	}
+	if w.TTA {
+		perPixel *= 8
+	}
+	var total int64
+	x, y := int64(size.X), int64(size.Y)
+	for i := 0; i < passes; i++ {
+		if !w.Denoise {
+			x, y = x*2, y*2
+		}
+		total += perPixel * x * y
+	}
+	return total, image.Pt(cw, ch), nil
+}
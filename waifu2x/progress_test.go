@@ -0,0 +1,102 @@
+package waifu2x
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestModelChainProgress(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var fractions []float64
+	noise := &Waifu2x{
+		models:   randomModel(rng, 1, 2, 1),
+		src:      testImage(20, 12),
+		Denoise:  true,
+		TileSize: 16,
+		Progress: func(f float64) { fractions = append(fractions, f) },
+	}
+	scale := &Waifu2x{
+		models:       randomModel(rng, 1, 4, 1),
+		chromaModels: randomModel(rng, 1, 1),
+		TileSize:     16,
+		TileWorkers:  2,
+		TargetWidth:  80,
+	}
+	if err := NewModelChain(noise, scale).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fractions) == 0 {
+		t.Fatal("no progress")
+	}
+	for i, f := range fractions {
+		if f > 1 {
+			t.Fatalf("got fraction %v > 1", f)
+		}
+		if i > 0 && f <= fractions[i-1] {
+			t.Fatalf("fraction %v after %v", f, fractions[i-1])
+		}
+	}
+	if last := fractions[len(fractions)-1]; last != 1 {
+		t.Errorf("got last fraction %v, want 1", last)
+	}
+
+	// The denoising stage is a small part of the work of the two passes
+	// of the scale stage.
+	noiseCalls := 2 * 2 * 2
+	if f := fractions[noiseCalls-1]; f > 0.05 {
+		t.Errorf("got fraction %v after the denoising stage, want it weighted by its size", f)
+	}
+}
+
+func TestTileProgress(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(2)), 1, 4, 1)
+	for _, deadline := range []time.Time{{}, time.Now().Add(-time.Second)} {
+		var fractions []float64
+		w := &Waifu2x{
+			models:       models,
+			src:          testImage(8, 8),
+			TileSize:     8,
+			SoftDeadline: deadline,
+			Progress:     func(f float64) { fractions = append(fractions, f) },
+		}
+		// The 16x16 upscaled image is 4 tiles.
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(fractions) == 0 {
+			t.Fatal("no progress")
+		}
+		for i, f := range fractions {
+			if i > 0 && f < fractions[i-1] {
+				t.Fatalf("fraction %v after %v", f, fractions[i-1])
+			}
+		}
+		if last := fractions[len(fractions)-1]; last != 1 {
+			t.Errorf("deadline %v: got last fraction %v, want 1", deadline, last)
+		}
+	}
+}
+
+func TestProgressSilent(t *testing.T) {
+	r, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stderr = f }(os.Stderr)
+	os.Stderr = wr
+
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(20, 12)}
+	err = w.Exec()
+	os.Stderr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); len(b) > 0 {
+		t.Errorf("got %q on stderr without Progress, want nothing", b)
+	}
+}
@@ -0,0 +1,125 @@
+package waifu2x
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// ExecRegion updates prev, the result of a previous Exec, after the image
+// was changed within the changed rectangle. Only the changed region and the
+// margin the layers of the model see around it are processed, and the result
+// is composited over prev, so the pixels outside the affected region are
+// those of prev. It only supports a single pass without a target size, and
+// AutoLevels is computed from the processed region.
+func (w *Waifu2x) ExecRegion(ctx context.Context, prev image.Image, changed image.Rectangle) error {
+	scale, err := w.singlePass("ExecRegion")
+	if err != nil {
+		return err
+	}
+	bounds := w.src.Bounds()
+	cw, ch := bounds.Dx()*scale, bounds.Dy()*scale
+	if size := prev.Bounds().Size(); size != image.Pt(cw, ch) {
+		return fmt.Errorf("%w: previous result %v for %v", ErrSizeMismatch, size, image.Pt(cw, ch))
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), prev, prev.Bounds().Min, draw.Src)
+	r := changed.Intersect(bounds).Sub(bounds.Min)
+	if r.Empty() {
+		w.dst = dst
+		return nil
+	}
+
+	// The changed region affects the pixels the interpolation spreads it
+	// to, and the pixels within the margin of the model of them.
+	r = r.Inset(-w.interpolationReach())
+	out, crop, err := w.execAround(ctx, r.Inset(-w.modelMargin()), scale)
+	if err != nil {
+		return err
+	}
+	affected := image.Rectangle{r.Min.Mul(scale), r.Max.Mul(scale)}.Inset(-w.modelMargin()).Intersect(dst.Bounds())
+	draw.Draw(dst, affected, out, affected.Min.Sub(crop.Min.Mul(scale)), draw.Src)
+	w.dst = dst
+	w.passes = 1
+	return nil
+}
+
+// singlePass returns the scale of the model, checking that the image is
+// processed in a single pass without a target size as the function needs.
+func (w *Waifu2x) singlePass(function string) (int, error) {
+	if w.src == nil {
+		return 0, ErrEmptyImage
+	}
+	width, height := w.src.Bounds().Dx(), w.src.Bounds().Dy()
+	scale := 2
+	if w.Denoise {
+		scale = 1
+	}
+	passes, cw, ch, err := w.outputSize(width, height)
+	if err != nil {
+		return 0, err
+	}
+	if passes != 1 || cw != width*scale || ch != height*scale {
+		return 0, fmt.Errorf("waifu2x: %s needs a single pass without a target size", function)
+	}
+	return scale, nil
+}
+
+// execAround processes the pixels of the image around r, relative to the
+// bounds of the image, with the context the output pixels of r need, and
+// returns the result with the rectangle of the image that was processed.
+// The output pixels of r are computed from the same inputs as a full Exec.
+func (w *Waifu2x) execAround(ctx context.Context, r image.Rectangle, scale int) (*image.RGBA, image.Rectangle, error) {
+
+	// The output pixels are computed from the pixels within the margin of
+	// them, and one more pixel for the nearest neighbor upscaling, or the
+	// taps of the bicubic one. The interpolation is the one of the whole
+	// image.
+
+	bounds := w.src.Bounds()
+	reach := (w.modelMargin()+scale-1)/scale + 1 + w.interpolationReach()
+	crop := r.Inset(-reach).Intersect(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	sub := *w
+	sub.Interpolation = w.interpolation(w.src)
+	src := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(src, src.Bounds(), w.src, crop.Min.Add(bounds.Min), draw.Src)
+	sub.src = src
+	sub.preUpscaled = nil
+	out, err := sub.exec(ctx, nil)
+	if err != nil {
+		return nil, image.Rectangle{}, err
+	}
+	w.clipped, w.values = sub.clipped, sub.values
+	w.approximate = sub.approximate
+	return out, crop, nil
+}
+
+// interpolationReach returns how far the upscaling of the image reaches, in
+// the pixels of the image.
+func (w *Waifu2x) interpolationReach() int {
+	if w.Denoise {
+		return 0
+	}
+	return w.interpolation(w.src).reach()
+}
+
+// modelMargin returns how far the pixels each output pixel is computed from
+// reach, in the pixels given to the model.
+func (w *Waifu2x) modelMargin() int {
+	margin := 0
+	for _, models := range [][]Model{w.models, w.chromaModels} {
+		if reach := receptiveField(models); reach > margin {
+			margin = reach
+		}
+	}
+	if w.PreDenoise {
+		margin++
+	}
+	if w.GuidedChroma {
+		// The window and the block of the luma compared with.
+		margin += guidedRadius + 1
+	}
+	return margin
+}
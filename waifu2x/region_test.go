@@ -0,0 +1,88 @@
+package waifu2x
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestExecRegion(t *testing.T) {
+	for _, tc := range []struct {
+		denoise bool
+		interp  InterpolationMode
+		spread  int
+	}{
+		{false, NearestNeighbor, 0},
+		{true, NearestNeighbor, 0},
+
+		// The interpolations spread the change by the radius of their
+		// kernels.
+		{false, Bilinear, 1},
+		{false, Bicubic, 2},
+		{false, Lanczos, 3},
+	} {
+		denoise := tc.denoise
+		src := testImage(24, 20)
+		w := &Waifu2x{models: []Model{boxModel(), boxModel()}, src: src, Denoise: denoise, Interpolation: tc.interp}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		prev := w.Result()
+
+		changed := image.Rect(8, 6, 12, 9)
+		edited := image.NewRGBA(src.Bounds())
+		copy(edited.Pix, src.Pix)
+		for y := changed.Min.Y; y < changed.Max.Y; y++ {
+			for x := changed.Min.X; x < changed.Max.X; x++ {
+				edited.Set(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+
+		w.SetImage(edited)
+		if err := w.ExecRegion(context.Background(), prev, changed); err != nil {
+			t.Fatal(err)
+		}
+		got := w.Result()
+		full := &Waifu2x{models: w.models, src: edited, Denoise: denoise, Interpolation: tc.interp}
+		if err := full.Exec(); err != nil {
+			t.Fatal(err)
+		}
+
+		scale := 2
+		if denoise {
+			scale = 1
+		}
+		affected := image.Rectangle{changed.Min.Mul(scale), changed.Max.Mul(scale)}.Inset(-2 - tc.spread*scale)
+		differs := false
+		for y := 0; y < got.Bounds().Dy(); y++ {
+			for x := 0; x < got.Bounds().Dx(); x++ {
+				p := image.Pt(x, y)
+				if got.RGBAAt(x, y) != full.Result().RGBAAt(x, y) {
+					t.Fatalf("denoise %v, interpolation %d: got %v at %v, want %v of a full Exec", denoise, tc.interp, got.RGBAAt(x, y), p, full.Result().RGBAAt(x, y))
+				}
+				if got.RGBAAt(x, y) != prev.RGBAAt(x, y) {
+					if !p.In(affected) {
+						t.Fatalf("denoise %v, interpolation %d: %v outside %v differs from the previous result", denoise, tc.interp, p, affected)
+					}
+					differs = true
+				}
+			}
+		}
+		if !differs {
+			t.Errorf("denoise %v, interpolation %d: the changed region is the same as the previous result", denoise, tc.interp)
+		}
+	}
+}
+
+func TestExecRegionErrors(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(4, 4)}
+	if err := w.ExecRegion(context.Background(), image.NewRGBA(image.Rect(0, 0, 4, 4)), image.Rect(0, 0, 1, 1)); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want %v", err, ErrSizeMismatch)
+	}
+	w.TargetWidth = 32
+	if err := w.ExecRegion(context.Background(), image.NewRGBA(image.Rect(0, 0, 32, 32)), image.Rect(0, 0, 1, 1)); err == nil {
+		t.Error("got no error for two passes")
+	}
+}
@@ -0,0 +1,54 @@
+package waifu2x
+
+import (
+	_ "embed"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// selfTestModel is a tiny hand-built model which splits the luma into two
+// planes and averages them back, so its output is known without training.
+//
+//go:embed selftest_model.json
+var selfTestModel []byte
+
+// selfTestTolerance is the maximum channel difference from the input resized
+// by nearest neighbor allowed in SelfTest. It covers the rounding of the
+// YCbCr conversion.
+const selfTestTolerance = 2
+
+// SelfTest processes a synthetic gradient with a tiny built-in model and
+// checks the size and the values of the result. It needs no files.
+func SelfTest() error {
+	models, err := parseModel(selfTestModel)
+	if err != nil {
+		return err
+	}
+	src := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 10), 128, 255})
+		}
+	}
+
+	w := &Waifu2x{models: models, src: src}
+	if err := w.Exec(); err != nil {
+		return err
+	}
+	if size := w.dst.Bounds().Size(); size != image.Pt(64, 48) {
+		return fmt.Errorf("waifu2x: self-test: output size is %v, want (64,48)", size)
+	}
+	want, err := w.upscale(src, w.interpolation(src))
+	if err != nil {
+		return err
+	}
+	max, _, err := Compare(w.dst, want)
+	if err != nil {
+		return err
+	}
+	if max > selfTestTolerance {
+		return fmt.Errorf("waifu2x: self-test: output differs from the input by %d", max)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// srgbPrimaries are the primaries of sRGB adapted to D50, as in its ICC
+// profile.
+var srgbPrimaries = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+// toSRGB returns the matrix converting the linear values of the profile to
+// linear sRGB through the XYZ coordinates.
+func (p *rgbProfile) toSRGB() [3][3]float64 {
+	m := invert3(transpose3(srgbPrimaries))
+	src := transpose3(p.primaries)
+	var res [3][3]float64
+	for i := range res {
+		for j := range res[i] {
+			for k := range res[i] {
+				res[i][j] += m[i][k] * src[k][j]
+			}
+		}
+	}
+	return res
+}
+
+func transpose3(m [3][3]float64) [3][3]float64 {
+	var t [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			t[j][i] = m[i][j]
+		}
+	}
+	return t
+}
+
+func invert3(m [3][3]float64) [3][3]float64 {
+	var inv [3][3]float64
+	for i := range inv {
+		for j := range inv[i] {
+
+			// The cofactor of m[j][i], signed by the cyclic order of the
+			// rows and columns.
+
+			r1, r2 := (j+1)%3, (j+2)%3
+			c1, c2 := (i+1)%3, (i+2)%3
+			inv[i][j] = m[r1][c1]*m[r2][c2] - m[r1][c2]*m[r2][c1]
+		}
+	}
+	det := m[0][0]*inv[0][0] + m[0][1]*inv[1][0] + m[0][2]*inv[2][0]
+	for i := range inv {
+		for j := range inv[i] {
+			inv[i][j] /= det
+		}
+	}
+	return inv
+}
+
+// convertToSRGB converts the image from the profile to sRGB in place,
+// clipping the colors out of the gamut of sRGB.
+func convertToSRGB(img *image.RGBA, p *rgbProfile) {
+	m := p.toSRGB()
+	var lut [3][256]float64
+	for k := range lut {
+		for i := range lut[k] {
+			lut[k][i] = p.trc[k](float64(i) / 255)
+		}
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+
+			// Unpremultiply, since the transfer function doesn't apply
+			// to the alpha.
+			a := float64(c.A) / 255
+			in := [3]float64{lut[0][c.R], lut[1][c.G], lut[2][c.B]}
+			if c.A < 255 {
+				in = [3]float64{p.trc[0](float64(c.R) / 255 / a), p.trc[1](float64(c.G) / 255 / a), p.trc[2](float64(c.B) / 255 / a)}
+			}
+			var rgb [3]uint8
+			for k, row := range m {
+				v := row[0]*in[0] + row[1]*in[1] + row[2]*in[2]
+				rgb[k] = uint8(math.Round(255 * a * fromLinear(math.Min(math.Max(v, 0), 1))))
+			}
+			img.SetRGBA(x, y, color.RGBA{rgb[0], rgb[1], rgb[2], c.A})
+		}
+	}
+}
+
+// sourceProfile returns the profile the result is converted from with
+// SRGBOutput, or nil if it is sRGB already. Untagged images are taken as
+// sRGB, like the profile of a previous stage with SRGBOutput.
+func (w *Waifu2x) sourceProfile() (*rgbProfile, error) {
+	if !w.SRGBOutput || len(w.profile) == 0 || bytes.Equal(w.profile, srgbProfile) {
+		return nil, nil
+	}
+	p, err := parseRGBProfile(w.profile)
+	if err != nil && w.colorSpace == displayP3 {
+		return &displayP3RGB, nil
+	}
+	return p, err
+}
+
+// outputProfile returns the ICC profile of the result, the sRGB one with
+// SRGBOutput.
+func (w *Waifu2x) outputProfile() []byte {
+	if w.SRGBOutput {
+		return srgbProfile
+	}
+	return w.profile
+}
+
+// srgbProfile is the ICC profile embedded with SRGBOutput.
+var srgbProfile = newSRGBProfile()
+
+// newSRGBProfile builds a v2 display profile of sRGB IEC61966-2.1, with the
+// transfer function as a table of 1024 entries.
+func newSRGBProfile() []byte {
+	var curv bytes.Buffer
+	curv.WriteString("curv\x00\x00\x00\x00")
+	binary.Write(&curv, binary.BigEndian, uint32(1024))
+	for i := 0; i < 1024; i++ {
+		binary.Write(&curv, binary.BigEndian, uint16(math.Round(65535*toLinear(float64(i)/1023))))
+	}
+	return newRGBProfile("sRGB IEC61966-2.1", srgbPrimaries, curv.Bytes())
+}
+
+// newRGBProfile builds a v2 display profile of the primaries adapted to D50,
+// as ICC requires, and the curv tag shared by the channels.
+func newRGBProfile(name string, primaries [3][3]float64, curv []byte) []byte {
+	s15 := func(b *bytes.Buffer, v float64) {
+		binary.Write(b, binary.BigEndian, int32(math.Round(v*65536)))
+	}
+	xyz := func(x, y, z float64) []byte {
+		var b bytes.Buffer
+		b.WriteString("XYZ \x00\x00\x00\x00")
+		s15(&b, x)
+		s15(&b, y)
+		s15(&b, z)
+		return b.Bytes()
+	}
+	var desc bytes.Buffer
+	desc.WriteString("desc\x00\x00\x00\x00")
+	binary.Write(&desc, binary.BigEndian, uint32(len(name)+1))
+	desc.WriteString(name + "\x00")
+	desc.Write(make([]byte, 4+4+2+1+67)) // no Unicode or ScriptCode description
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc.Bytes()},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(0.9642, 1, 0.8249)},
+		{"rXYZ", xyz(primaries[0][0], primaries[0][1], primaries[0][2])},
+		{"gXYZ", xyz(primaries[1][0], primaries[1][1], primaries[1][2])},
+		{"bXYZ", xyz(primaries[2][0], primaries[2][1], primaries[2][2])},
+		{"rTRC", curv},
+		{"gTRC", nil},
+		{"bTRC", nil},
+	}
+
+	// The tag data follows the header and the tag table, each element
+	// aligned to 4 bytes. The TRC tags without data share the last one.
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	start := 128 + 4 + 12*len(tags)
+	var offset, size int
+	for _, t := range tags {
+		if t.data != nil {
+			offset, size = start+data.Len(), len(t.data)
+			data.Write(t.data)
+			data.Write(make([]byte, -data.Len()&3))
+		}
+		table.WriteString(t.sig)
+		binary.Write(&table, binary.BigEndian, uint32(offset))
+		binary.Write(&table, binary.BigEndian, uint32(size))
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, uint32(start+data.Len()))
+	header.WriteString("\x00\x00\x00\x00")   // CMM
+	header.WriteString("\x02\x10\x00\x00")   // version 2.1
+	header.WriteString("mntrRGB XYZ ")       // class, color space and PCS
+	header.Write(make([]byte, 12))           // date
+	header.WriteString("acsp")               // signature
+	header.Write(make([]byte, 4+4+4+4+8+4))  // platform to rendering intent
+	header.Write(xyz(0.9642, 1, 0.8249)[8:]) // D50 illuminant
+	header.Write(make([]byte, 4+16+28))      // creator, ID and reserved
+	return append(append(header.Bytes(), table.Bytes()...), data.Bytes()...)
+}
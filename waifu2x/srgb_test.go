@@ -0,0 +1,107 @@
+package waifu2x
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSRGBOutput(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: p3Image(), ColorManaged: true, SRGBOutput: true}
+	w.SetProfile(p3Profile)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	src := p3Image()
+
+	// Gray is the same in both spaces, and the purple gets redder in the
+	// smaller gamut of sRGB.
+	if got := w.dst.RGBAAt(4, 2); got != src.RGBAAt(2, 1) {
+		t.Errorf("gray: got %v, want %v", got, src.RGBAAt(2, 1))
+	}
+	got, in := w.dst.RGBAAt(0, 2), src.RGBAAt(0, 1)
+	if int(got.R) < int(in.R)+10 || got.G >= in.G {
+		t.Errorf("got %v for the P3 %v, want more red and less green", got, in)
+	}
+
+	out := filepath.Join(t.TempDir(), "dst.png")
+	if err := w.SaveImage(out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := iccProfile(b)
+	if !bytes.Equal(profile, srgbProfile) || profileColorSpace(profile) != sRGB {
+		t.Error("the output isn't tagged as sRGB")
+	}
+	if size := int(profile[0])<<24 | int(profile[1])<<16 | int(profile[2])<<8 | int(profile[3]); size != len(profile) {
+		t.Errorf("the profile header gives %d bytes of %d", size, len(profile))
+	}
+}
+
+func TestSRGBOutputAdobeRGB(t *testing.T) {
+
+	// Adobe RGB (1998) has a gamma of 563/256 and a wider green than sRGB.
+	adobe := newRGBProfile("Adobe RGB (1998)", [3][3]float64{
+		{0.6097559, 0.3111242, 0.0194811},
+		{0.2052401, 0.6256560, 0.0608902},
+		{0.1492240, 0.0632197, 0.7448387},
+	}, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33\x00\x00"))
+	src := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	src.SetRGBA(0, 0, color.RGBA{200, 100, 50, 255})
+	src.SetRGBA(1, 0, color.RGBA{128, 128, 128, 255})
+	src.SetRGBA(2, 0, color.RGBA{30, 200, 60, 255})
+	w := &Waifu2x{models: []Model{identityModel()}, src: src, SRGBOutput: true}
+	w.SetProfile(adobe)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []color.RGBA{{227, 100, 42, 255}, {129, 129, 129, 255}, {0, 201, 37, 255}} {
+		got := w.dst.RGBAAt(2*x, 0)
+		for _, d := range []int{int(got.R) - int(want.R), int(got.G) - int(want.G), int(got.B) - int(want.B)} {
+			if d < -2 || d > 2 {
+				t.Errorf("got %v for the Adobe RGB %v, want about %v", got, src.RGBAAt(x, 0), want)
+				break
+			}
+		}
+	}
+}
+
+func TestSRGBOutputUnsupported(t *testing.T) {
+	cmyk := append([]byte{}, srgbProfile...)
+	copy(cmyk[16:], "CMYK")
+	w := &Waifu2x{models: []Model{identityModel()}, src: p3Image(), SRGBOutput: true}
+	w.SetProfile(cmyk)
+	if err := w.Exec(); !errors.Is(err, ErrUnsupportedProfile) {
+		t.Errorf("got %v, want ErrUnsupportedProfile", err)
+	}
+
+	// Without SRGBOutput, the profile is only kept.
+	w.SRGBOutput = false
+	if err := w.Exec(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDisplayP3ToSRGB(t *testing.T) {
+	want := [3][3]float64{
+		{1.2249401, -0.2249404, 0},
+		{-0.0420569, 1.0420571, 0},
+		{-0.0196376, -0.0786361, 1.0982735},
+	}
+	got := displayP3RGB.toSRGB()
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(got[i][j]-want[i][j]) > 1e-3 {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+}
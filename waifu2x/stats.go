@@ -0,0 +1,62 @@
+package waifu2x
+
+import (
+	"image"
+	"time"
+)
+
+// Stats describes the last Exec.
+type Stats struct {
+	// InputWidth and InputHeight are the size of the image given to Exec.
+	InputWidth, InputHeight int
+	// OutputWidth and OutputHeight are the size of the result.
+	OutputWidth, OutputHeight int
+	// Passes is the number of times the model was applied.
+	Passes int
+	// Elapsed is the time Exec took.
+	Elapsed time.Duration
+	// Clipped is the fraction of the values the model output that were
+	// clipped to [0, 1]. A large fraction hints that the model expects
+	// another normalization of the input.
+	Clipped float64
+	// Trimmed is the rectangle of the input inside the uniform borders
+	// that AutoTrim processed, or empty when the whole image was.
+	Trimmed image.Rectangle
+	// Approximate tells that SoftDeadline passed, so some tiles were only
+	// resized.
+	Approximate bool
+}
+
+func clipFraction(clipped, values int64) float64 {
+	if values == 0 {
+		return 0
+	}
+	return float64(clipped) / float64(values)
+}
+
+// Stats returns the stats of the last successful Exec.
+func (w *Waifu2x) Stats() Stats {
+	return w.stats
+}
+
+// Stats returns the stats of the last successful Exec of the chain, from
+// the input of the first stage to the result of the last one. Passes and
+// Elapsed are summed over the stages, and Clipped is of all the stages.
+func (c *ModelChain) Stats() Stats {
+	var s Stats
+	var clipped, values int64
+	for _, w := range c.Stages {
+		s.Passes += w.stats.Passes
+		s.Elapsed += w.stats.Elapsed
+		s.Approximate = s.Approximate || w.stats.Approximate
+		clipped += w.clipped
+		values += w.values
+	}
+	s.Clipped = clipFraction(clipped, values)
+	if len(c.Stages) > 0 {
+		first, last := c.Stages[0].stats, c.Stages[len(c.Stages)-1].stats
+		s.InputWidth, s.InputHeight = first.InputWidth, first.InputHeight
+		s.OutputWidth, s.OutputHeight = last.OutputWidth, last.OutputHeight
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	denoise := &Waifu2x{models: []Model{identityModel()}, Denoise: true}
+	scale := &Waifu2x{models: []Model{identityModel()}, Passes: 2}
+	chain := NewModelChain(denoise, scale)
+	chain.SetImage(testImage(5, 3))
+	if err := chain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := denoise.Stats(); s.InputWidth != 5 || s.InputHeight != 3 || s.OutputWidth != 5 || s.OutputHeight != 3 || s.Passes != 1 {
+		t.Errorf("got denoise stats %+v", s)
+	}
+	if s := scale.Stats(); s.InputWidth != 5 || s.InputHeight != 3 || s.OutputWidth != 20 || s.OutputHeight != 12 || s.Passes != 2 {
+		t.Errorf("got scale stats %+v", s)
+	}
+	s := chain.Stats()
+	if s.InputWidth != 5 || s.InputHeight != 3 || s.OutputWidth != 20 || s.OutputHeight != 12 || s.Passes != 3 {
+		t.Errorf("got chain stats %+v", s)
+	}
+	if s.Elapsed <= 0 || s.Elapsed != denoise.Stats().Elapsed+scale.Stats().Elapsed {
+		t.Errorf("got elapsed %v, want the sum of %v and %v", s.Elapsed, denoise.Stats().Elapsed, scale.Stats().Elapsed)
+	}
+}
+
+func TestStatsClipped(t *testing.T) {
+
+	// The bias takes the white half of the image above 1.
+
+	src := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 4; x < 8; x++ {
+			src.SetGray(x, y, color.Gray{255})
+		}
+	}
+	bright := identityModel()
+	bright.Bias[0] = 0.5
+
+	for _, c := range []struct {
+		model   Model
+		clipped float64
+	}{
+		{identityModel(), 0},
+		{bright, 0.5},
+	} {
+		w := &Waifu2x{models: []Model{c.model}, src: src}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Stats().Clipped; got != c.clipped {
+			t.Errorf("bias %v: got clipped %v, want %v", c.model.Bias[0], got, c.clipped)
+		}
+	}
+
+	// The chain counts the values of both stages.
+	denoise := &Waifu2x{models: []Model{identityModel()}, Denoise: true}
+	scale := &Waifu2x{models: []Model{bright}}
+	chain := NewModelChain(denoise, scale)
+	chain.SetImage(src)
+	if err := chain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := chain.Stats().Clipped, 0.5*4/5; got != want {
+		t.Errorf("got clipped %v for the chain, want %v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package waifu2x
+
+import (
+	"context"
+	"image"
+	"image/color"
+)
+
+// StreamRows processes the image in bands of rows and calls fn with each
+// row of the result in order, so that the result can be encoded as it is
+// computed without holding it in memory. The rows are the same as those of
+// Exec, except that AutoLevels and Dither are computed for each band. row is
+// only valid during the call. It only supports a single pass without a
+// target size, and Result is not set.
+func (w *Waifu2x) StreamRows(fn func(y int, row []color.RGBA)) error {
+	return w.StreamRowsContext(context.Background(), fn)
+}
+
+// StreamRowsContext streams the rows like StreamRows, and stops with the
+// error of ctx when ctx is done.
+func (w *Waifu2x) StreamRowsContext(ctx context.Context, fn func(y int, row []color.RGBA)) error {
+	scale, err := w.singlePass("StreamRows")
+	if err != nil {
+		return err
+	}
+	width, height := w.src.Bounds().Dx(), w.src.Bounds().Dy()
+	row := make([]color.RGBA, width*scale)
+	for y0 := 0; y0 < height; y0 += lowMemoryRows {
+		band := image.Rect(0, y0, width, y0+lowMemoryRows).Intersect(image.Rect(0, 0, width, height))
+		out, crop, err := w.execAround(ctx, band, scale)
+		if err != nil {
+			return err
+		}
+		for y := band.Min.Y * scale; y < band.Max.Y*scale; y++ {
+			pix := out.Pix[(y-crop.Min.Y*scale)*out.Stride:]
+			for x := range row {
+				row[x] = color.RGBA{pix[4*x], pix[4*x+1], pix[4*x+2], pix[4*x+3]}
+			}
+			fn(y, row)
+		}
+	}
+	return nil
+}
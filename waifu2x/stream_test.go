@@ -0,0 +1,53 @@
+package waifu2x
+
+import (
+	"errors"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestStreamRows(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 1)
+	for _, denoise := range []bool{false, true} {
+		src := testImage(21, lowMemoryRows*2+5)
+		full := &Waifu2x{models: models, src: src, Denoise: denoise}
+		if err := full.Exec(); err != nil {
+			t.Fatal(err)
+		}
+
+		w := &Waifu2x{models: models, src: src, Denoise: denoise}
+		next := 0
+		err := w.StreamRows(func(y int, row []color.RGBA) {
+			if y != next {
+				t.Fatalf("denoise %v: got row %d, want %d", denoise, y, next)
+			}
+			next++
+			for x, c := range row {
+				if want := full.Result().RGBAAt(x, y); c != want {
+					t.Fatalf("denoise %v: got %v at (%d,%d), want %v", denoise, c, x, y, want)
+				}
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := full.Result().Bounds().Dy(); next != h {
+			t.Errorf("denoise %v: got %d rows, want %d", denoise, next, h)
+		}
+		if w.Result() != nil {
+			t.Errorf("denoise %v: the result is set", denoise)
+		}
+	}
+}
+
+func TestStreamRowsErrors(t *testing.T) {
+	var w Waifu2x
+	if err := w.StreamRows(func(int, []color.RGBA) {}); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("got %v, want %v", err, ErrEmptyImage)
+	}
+	w = Waifu2x{models: []Model{identityModel()}, src: testImage(4, 4), Passes: 2}
+	if err := w.StreamRows(func(int, []color.RGBA) {}); err == nil {
+		t.Error("got no error for two passes")
+	}
+}
@@ -0,0 +1,47 @@
+package waifu2x
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+// SaveStrips saves the result in n strips of about the same size instead of
+// a single image, split across the longer side, so vertical strips of a wide
+// image. The strips are saved in the format of name with their number before
+// the extension, e.g. dst_1.png, and their names are returned in order.
+func (w *Waifu2x) SaveStrips(name string, n int) ([]string, error) {
+	if w.dst == nil {
+		return nil, ErrEmptyImage
+	}
+	bounds := w.dst.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() > side {
+		side = bounds.Dy()
+	}
+	if n < 1 || n > side {
+		return nil, fmt.Errorf("%w: %d strips of %v", ErrInvalidBounds, n, bounds.Size())
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	var names []string
+	for i := 0; i < n; i++ {
+
+		// Split the result with SubImage, so the strips share its pixels.
+		r := bounds
+		if bounds.Dx() >= bounds.Dy() {
+			r.Min.X, r.Max.X = bounds.Min.X+i*side/n, bounds.Min.X+(i+1)*side/n
+		} else {
+			r.Min.Y, r.Max.Y = bounds.Min.Y+i*side/n, bounds.Min.Y+(i+1)*side/n
+		}
+		strip := w.dst.SubImage(r).(*image.RGBA)
+		stripName := fmt.Sprintf("%s_%d%s", base, i+1, ext)
+		if err := w.save(stripName, strip, nil); err != nil {
+			return names, err
+		}
+		names = append(names, stripName)
+	}
+	return names, nil
+}
@@ -0,0 +1,63 @@
+package waifu2x
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveStrips(t *testing.T) {
+	for _, size := range []image.Point{{11, 4}, {3, 7}} {
+		w := &Waifu2x{models: []Model{boxModel()}, src: testImage(size.X, size.Y)}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		names, err := w.SaveStrips(filepath.Join(t.TempDir(), "dst.png"), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 3 {
+			t.Fatalf("%v: got %d strips, want 3", size, len(names))
+		}
+
+		// The strips put side by side make the result.
+		full := image.NewRGBA(w.dst.Bounds())
+		offset := image.Point{}
+		for _, name := range names {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			strip, _, err := image.Decode(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := strip.Bounds().Sub(strip.Bounds().Min).Add(offset)
+			draw.Draw(full, r, strip, strip.Bounds().Min, draw.Src)
+			if size.X > size.Y {
+				offset.X += r.Dx()
+			} else {
+				offset.Y += r.Dy()
+			}
+		}
+		if offset != image.Pt(w.dst.Bounds().Dx(), 0) && offset != image.Pt(0, w.dst.Bounds().Dy()) {
+			t.Errorf("%v: strips end at %v", size, offset)
+		}
+		if !bytes.Equal(full.Pix, w.dst.Pix) {
+			t.Errorf("%v: the strips don't make the result", size)
+		}
+	}
+
+	w := &Waifu2x{models: []Model{boxModel()}, src: testImage(2, 1)}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.SaveStrips(filepath.Join(t.TempDir(), "dst.png"), 5); !errors.Is(err, ErrInvalidBounds) {
+		t.Errorf("got %v for 5 strips of 4 pixels, want %v", err, ErrInvalidBounds)
+	}
+}
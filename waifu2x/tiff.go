@@ -0,0 +1,508 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// TIFF tags.
+const (
+	tiffNewSubfileType  = 254
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffPhotometric     = 262
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffXResolution     = 282
+	tiffYResolution     = 283
+	tiffPlanarConfig    = 284
+	tiffResolutionUnit  = 296
+	tiffPageNumber      = 297
+	tiffExtraSamples    = 338
+)
+
+// tiffTextTags are the ASCII tags kept in TIFFPage.Text: DocumentName,
+// ImageDescription, Make, Model, PageName, Software, DateTime, Artist and
+// Copyright.
+var tiffTextTags = []uint16{269, 270, 271, 272, 285, 305, 306, 315, 33432}
+
+// TIFF field types.
+const (
+	tiffByte     = 1
+	tiffASCII    = 2
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+func init() {
+	decode := func(r io.Reader) (image.Image, error) {
+		pages, err := DecodeTIFF(r)
+		if err != nil {
+			return nil, err
+		}
+		return pages[0].Image, nil
+	}
+	image.RegisterFormat("tiff", "II*\x00", decode, decodeTIFFConfig)
+	image.RegisterFormat("tiff", "MM\x00*", decode, decodeTIFFConfig)
+}
+
+// decodeTIFFConfig reads the size and the color model of the first page from
+// its directory, reading the file only up to the end of the directory.
+func decodeTIFFConfig(r io.Reader) (image.Config, error) {
+	var b []byte
+	need := func(n uint64) error {
+		if n > uint64(len(b)) {
+			more := make([]byte, n-uint64(len(b)))
+			if _, err := io.ReadFull(r, more); err != nil {
+				return fmt.Errorf("%w: truncated TIFF file", ErrUnsupportedFormat)
+			}
+			b = append(b, more...)
+		}
+		return nil
+	}
+	if err := need(8); err != nil {
+		return image.Config{}, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(b[:4]) == "MM\x00*" {
+		order = binary.BigEndian
+	}
+	off := uint64(order.Uint32(b[4:]))
+	if err := need(off + 2); err != nil {
+		return image.Config{}, err
+	}
+	n := uint64(order.Uint16(b[off:]))
+	if err := need(off + 2 + 12*n); err != nil {
+		return image.Config{}, err
+	}
+
+	// The tags read are single values, which are stored in the entries.
+	value := func(tag uint16, def uint32) uint32 {
+		for i := uint64(0); i < n; i++ {
+			e := b[off+2+12*i:]
+			if order.Uint16(e) != tag || order.Uint32(e[4:]) == 0 {
+				continue
+			}
+			switch order.Uint16(e[2:]) {
+			case tiffShort:
+				return uint32(order.Uint16(e[8:]))
+			case tiffLong:
+				return order.Uint32(e[8:])
+			}
+		}
+		return def
+	}
+	width, height := value(tiffImageWidth, 0), value(tiffImageLength, 0)
+	if width == 0 || height == 0 || uint64(width) > math.MaxInt || uint64(height) > math.MaxInt {
+		return image.Config{}, fmt.Errorf("%w: %dx%d TIFF page", ErrEmptyImage, width, height)
+	}
+	var model color.Model
+	switch spp := value(tiffSamplesPerPixel, 1); {
+	case spp == 1:
+		model = color.GrayModel
+	case spp == 3 || value(tiffExtraSamples, 0) == 1:
+		model = color.RGBAModel
+	default:
+		model = color.NRGBAModel
+	}
+	return image.Config{ColorModel: model, Width: int(width), Height: int(height)}, nil
+}
+
+// TIFFPage is a page of a TIFF file with the metadata kept with it.
+type TIFFPage struct {
+	Image image.Image
+
+	// Text holds the ASCII tags of the page by tag number, e.g. 270 for
+	// ImageDescription.
+	Text map[uint16]string
+
+	// XResolution and YResolution are the pixels per ResolutionUnit as
+	// numerator and denominator, zero when unknown.
+	XResolution, YResolution [2]uint32
+	ResolutionUnit           uint16
+}
+
+// WithImage returns the page with the image replaced, e.g. by its upscaled
+// result. The resolution is scaled by the size of img, so that the page keeps
+// its physical size.
+func (p TIFFPage) WithImage(img image.Image) TIFFPage {
+	old, size := p.Image.Bounds().Size(), img.Bounds().Size()
+	p.XResolution = scaleRational(p.XResolution, size.X, old.X)
+	p.YResolution = scaleRational(p.YResolution, size.Y, old.Y)
+	p.Image = img
+	return p
+}
+
+func scaleRational(r [2]uint32, num, den int) [2]uint32 {
+	if r[1] == 0 || den == 0 {
+		return r
+	}
+	n, d := uint64(r[0])*uint64(num), uint64(r[1])*uint64(den)
+	for a, b := n, d; ; {
+		if b == 0 {
+			n, d = n/a, d/a
+			break
+		}
+		a, b = b, a%b
+	}
+	for n > 1<<32-1 || d > 1<<32-1 {
+		n, d = n/2, d/2
+	}
+	return [2]uint32{uint32(n), uint32(d)}
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func (e tiffEntry) uints(order binary.ByteOrder) []uint32 {
+	var v []uint32
+	for i := uint32(0); i < e.count; i++ {
+		switch e.typ {
+		case tiffByte:
+			v = append(v, uint32(e.data[i]))
+		case tiffShort:
+			v = append(v, uint32(order.Uint16(e.data[2*i:])))
+		case tiffLong:
+			v = append(v, order.Uint32(e.data[4*i:]))
+		}
+	}
+	return v
+}
+
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case tiffByte, tiffASCII, 7:
+		return 1
+	case tiffShort:
+		return 2
+	case tiffLong:
+		return 4
+	case tiffRational:
+		return 8
+	}
+	return 0
+}
+
+// DecodeTIFF decodes all the pages of a baseline TIFF file of 8-bit gray,
+// RGB or RGBA pixels in uncompressed strips.
+func DecodeTIFF(r io.Reader) ([]TIFFPage, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w: truncated TIFF header", ErrUnsupportedFormat)
+	}
+	var order binary.ByteOrder
+	switch string(b[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: not a TIFF file", ErrUnsupportedFormat)
+	}
+
+	var pages []TIFFPage
+	seen := map[uint32]bool{}
+	for off := order.Uint32(b[4:]); off != 0; {
+		if seen[off] {
+			return nil, fmt.Errorf("%w: TIFF directories loop", ErrUnsupportedFormat)
+		}
+		seen[off] = true
+		ifd, next, err := readTIFFDirectory(b, order, off)
+		if err != nil {
+			return nil, err
+		}
+		page, err := decodeTIFFPage(b, order, ifd)
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", len(pages)+1, err)
+		}
+		pages = append(pages, page)
+		off = next
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: TIFF file without pages", ErrUnsupportedFormat)
+	}
+	return pages, nil
+}
+
+func readTIFFDirectory(b []byte, order binary.ByteOrder, off uint32) (map[uint16]tiffEntry, uint32, error) {
+	if uint64(off)+2 > uint64(len(b)) {
+		return nil, 0, fmt.Errorf("%w: TIFF directory out of the file", ErrUnsupportedFormat)
+	}
+	n := int(order.Uint16(b[off:]))
+	start := int(off) + 2
+	if start+12*n+4 > len(b) {
+		return nil, 0, fmt.Errorf("%w: truncated TIFF directory", ErrUnsupportedFormat)
+	}
+	ifd := map[uint16]tiffEntry{}
+	for i := 0; i < n; i++ {
+		e := b[start+12*i:]
+		entry := tiffEntry{typ: order.Uint16(e[2:]), count: order.Uint32(e[4:])}
+		size := uint64(tiffTypeSize(entry.typ)) * uint64(entry.count)
+		if size <= 4 {
+			entry.data = e[8 : 8+size]
+		} else {
+			p := uint64(order.Uint32(e[8:]))
+			if p+size > uint64(len(b)) {
+				return nil, 0, fmt.Errorf("%w: TIFF tag %d out of the file", ErrUnsupportedFormat, order.Uint16(e))
+			}
+			entry.data = b[p : p+size]
+		}
+		ifd[order.Uint16(e)] = entry
+	}
+	return ifd, order.Uint32(b[start+12*n:]), nil
+}
+
+func decodeTIFFPage(b []byte, order binary.ByteOrder, ifd map[uint16]tiffEntry) (TIFFPage, error) {
+	value := func(tag uint16, def uint32) uint32 {
+		if v := ifd[tag].uints(order); len(v) > 0 {
+			return v[0]
+		}
+		return def
+	}
+	width, height := int(value(tiffImageWidth, 0)), int(value(tiffImageLength, 0))
+	spp := int(value(tiffSamplesPerPixel, 1))
+	photometric := value(tiffPhotometric, 1)
+	if width <= 0 || height <= 0 {
+		return TIFFPage{}, fmt.Errorf("%w: %dx%d TIFF page", ErrEmptyImage, width, height)
+	}
+	if c := value(tiffCompression, 1); c != 1 {
+		return TIFFPage{}, fmt.Errorf("%w: TIFF compression %d", ErrUnsupportedFormat, c)
+	}
+	if value(tiffPlanarConfig, 1) != 1 {
+		return TIFFPage{}, fmt.Errorf("%w: planar TIFF", ErrUnsupportedFormat)
+	}
+	bps := ifd[tiffBitsPerSample].uints(order)
+	if len(bps) == 0 {
+		bps = []uint32{1}
+	}
+	for _, v := range bps {
+		if v != 8 {
+			return TIFFPage{}, fmt.Errorf("%w: %d bits per sample TIFF", ErrUnsupportedFormat, v)
+		}
+	}
+	switch {
+	case (photometric == 0 || photometric == 1) && (spp == 1 || spp == 2):
+	case photometric == 2 && (spp == 3 || spp == 4):
+	default:
+		return TIFFPage{}, fmt.Errorf("%w: TIFF of photometric %d with %d samples", ErrUnsupportedFormat, photometric, spp)
+	}
+
+	// Concatenate the strips.
+	offsets, counts := ifd[tiffStripOffsets].uints(order), ifd[tiffStripByteCounts].uints(order)
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return TIFFPage{}, fmt.Errorf("%w: TIFF strips", ErrUnsupportedFormat)
+	}
+	// A crafted size overflows the product, so the high word is checked.
+	hi, pixels := bits.Mul64(uint64(width), uint64(height))
+	hi2, size := bits.Mul64(pixels, uint64(spp))
+	if hi != 0 || hi2 != 0 || size > uint64(len(b)) {
+		return TIFFPage{}, fmt.Errorf("%w: truncated TIFF pixels", ErrUnsupportedFormat)
+	}
+	pix := make([]byte, 0, size)
+	for i, off := range offsets {
+		end := uint64(off) + uint64(counts[i])
+		if end > uint64(len(b)) {
+			return TIFFPage{}, fmt.Errorf("%w: TIFF strip out of the file", ErrUnsupportedFormat)
+		}
+		pix = append(pix, b[off:end]...)
+	}
+	if uint64(len(pix)) < size {
+		return TIFFPage{}, fmt.Errorf("%w: truncated TIFF pixels", ErrUnsupportedFormat)
+	}
+
+	// Associated alpha is premultiplied, and unassociated or unspecified
+	// alpha is not.
+	premultiplied := value(tiffExtraSamples, 0) == 1
+	rect := image.Rect(0, 0, width, height)
+	var img image.Image
+	switch spp {
+	case 1:
+		g := image.NewGray(rect)
+		copy(g.Pix, pix)
+		if photometric == 0 {
+			for i := range g.Pix {
+				g.Pix[i] = 255 - g.Pix[i]
+			}
+		}
+		img = g
+	case 3:
+		m := image.NewRGBA(rect)
+		for i := 0; i < width*height; i++ {
+			copy(m.Pix[4*i:], pix[3*i:3*i+3])
+			m.Pix[4*i+3] = 255
+		}
+		img = m
+	default:
+		var m draw.Image
+		var p []byte
+		if premultiplied {
+			rgba := image.NewRGBA(rect)
+			m, p = rgba, rgba.Pix
+		} else {
+			nrgba := image.NewNRGBA(rect)
+			m, p = nrgba, nrgba.Pix
+		}
+		for i := 0; i < width*height; i++ {
+			if spp == 4 {
+				copy(p[4*i:], pix[4*i:4*i+4])
+				continue
+			}
+			v := pix[2*i]
+			if photometric == 0 {
+				v = 255 - v
+			}
+			p[4*i], p[4*i+1], p[4*i+2], p[4*i+3] = v, v, v, pix[2*i+1]
+		}
+		img = m
+	}
+
+	page := TIFFPage{Image: img, ResolutionUnit: uint16(value(tiffResolutionUnit, 0))}
+	for _, tag := range tiffTextTags {
+		if e, ok := ifd[tag]; ok && e.typ == tiffASCII {
+			if page.Text == nil {
+				page.Text = map[uint16]string{}
+			}
+			page.Text[tag] = string(bytes.TrimRight(e.data, "\x00"))
+		}
+	}
+	for tag, res := range map[uint16]*[2]uint32{tiffXResolution: &page.XResolution, tiffYResolution: &page.YResolution} {
+		if e, ok := ifd[tag]; ok && e.typ == tiffRational && e.count == 1 {
+			*res = [2]uint32{order.Uint32(e.data), order.Uint32(e.data[4:])}
+		}
+	}
+	return page, nil
+}
+
+// EncodeTIFF writes the pages as a little-endian TIFF file of uncompressed
+// 8-bit RGBA pixels with unassociated alpha, keeping the metadata of the
+// pages.
+func EncodeTIFF(w io.Writer, pages []TIFFPage) error {
+	if len(pages) == 0 {
+		return ErrEmptyImage
+	}
+	order := binary.LittleEndian
+	var b bytes.Buffer
+	b.WriteString("II*\x00")
+	b.Write([]byte{0, 0, 0, 0})
+	next := 4 // The offset of the offset of the next directory.
+
+	for i, page := range pages {
+		bounds := page.Image.Bounds()
+		if bounds.Empty() {
+			return ErrEmptyImage
+		}
+		nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), page.Image, bounds.Min, draw.Src)
+		pixOffset := b.Len()
+		b.Write(nrgba.Pix)
+		if uint64(b.Len()) > 1<<32-1 {
+			return fmt.Errorf("%w: TIFF file over 4 GiB", ErrImageTooLarge)
+		}
+
+		type field struct {
+			tag, typ uint16
+			count    uint32
+			data     []byte
+		}
+		var fields []field
+		shorts := func(tag uint16, v ...uint16) {
+			data := make([]byte, 2*len(v))
+			for i, s := range v {
+				order.PutUint16(data[2*i:], s)
+			}
+			fields = append(fields, field{tag, tiffShort, uint32(len(v)), data})
+		}
+		long := func(tag uint16, v uint32) {
+			data := make([]byte, 4)
+			order.PutUint32(data, v)
+			fields = append(fields, field{tag, tiffLong, 1, data})
+		}
+		if len(pages) > 1 {
+			long(tiffNewSubfileType, 2)
+		}
+		long(tiffImageWidth, uint32(bounds.Dx()))
+		long(tiffImageLength, uint32(bounds.Dy()))
+		shorts(tiffBitsPerSample, 8, 8, 8, 8)
+		shorts(tiffCompression, 1)
+		shorts(tiffPhotometric, 2)
+		long(tiffStripOffsets, uint32(pixOffset))
+		shorts(tiffSamplesPerPixel, 4)
+		long(tiffRowsPerStrip, uint32(bounds.Dy()))
+		long(tiffStripByteCounts, uint32(len(nrgba.Pix)))
+		for tag, res := range map[uint16][2]uint32{tiffXResolution: page.XResolution, tiffYResolution: page.YResolution} {
+			if res[1] != 0 {
+				data := make([]byte, 8)
+				order.PutUint32(data, res[0])
+				order.PutUint32(data[4:], res[1])
+				fields = append(fields, field{tag, tiffRational, 1, data})
+			}
+		}
+		shorts(tiffPlanarConfig, 1)
+		if page.ResolutionUnit != 0 {
+			shorts(tiffResolutionUnit, page.ResolutionUnit)
+		}
+		if len(pages) > 1 {
+			shorts(tiffPageNumber, uint16(i), uint16(len(pages)))
+		}
+		shorts(tiffExtraSamples, 2)
+		for tag, text := range page.Text {
+			fields = append(fields, field{tag, tiffASCII, uint32(len(text) + 1), append([]byte(text), 0)})
+		}
+		sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+
+		// The values that don't fit in an entry are written before the
+		// directory, which starts at a word boundary.
+		offsets := make([]uint32, len(fields))
+		for i, f := range fields {
+			if len(f.data) > 4 {
+				if b.Len()%2 == 1 {
+					b.WriteByte(0)
+				}
+				offsets[i] = uint32(b.Len())
+				b.Write(f.data)
+			}
+		}
+		if b.Len()%2 == 1 {
+			b.WriteByte(0)
+		}
+		order.PutUint32(b.Bytes()[next:], uint32(b.Len()))
+
+		entries := make([]byte, 2+12*len(fields)+4)
+		order.PutUint16(entries, uint16(len(fields)))
+		for i, f := range fields {
+			e := entries[2+12*i:]
+			order.PutUint16(e, f.tag)
+			order.PutUint16(e[2:], f.typ)
+			order.PutUint32(e[4:], f.count)
+			if len(f.data) > 4 {
+				order.PutUint32(e[8:], offsets[i])
+			} else {
+				copy(e[8:12], f.data)
+			}
+		}
+		next = b.Len() + 2 + 12*len(fields)
+		b.Write(entries)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,170 @@
+package waifu2x
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestTIFFRoundTrip(t *testing.T) {
+	pages := []TIFFPage{
+		{
+			Image:          testImage(5, 4),
+			Text:           map[uint16]string{270: "first", 285: "page 1"},
+			XResolution:    [2]uint32{300, 1},
+			YResolution:    [2]uint32{150, 1},
+			ResolutionUnit: 2,
+		},
+		{Image: testImage(3, 7), Text: map[uint16]string{270: "second"}},
+	}
+	var buf bytes.Buffer
+	if err := EncodeTIFF(&buf, pages); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeTIFF(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(pages) {
+		t.Fatalf("got %d pages, want %d", len(got), len(pages))
+	}
+	for i, p := range pages {
+		if !sameImage(got[i].Image, p.Image) {
+			t.Errorf("page %d: the pixels differ", i)
+		}
+		if !reflect.DeepEqual(got[i].Text, p.Text) {
+			t.Errorf("page %d: got text %v, want %v", i, got[i].Text, p.Text)
+		}
+		if got[i].XResolution != p.XResolution || got[i].YResolution != p.YResolution || got[i].ResolutionUnit != p.ResolutionUnit {
+			t.Errorf("page %d: got resolution %v x %v (%d), want %v x %v (%d)", i,
+				got[i].XResolution, got[i].YResolution, got[i].ResolutionUnit,
+				p.XResolution, p.YResolution, p.ResolutionUnit)
+		}
+	}
+
+	// image.Decode reads the first page.
+	img, format, err := image.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "tiff" || !sameImage(img, pages[0].Image) {
+		t.Errorf("got format %q, want the first page of a tiff", format)
+	}
+}
+
+// tiffFile encodes a TIFF file of a directory of single value entries of
+// tag, type and value, followed by the pixels.
+func tiffFile(order binary.ByteOrder, entries [][3]uint32, pix []byte) []byte {
+	var b bytes.Buffer
+	if order == binary.ByteOrder(binary.BigEndian) {
+		b.WriteString("MM\x00*")
+	} else {
+		b.WriteString("II*\x00")
+	}
+	binary.Write(&b, order, uint32(8))
+	binary.Write(&b, order, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&b, order, uint16(e[0]))
+		binary.Write(&b, order, uint16(e[1]))
+		binary.Write(&b, order, uint32(1))
+		if e[1] == tiffShort {
+			binary.Write(&b, order, uint16(e[2]))
+			binary.Write(&b, order, uint16(0))
+		} else {
+			binary.Write(&b, order, e[2])
+		}
+	}
+	binary.Write(&b, order, uint32(0))
+	b.Write(pix)
+	return b.Bytes()
+}
+
+// overflowTIFF declares a 2^31x2^31 RGBA page, whose size in bytes wraps to
+// 0 in 64 bits.
+func overflowTIFF() []byte {
+	return tiffFile(binary.LittleEndian, [][3]uint32{
+		{tiffImageWidth, tiffLong, 1 << 31},
+		{tiffImageLength, tiffLong, 1 << 31},
+		{tiffBitsPerSample, tiffShort, 8},
+		{tiffCompression, tiffShort, 1},
+		{tiffPhotometric, tiffShort, 2},
+		{tiffStripOffsets, tiffLong, 8 + 2 + 12*8 + 4},
+		{tiffSamplesPerPixel, tiffShort, 4},
+		{tiffStripByteCounts, tiffLong, 4},
+	}, []byte{1, 2, 3, 4})
+}
+
+func TestDecodeTIFFOverflow(t *testing.T) {
+	b := overflowTIFF()
+	if _, err := DecodeTIFF(bytes.NewReader(b)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedFormat)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil || format != "tiff" || config.Width != 1<<31 || config.Height != 1<<31 {
+		t.Errorf("got config %+v of %q, %v, want 2^31x2^31 tiff", config, format, err)
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, MaxPixels: 1 << 12}
+	if _, err := w.ProcessBytes(b); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("ProcessBytes: got %v, want %v", err, ErrImageTooLarge)
+	}
+}
+
+func TestDecodeTIFFBigEndian(t *testing.T) {
+	// A 2x1 8-bit grayscale image in Motorola byte order.
+	b := bytes.NewReader(tiffFile(binary.BigEndian, [][3]uint32{
+		{tiffImageWidth, tiffShort, 2},
+		{tiffImageLength, tiffShort, 1},
+		{tiffBitsPerSample, tiffShort, 8},
+		{tiffCompression, tiffShort, 1},
+		{tiffPhotometric, tiffShort, 1},
+		{tiffStripOffsets, tiffLong, 8 + 2 + 12*8 + 4},
+		{tiffSamplesPerPixel, tiffShort, 1},
+		{tiffStripByteCounts, tiffLong, 2},
+	}, []byte{10, 200}))
+
+	pages, err := DecodeTIFF(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	img := pages[0].Image
+	if img.Bounds() != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("got bounds %v, want 2x1", img.Bounds())
+	}
+	for x, want := range []uint8{10, 200} {
+		if got := color.GrayModel.Convert(img.At(x, 0)).(color.Gray).Y; got != want {
+			t.Errorf("pixel %d: got %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestTIFFPageWithImage(t *testing.T) {
+	p := TIFFPage{Image: testImage(4, 3), XResolution: [2]uint32{300, 1}, YResolution: [2]uint32{72, 1}, ResolutionUnit: 2}
+	p = p.WithImage(testImage(8, 6))
+	if p.XResolution != [2]uint32{600, 1} || p.YResolution != [2]uint32{144, 1} {
+		t.Errorf("got resolution %v x %v, want [600 1] x [144 1]", p.XResolution, p.YResolution)
+	}
+}
+
+func sameImage(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	da, db := a.Bounds().Min, b.Bounds().Min
+	for y := 0; y < a.Bounds().Dy(); y++ {
+		for x := 0; x < a.Bounds().Dx(); x++ {
+			ca := color.NRGBAModel.Convert(a.At(da.X+x, da.Y+y))
+			cb := color.NRGBAModel.Convert(b.At(db.X+x, db.Y+y))
+			if ca != cb {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package waifu2x
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// trimTolerance is the largest difference of a channel from the corner color,
+// in 8 bits, of the pixels of a uniform border, for the noise of scans.
+const trimTolerance = 4
+
+// trimBounds returns the rectangle of the image, relative to its bounds,
+// inside the rows and the columns of the color of the top left corner.
+func trimBounds(img image.Image) (image.Rectangle, color.RGBA) {
+	bounds := img.Bounds()
+	c := color.RGBAModel.Convert(img.At(bounds.Min.X, bounds.Min.Y)).(color.RGBA)
+	near := func(a, b uint8) bool {
+		d := int(a) - int(b)
+		return d >= -trimTolerance && d <= trimTolerance
+	}
+	uniform := func(r image.Rectangle) bool {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				p := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				if !near(p.R, c.R) || !near(p.G, c.G) || !near(p.B, c.B) || !near(p.A, c.A) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	r := bounds
+	for r.Min.Y < r.Max.Y && uniform(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1)) {
+		r.Min.Y++
+	}
+	for r.Max.Y > r.Min.Y && uniform(image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y)) {
+		r.Max.Y--
+	}
+	for r.Min.X < r.Max.X && uniform(image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y)) {
+		r.Min.X++
+	}
+	for r.Max.X > r.Min.X && uniform(image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y)) {
+		r.Max.X--
+	}
+	return r.Sub(bounds.Min), c
+}
+
+// execTrimmed executes like exec, processing only the image inside its
+// uniform borders and the margin of the model around it with AutoTrim. The
+// rest of the output is filled with the color of the borders.
+func (w *Waifu2x) execTrimmed(ctx context.Context, into *image.RGBA) (*image.RGBA, error) {
+	w.trimmed = image.Rectangle{}
+	if !w.AutoTrim || w.src == nil {
+		return w.exec(ctx, into)
+	}
+	if _, float := w.src.(*FloatImage); float && w.HDR {
+		return w.exec(ctx, into)
+	}
+	bounds := w.src.Bounds()
+	if w.previewing(bounds.Dx(), bounds.Dy()) {
+		return w.exec(ctx, into)
+	}
+	scale, err := w.singlePass("AutoTrim")
+	if err != nil {
+		return w.exec(ctx, into)
+	}
+	content, c := trimBounds(w.src)
+	if content.Empty() || content == image.Rect(0, 0, bounds.Dx(), bounds.Dy()) {
+		return w.exec(ctx, into)
+	}
+
+	out, crop, err := w.execAround(ctx, content, scale)
+	if err != nil {
+		return nil, err
+	}
+	w.passes = 1
+	w.trimmed = content
+
+	dst := outputImage(into, bounds.Dx()*scale, bounds.Dy()*scale)
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rectangle{crop.Min.Mul(scale), crop.Max.Mul(scale)}, out, image.Point{}, draw.Src)
+	return dst, nil
+}
@@ -0,0 +1,50 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestExecAutoTrim(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(11)), 1, 4, 1)
+	src := image.NewRGBA(image.Rect(0, 0, 24, 20))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	content := image.Rect(6, 5, 18, 13)
+	draw.Draw(src, content, testImage(12, 8), image.Point{}, draw.Src)
+
+	full := &Waifu2x{models: models, src: src}
+	if err := full.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	w := &Waifu2x{models: models, src: src, AutoTrim: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Stats().Trimmed; got != content {
+		t.Errorf("processed %v, want %v", got, content)
+	}
+	if got := w.Result().Bounds(); got != image.Rect(0, 0, 48, 40) {
+		t.Fatalf("got bounds %v, want 48x40", got)
+	}
+	scaled := image.Rectangle{content.Min.Mul(2), content.Max.Mul(2)}
+	if !sameImage(w.dst.SubImage(scaled), full.dst.SubImage(scaled)) {
+		t.Error("the content differs from a full Exec")
+	}
+	for _, p := range []image.Point{{0, 0}, {47, 39}, {20, 2}, {2, 20}} {
+		if got := w.dst.RGBAAt(p.X, p.Y); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("border at %v: got %v, want white", p, got)
+		}
+	}
+
+	// Images without borders are processed whole.
+	w = &Waifu2x{models: models, src: testImage(12, 8), AutoTrim: true}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Stats().Trimmed; !got.Empty() {
+		t.Errorf("processed %v, want the whole image", got)
+	}
+}
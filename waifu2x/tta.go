@@ -0,0 +1,85 @@
+package waifu2x
+
+import (
+	"context"
+
+	"github.com/lon9/mat"
+)
+
+// dihedral returns the plane flipped horizontally when k&1 is set, then
+// vertically when k&2 is set, then transposed when k&4 is set, which are the
+// 8 rotations and flips for k in [0, 8).
+func dihedral(m [][]float32, k int) [][]float32 {
+	height, width := len(m), len(m[0])
+	res := m
+	if k&3 != 0 {
+		res = make([][]float32, height)
+		for y := range res {
+			sy := y
+			if k&2 != 0 {
+				sy = height - 1 - y
+			}
+			res[y] = make([]float32, width)
+			for x := range res[y] {
+				sx := x
+				if k&1 != 0 {
+					sx = width - 1 - x
+				}
+				res[y][x] = m[sy][sx]
+			}
+		}
+	}
+	if k&4 != 0 {
+		t := make([][]float32, width)
+		for x := range t {
+			t[x] = make([]float32, height)
+			for y := range t[x] {
+				t[x][y] = res[y][x]
+			}
+		}
+		res = t
+	}
+	return res
+}
+
+// undoDihedral turns the plane back from dihedral(m, k). Each step is its own
+// inverse, so they are undone in the reverse order.
+func undoDihedral(m [][]float32, k int) [][]float32 {
+	if k&4 != 0 {
+		m = dihedral(m, 4)
+	}
+	return dihedral(m, k&3)
+}
+
+// ttaTiles applies the network like tiles to the 8 rotations and flips of
+// the padded plane, and averages the results turned back. The padding of
+// the borders is symmetric, so the planes are those of the turned image.
+func (w *Waifu2x) ttaTiles(ctx context.Context, padded *mat.Matrix, width, height int) ([][]float32, error) {
+	var sum [][]float32
+	for k := 0; k < 8; k++ {
+		tw, th := width, height
+		if k&4 != 0 {
+			tw, th = height, width
+		}
+		res, err := w.tiles(ctx, mat.NewMatrix(dihedral(padded.M, k)), tw, th)
+		if err != nil {
+			return nil, err
+		}
+		res = undoDihedral(res, k)
+		if sum == nil {
+			sum = res
+			continue
+		}
+		for y, row := range sum {
+			for x, v := range res[y] {
+				row[x] += v
+			}
+		}
+	}
+	for _, row := range sum {
+		for x := range row {
+			row[x] /= 8
+		}
+	}
+	return sum, nil
+}
@@ -0,0 +1,80 @@
+package waifu2x
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDihedral(t *testing.T) {
+	m := [][]float32{{1, 2, 3}, {4, 5, 6}}
+	seen := map[string]bool{}
+	for k := 0; k < 8; k++ {
+		d := dihedral(m, k)
+		seen[fmt.Sprint(d)] = true
+		if got := undoDihedral(d, k); !reflect.DeepEqual(got, m) {
+			t.Errorf("%d: got %v back, want %v", k, got, m)
+		}
+	}
+	if len(seen) != 8 {
+		t.Errorf("got %d distinct transforms, want 8", len(seen))
+	}
+}
+
+// symmetry returns the largest difference of the image from its 8 rotations
+// and flips.
+func symmetry(img *image.RGBA) int {
+	n := img.Bounds().Dx()
+	max := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			v := int(img.RGBAAt(x, y).R)
+			for _, p := range []image.Point{{n - 1 - x, y}, {x, n - 1 - y}, {y, x}} {
+				d := v - int(img.RGBAAt(p.X, p.Y).R)
+				if d < 0 {
+					d = -d
+				}
+				if d > max {
+					max = d
+				}
+			}
+		}
+	}
+	return max
+}
+
+func TestExecTTA(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(13)), 1, 4, 4, 1)
+
+	// A gray image symmetric under the rotations and the flips.
+	const n = 12
+	src := image.NewRGBA(image.Rect(0, 0, n, n))
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			dx, dy := 2*x-n+1, 2*y-n+1
+			v := uint8((dx*dx + dy*dy) * 255 / (2 * (n - 1) * (n - 1)))
+			src.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	single := &Waifu2x{models: models, src: src}
+	if err := single.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	tta := &Waifu2x{models: models, src: src, TTA: true, TileSize: 10}
+	if err := tta.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if sameImage(single.Result(), tta.Result()) {
+		t.Error("the TTA output is the same as a single pass")
+	}
+	if d := symmetry(single.Result()); d <= 1 {
+		t.Fatalf("got asymmetry %d without TTA, want a model that breaks the symmetry", d)
+	}
+	if d := symmetry(tta.Result()); d > 1 {
+		t.Errorf("got asymmetry %d with TTA, want at most 1", d)
+	}
+}
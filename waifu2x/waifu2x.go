@@ -8,18 +8,28 @@ Reference: https://github.com/nagadomi/waifu2x, https://marcan.st/transf/waifu2x
 */
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/lon9/mat"
 	"github.com/nfnt/resize"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io/fs"
 	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Model of this program.
@@ -30,21 +40,315 @@ type Model struct {
 	KH           int             `json:"kH"`
 	Bias         []float32       `json:"bias"`
 	NInputPlane  int             `json:"nInputPlane"`
+
+	// Layout is the order of the axes of Weight. Empty means LayoutOIHW,
+	// which other layouts are transposed to on load.
+	Layout string `json:"layout,omitempty"`
 }
 
+// lowMemoryRows is the number of rows of a band in low memory mode.
+const lowMemoryRows = 64
+
+// PadMode is flag for padding mode at the image borders.
+type PadMode int
+
+const (
+	// Edge pads by repeating the border pixels.
+	Edge PadMode = iota
+	// Reflect101 pads by mirroring without repeating the border pixels
+	// (BORDER_REFLECT_101 of OpenCV).
+	Reflect101
+)
+
+// FitMode is flag for how the image is fitted to the target size.
+type FitMode int
+
+const (
+	// Stretch ignores the aspect ratio of the image.
+	Stretch FitMode = iota
+	// Letterbox keeps the aspect ratio and pads the image with transparent
+	// pixels to fill the target size.
+	Letterbox
+	// Crop keeps the aspect ratio and crops the center of the image to
+	// fill the target size.
+	Crop
+)
+
 // Waifu2x is structure of Waifu2x.
 type Waifu2x struct {
 	models []Model
 	src    image.Image
 	dst    *image.RGBA
+	hdrDst *FloatImage
+
+	// Interpolation is how the image is resized before the model is
+	// applied. AutoInterpolation picks it for each image.
+	Interpolation InterpolationMode
+
+	// Padding is padding mode applied at the image borders.
+	Padding PadMode
+
+	// TargetWidth and TargetHeight are the size of the output image. The
+	// model is applied as many times as needed to reach it and the result
+	// is resized to fit. When one of them is zero, it is computed from the
+	// aspect ratio of the input. When both are zero, the image is scaled
+	// by 2.
+	TargetWidth  int
+	TargetHeight int
+
+	// Fit is how the image is fitted when both TargetWidth and TargetHeight
+	// are set.
+	Fit FitMode
+
+	// Passes is the number of times the model is applied when no target
+	// size is set, so the image is scaled by 2^Passes. Zero means 1.
+	Passes int
+
+	// MaxPixels is the maximum number of pixels of an image given to the
+	// model. Zero means no limit.
+	MaxPixels int
+
+	// MaxOutputDim is the maximum width and height of the images the model
+	// outputs, which guards against extreme scales of narrow images that
+	// MaxPixels allows. Zero means no limit.
+	MaxOutputDim int
+
+	// Denoise applies the model to the image at its size instead of
+	// scaling it by 2, for denoising models. TargetWidth and TargetHeight
+	// are ignored.
+	Denoise bool
+
+	// TileSize splits the image into tiles of the size, so that the planes
+	// of each layer only hold a tile. Zero means the whole image.
+	TileSize int
+
+	// TileOverlap is the number of pixels of the neighbours each tile is
+	// given. Tiles are seamless when it is the receptive field of the model,
+	// and smaller values trade seams for less work. Zero means
+	// ReceptiveField, and larger values are clamped to it.
+	TileOverlap int
+
+	// TileWorkers is the number of tiles processed at the same time. Go
+	// doesn't allow pinning goroutines to CPUs, but bounding the tiles in
+	// flight keeps the working set of each worker small, which helps cache
+	// locality on NUMA machines. Zero means 1.
+	TileWorkers int
+
+	// Workers is the number of convolutions computed at the same time,
+	// across all the tiles. Zero means no bound, one goroutine for each
+	// output plane of a layer, or GOMAXPROCS with Half, so that only a few
+	// planes are expanded to float32. Unlike GOMAXPROCS, which bounds the
+	// OS threads running Go code, it bounds the work in flight and so the
+	// memory of the partial sums.
+	Workers int
+
+	// PreDenoise applies a 3x3 median filter to the luma of the input
+	// before reconstructing, for noisy images without a denoising model.
+	PreDenoise bool
+
+	// LowMemory processes the image in bands of rows, so that the planes of
+	// each layer only hold a band instead of the whole image.
+	LowMemory bool
+
+	// ColorManaged converts images tagged as Display P3 to a linear
+	// working space, so that the luma is computed from the P3 primaries
+	// instead of treating them as sRGB.
+	ColorManaged bool
+
+	// SRGBOutput converts the result from the ICC profile of the input to
+	// sRGB, clipping the colors out of its gamut, and embeds an sRGB profile
+	// in the saved image instead, e.g. for the web. RGB profiles of
+	// primaries and tone curves, like Adobe RGB, ProPhoto RGB and Display
+	// P3, are converted, and Exec returns ErrUnsupportedProfile for the
+	// others. Untagged images are taken as sRGB already.
+	SRGBOutput bool
+
+	// Linear gives the model the luma in linear light instead of gamma
+	// encoded, and encodes the result back with the sRGB transfer function.
+	Linear bool
+
+	// AutoLevels stretches the luma so that it spans the whole range
+	// before reconstruction, and restores the original range after, which
+	// helps images of low contrast. AutoLevelsPercentile is the percentage
+	// of the darkest and the brightest pixels clipped to find the range.
+	AutoLevels           bool
+	AutoLevelsPercentile float64
+
+	// Half stores the planes between the layers in float16, which halves
+	// the memory they take at a small cost of accuracy.
+	Half bool
+
+	// Accumulate64 sums the products of the convolutions of each layer in
+	// float64 and rounds them to float32 when the layer ends, which is
+	// more accurate for deep models and slower. With Half, the rounded sums
+	// are then stored in float16.
+	Accumulate64 bool
+
+	// JPEGNoSubsample saves JPEG images with the chroma at full resolution.
+	// image/jpeg always subsamples it to 4:2:0, which blurs the upscaled
+	// chroma again.
+	JPEGNoSubsample bool
+
+	// LumaOnly saves the luma of the result as a single channel grayscale
+	// PNG or JPEG image, which is smaller than a color image of gray
+	// pixels. The alpha and the ICC profile are dropped.
+	LumaOnly bool
+
+	// Dither is how the planes the model outputs are quantized to 8 bits.
+	// Dithering reduces the banding of smooth gradients.
+	Dither DitherMode
+
+	// Rounding is how the planes are quantized to 8 bits without Dither,
+	// to the nearest level by default.
+	Rounding RoundingMode
+
+	// Mask reconstructs only the pixels where the mask isn't black, and
+	// resizes the input for the rest, e.g. to enhance the subject of a
+	// photo. The mask is resized to the output. ExecRegion and the linear
+	// results of HDR images ignore it.
+	Mask image.Image
+
+	// SoftDeadline stops applying the model when it passes: the layer
+	// being computed is finished, and the tiles not done yet are only
+	// resized, so the result is approximate. Zero means no deadline.
+	SoftDeadline time.Time
+
+	// TTA applies the model to the 8 rotations and flips of the image and
+	// averages the results turned back, which improves the quality at 8
+	// times the work.
+	TTA bool
+
+	// AutoTrim processes only the image inside its uniform borders, of the
+	// color of the top left corner, and fills the borders of the output
+	// with the color, which saves the work on the margins of scans. It
+	// only applies to a single pass without a target size or Preview.
+	AutoTrim bool
+
+	// Palette saves PNG images quantized to the number of colors by
+	// Quantize as indexed images, which are small for pixel art. Zero
+	// saves true color images.
+	Palette int
+
+	// HDR processes OpenEXR images in linear floating point, keeping the
+	// values above 1. PreDenoise isn't applied to them, and HDRResult isn't
+	// fitted to the target size.
+	HDR bool
+
+	// AlphaThreshold snaps the alpha below it to 0, clearing the faint
+	// colors that the model spreads around soft edges. The alpha of the
+	// image is kept otherwise.
+	AlphaThreshold uint8
+
+	// DropAlpha saves the result opaque, with the colors of the
+	// translucent pixels unpremultiplied, for compositing tools that take
+	// the alpha from a separate mask saved from Alpha. The colors of fully
+	// transparent pixels are black.
+	DropAlpha bool
+
+	// Progress is called with the fraction of the work done, counted as
+	// the pixels of each convolution. It goes from 0 to 1 once for each
+	// Exec, across the tiles, the passes and the chroma planes, or for each
+	// ModelChain across its stages, which report to the Progress of the
+	// first stage. The calls are serialized. Nil reports nothing.
+	Progress func(fraction float64)
+
+	// ClipWarning prints a warning to stderr when more than the fraction
+	// of the values the model outputs are clipped to [0, 1], see
+	// Stats.Clipped. Zero means no warning.
+	ClipWarning float64
+
+	// GuidedChroma sharpens the chroma upscaled with nearest neighbor by a
+	// joint bilateral filter guided by the reconstructed luma, so the color
+	// edges line up with the luma. It applies to the scale models without a
+	// chroma model.
+	GuidedChroma bool
+
+	// Preview shrinks the image by the factor before applying the model,
+	// and resizes the result to the size of the full output, for a quick
+	// approximation of the settings. Zero or 1 processes the whole image.
+	// HDR images are always processed in full.
+	Preview int
+
+	// FileMode is the permission of the saved images, regardless of the
+	// umask. Zero means 0644.
+	FileMode os.FileMode
+
+	// Activation is applied to the output planes of each layer, and returns
+	// the input planes of the next one. Nil means LeakyReLU, which the
+	// waifu2x models are trained with. With Half, a custom activation keeps
+	// the planes of a layer in float32 until it is applied.
+	Activation func([]mat.Matrix) []mat.Matrix
+
+	// TempDir is the directory of the temporary files the outputs and the
+	// downloaded models are written to before they are renamed in place.
+	// Empty means the directory of the file written.
+	TempDir string
+
+	modelSHA256     string
+	cacheDir        string
+	downloadRetries int
+	downloadTimeout time.Duration
+	profile         []byte
+	colorSpace      colorSpace
+	preUpscaled     image.Image
+	passes          int
+	progress        *progress
+	clipped         int64
+	values          int64
+	stats           Stats
+
+	chromaModelPath string
+	chromaModels    []Model
+	trimmed         image.Rectangle
+	approximate     int32
+
+	// fsys is the file system of NewWaifu2xFS, nil for the OS.
+	fsys fs.FS
+}
+
+// Option configures how NewWaifu2x loads the model and the image.
+type Option func(*Waifu2x)
+
+// WithModelSHA256 makes NewWaifu2x verify the model against the hex encoded
+// SHA-256 checksum.
+func WithModelSHA256(sum string) Option {
+	return func(w *Waifu2x) {
+		w.modelSHA256 = strings.ToLower(sum)
+	}
+}
+
+// WithCacheDir sets the directory where models downloaded from a URL are
+// cached. The default is waifu2x-go in os.UserCacheDir.
+func WithCacheDir(dir string) Option {
+	return func(w *Waifu2x) {
+		w.cacheDir = dir
+	}
 }
 
-// NewWaifu2x is constructor of Waifu2x.
-func NewWaifu2x(modelPath, inputImgPath string) (*Waifu2x, error) {
-	var w Waifu2x
+// NewWaifu2x is constructor of Waifu2x. modelPath is either a file path or
+// a http(s) URL. When modelPath is empty, the embedded default scale model is
+// used. When inputImgPath is empty, no image is loaded and images
+// are given by ProcessBytes.
+func NewWaifu2x(modelPath, inputImgPath string, opts ...Option) (*Waifu2x, error) {
+	return newWaifu2x(nil, modelPath, inputImgPath, opts)
+}
+
+func newWaifu2x(fsys fs.FS, modelPath, inputImgPath string, opts []Option) (*Waifu2x, error) {
+	w := Waifu2x{downloadRetries: defaultDownloadRetries, fsys: fsys}
+	for _, opt := range opts {
+		opt(&w)
+	}
 	if err := w.loadModel(modelPath); err != nil {
 		return nil, err
 	}
+	if w.chromaModelPath != "" {
+		if err := w.loadChromaModel(w.chromaModelPath); err != nil {
+			return nil, err
+		}
+	}
+	if inputImgPath == "" {
+		return &w, nil
+	}
 	if err := w.getImage(inputImgPath); err != nil {
 		return nil, err
 	}
@@ -56,65 +360,305 @@ func (w *Waifu2x) loadModel(path string) error {
 
 	//Load model from json file.
 
-	f, err := ioutil.ReadFile(path)
+	var f []byte
+	var err error
+	switch {
+	case path == "":
+		f = defaultModel
+		err = w.verifyModel(f)
+	case isURL(path):
+		f, err = w.downloadModel(path)
+	default:
+		f, err = w.readFile(path)
+		if err == nil {
+			err = w.verifyModel(f)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(f, &w.models)
+	w.models, err = parseModel(f)
+	return err
+}
+
+func parseModel(b []byte) ([]Model, error) {
+	b, err := decompressModel(b)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, binaryModelMagic) {
+		return parseBinaryModel(b)
+	}
+	var models []Model
+	if err := json.Unmarshal(b, &models); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidModel, err)
+	}
+	for l := range models {
+		if err := models[l].canonicalLayout(); err != nil {
+			return nil, fmt.Errorf("layer %d: %w", l, err)
+		}
+	}
+	if err := validateModels(models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func validateModels(models []Model) error {
+
+	// Check the shapes of the layers so that Exec doesn't index out of
+	// range.
+
+	if len(models) == 0 {
+		return fmt.Errorf("%w: the model has no layers", ErrInvalidModel)
+	}
+	for l, m := range models {
+		if m.NInputPlane <= 0 || m.NOutputPlane <= 0 {
+			return fmt.Errorf("%w: layer %d has %d input and %d output planes", ErrInvalidModel, l, m.NInputPlane, m.NOutputPlane)
+		}
+		if l > 0 && m.NInputPlane != models[l-1].NOutputPlane {
+			return fmt.Errorf("%w: layer %d has %d input planes, but the previous layer outputs %d", ErrInvalidModel, l, m.NInputPlane, models[l-1].NOutputPlane)
+		}
+		if m.KW <= 0 || m.KH <= 0 || m.KW%2 == 0 || m.KH%2 == 0 {
+			return fmt.Errorf("%w: layer %d has %dx%d kernels, only odd sizes are supported", ErrInvalidModel, l, m.KW, m.KH)
+		}
+		if len(m.Bias) != m.NOutputPlane || len(m.Weight) != m.NOutputPlane {
+			return fmt.Errorf("%w: layer %d has %d biases and %d weights for %d output planes", ErrInvalidModel, l, len(m.Bias), len(m.Weight), m.NOutputPlane)
+		}
+		for _, wgt := range m.Weight {
+			if len(wgt) != m.NInputPlane {
+				return fmt.Errorf("%w: layer %d has %d kernels for %d input planes", ErrInvalidModel, l, len(wgt), m.NInputPlane)
+			}
+			for _, k := range wgt {
+				if len(k) != m.KH {
+					return fmt.Errorf("%w: layer %d has a kernel of %d rows", ErrInvalidModel, l, len(k))
+				}
+				for _, row := range k {
+					if len(row) != m.KW {
+						return fmt.Errorf("%w: layer %d has a kernel of %d columns", ErrInvalidModel, l, len(row))
+					}
+				}
+			}
+		}
+	}
+	return nil
 }
 
 func (w *Waifu2x) getImage(path string) error {
 
 	// Getting image from file name.
 
-	sf, err := os.Open(path)
+	img, profile, err := w.ReadImage(path)
 	if err != nil {
 		return err
 	}
+	w.SetImage(img)
+	w.SetProfile(profile)
+	return nil
+}
+
+func (w *Waifu2x) decodeImage(b []byte) (image.Image, error) {
 
-	defer sf.Close()
+	// Check the size in the header before decoding, so that a crafted
+	// header can't make the decoder allocate a huge image.
 
-	img, _, err := image.Decode(sf)
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	x := img.Bounds().Max.X
-	y := img.Bounds().Max.Y
-	w.src = resize.Resize(uint(x*2), uint(y*2), img, resize.NearestNeighbor)
-	return nil
+	if _, _, _, err := w.outputSize(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	return img, err
+}
+
+// ReadImage decodes the image file and returns it with its ICC profile, to
+// give to SetImage and SetProfile. Unlike LoadImage, it can be called while
+// another image is processed, e.g. to decode the next image of a batch.
+// ReadImage checks the size against MaxPixels and MaxOutputDim before
+// decoding the pixels, and reads the file system of NewWaifu2xFS if any.
+func (w *Waifu2x) ReadImage(path string) (image.Image, []byte, error) {
+	b, err := w.readFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := w.decodeImage(b)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, iccProfile(b), nil
+}
+
+// ReadImage is like (*Waifu2x).ReadImage without the limits, for an image
+// that isn't to be reconstructed, e.g. a reference.
+func ReadImage(path string) (image.Image, []byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, iccProfile(b), nil
+}
+
+// resizeImage resizes the images given to the model. Tests replace it to
+// return a wrong size.
+var resizeImage = resize.Resize
+
+func (w *Waifu2x) upscale(img image.Image, mode InterpolationMode) (image.Image, error) {
+
+	// Resize the image to twice the size as the input of the model. The
+	// planes are indexed by the size, so check it.
+
+	x := img.Bounds().Dx()
+	y := img.Bounds().Dy()
+	res := resizeImage(uint(x*2), uint(y*2), img, mode.function())
+	if size, want := res.Bounds().Size(), image.Pt(x*2, y*2); size != want {
+		return nil, fmt.Errorf("%w: resized to %v instead of %v", ErrSizeMismatch, size, want)
+	}
+	return res, nil
+}
+
+// LoadImage loads the image to be reconstructed from the file, of the file
+// system of NewWaifu2xFS if any. The size in the header is checked against
+// MaxPixels and MaxOutputDim before decoding the pixels.
+func (w *Waifu2x) LoadImage(path string) error {
+	return w.getImage(path)
+}
+
+// Image returns the image to be reconstructed.
+func (w *Waifu2x) Image() image.Image {
+	return w.src
+}
+
+// SetImage sets the image to be reconstructed. It clears the image given by
+// SetPreUpscaled.
+func (w *Waifu2x) SetImage(img image.Image) {
+	w.src = img
+	w.preUpscaled = nil
+}
+
+// SetPreUpscaled sets the image given to the model in the first pass instead
+// of the image of SetImage resized by 2 with nearest neighbor, e.g. an upscale
+// by another tool. It must be twice the size of the image of SetImage, which
+// is still used for the output size. Denoising models don't resize, so they
+// ignore it.
+func (w *Waifu2x) SetPreUpscaled(img image.Image) {
+	w.preUpscaled = img
+}
+
+// Result returns the reconstructed image. Exec must be called before.
+func (w *Waifu2x) Result() *image.RGBA {
+	return w.dst
 }
 
 // SaveImage saves image.
 func (w *Waifu2x) SaveImage(name string) error {
+	return w.save(name, w.dst, w.hdrDst)
+}
+
+func (w *Waifu2x) save(name string, dst *image.RGBA, hdr *FloatImage) error {
 
 	ext := filepath.Ext(name)
-	dstFile, err := os.Create(name)
-	if err != nil {
-		return err
+	if w.DropAlpha && dst != nil {
+		dst = opaqueImage(dst)
 	}
-	defer dstFile.Close()
+	var buf bytes.Buffer
 	switch ext {
+	case ".png", ".jpeg", ".jpg", ".exr", ".tif", ".tiff":
+	default:
+		enc := encoder(ext)
+		if enc == nil {
+			return fmt.Errorf("%w: %s", ErrUnsupportedFormat, ext)
+		}
+		if err := enc(&buf, dst); err != nil {
+			return err
+		}
+		return w.writeFile(name, buf.Bytes(), w.fileMode())
+	}
+	var err error
+	switch ext {
+	case ".exr":
+
+		// Save the linear result of HDR images, or the linear values of
+		// the others.
+		if hdr == nil {
+			hdr = floatImage(dst)
+		}
+		if err := EncodeEXR(&buf, hdr); err != nil {
+			return err
+		}
+		return w.writeFile(name, buf.Bytes(), w.fileMode())
+	case ".tif", ".tiff":
+		if err := EncodeTIFF(&buf, []TIFFPage{{Image: dst}}); err != nil {
+			return err
+		}
+		return w.writeFile(name, buf.Bytes(), w.fileMode())
 	case ".png":
-		err = png.Encode(dstFile, w.dst)
+		if w.LumaOnly {
+			err = png.Encode(&buf, w.lumaImage(dst))
+		} else if w.Palette > 0 {
+			err = png.Encode(&buf, Quantize(dst, w.Palette))
+		} else {
+			err = png.Encode(&buf, dst)
+		}
 	case ".jpeg", ".jpg":
-		err = jpeg.Encode(dstFile, w.dst, &jpeg.Options{jpeg.DefaultQuality})
+		if w.LumaOnly {
+			err = jpeg.Encode(&buf, w.lumaImage(dst), &jpeg.Options{Quality: jpeg.DefaultQuality})
+		} else if w.JPEGNoSubsample {
+			err = encodeJPEG444(&buf, dst, jpeg.DefaultQuality)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpeg.DefaultQuality})
+		}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if !w.LumaOnly {
+		// The profile of a color image doesn't apply to a grayscale one.
+		b = embedProfile(b, w.outputProfile())
+	}
+	return w.writeFile(name, b, w.fileMode())
 }
 
 func (w *Waifu2x) convertYCbCr(img image.Image) [][]color.YCbCr {
 
 	// Convert color model from RBGA to YCbCr.
 
-	colSize := img.Bounds().Max.X
-	rowSize := img.Bounds().Max.Y
+	bounds := img.Bounds()
+	colSize := bounds.Dx()
+	rowSize := bounds.Dy()
 	res := make([][]color.YCbCr, rowSize)
+
+	// Read the planes of YCbCr images, e.g. JPEG, as they are, instead of
+	// converting them to RGB and back, which rounds twice.
+	if ycc, ok := img.(*image.YCbCr); ok {
+		for y := 0; y < rowSize; y++ {
+			res[y] = make([]color.YCbCr, colSize)
+			for x := 0; x < colSize; x++ {
+				yi := ycc.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := ycc.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				res[y][x] = color.YCbCr{ycc.Y[yi], ycc.Cb[ci], ycc.Cr[ci]}
+			}
+		}
+		return res
+	}
 	for y := 0; y < rowSize; y++ {
 		res[y] = make([]color.YCbCr, colSize)
 		for x := 0; x < colSize; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			Y, Cb, Cr := color.RGBToYCbCr(uint8(r), uint8(g), uint8(b))
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			Y, Cb, Cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
 			res[y][x] = color.YCbCr{Y, Cb, Cr}
 		}
 	}
@@ -135,104 +679,973 @@ func (w *Waifu2x) extY(cl [][]color.YCbCr) [][]float32 {
 	return res
 }
 
+// ProcessBytes reconstructs the encoded image and returns the result
+// encoded as PNG.
+func (w *Waifu2x) ProcessBytes(b []byte) ([]byte, error) {
+
+	img, err := w.decodeImage(b)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedFormat, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.SetImage(img)
+	w.SetProfile(iccProfile(b))
+	if err := w.Exec(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, w.dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *Waifu2x) checkPlanes() error {
+
+	// The model is given the luma as a single plane and must output one,
+	// e.g. an RGB model of 3 planes would index out of range.
+
+	if len(w.models) == 0 {
+		return nil
+	}
+	if in := w.models[0].NInputPlane; in != 1 {
+		return fmt.Errorf("%w: the first layer takes %d input planes, but the model is given 1 plane of luma", ErrInvalidModel, in)
+	}
+	if out := w.models[len(w.models)-1].NOutputPlane; out != 1 {
+		return fmt.Errorf("%w: the last layer outputs %d planes, but 1 plane of luma is expected", ErrInvalidModel, out)
+	}
+	return nil
+}
+
+func (w *Waifu2x) outputSize(width, height int) (passes, cw, ch int, err error) {
+
+	// Count the passes and check the size before allocating anything.
+
+	if width <= 0 || height <= 0 {
+		return 0, 0, 0, fmt.Errorf("%w: %dx%d", ErrEmptyImage, width, height)
+	}
+	cw, ch = w.contentSize(width, height)
+	passes = 1
+	for x, y := width*2, height*2; x < cw || y < ch; x, y = x*2, y*2 {
+		passes++
+	}
+	pixels := float64(width) * float64(height)
+	if !w.Denoise {
+		pixels = math.Ldexp(pixels, 2*passes)
+	}
+	if w.MaxPixels > 0 && pixels > float64(w.MaxPixels) {
+		return 0, 0, 0, fmt.Errorf("%w: %.0f pixels exceeds %d", ErrImageTooLarge, pixels, w.MaxPixels)
+	}
+	if w.MaxOutputDim > 0 {
+		ow, oh := float64(width), float64(height)
+		if !w.Denoise {
+			ow, oh = math.Ldexp(ow, passes), math.Ldexp(oh, passes)
+		}
+		if ow > float64(w.MaxOutputDim) || oh > float64(w.MaxOutputDim) {
+			return 0, 0, 0, fmt.Errorf("%w: %.0fx%.0f output exceeds %d", ErrImageTooLarge, ow, oh, w.MaxOutputDim)
+		}
+	}
+	return passes, cw, ch, nil
+}
+
 // Exec execute reconstructing.
-func (w *Waifu2x) Exec() {
+func (w *Waifu2x) Exec() error {
+	return w.ExecContext(context.Background())
+}
+
+// ExecContext executes reconstructing like Exec, and stops with the error of
+// ctx when ctx is done. The tiles being processed are finished first.
+func (w *Waifu2x) ExecContext(ctx context.Context) error {
+	dst, err := w.timedExec(ctx, nil)
+	if err != nil {
+		return err
+	}
+	w.dst = dst
+	return nil
+}
+
+// timedExec executes reconstructing into, if not nil, an image of the bounds
+// of OutputBounds.
+func (w *Waifu2x) timedExec(ctx context.Context, into *image.RGBA) (*image.RGBA, error) {
+	start := time.Now()
+	profile, err := w.sourceProfile()
+	if err != nil {
+		return nil, err
+	}
+	dst, err := w.execTrimmed(ctx, into)
+	if err != nil {
+		return nil, err
+	}
+	if w.Mask != nil {
+		if dst, err = w.applyMask(dst); err != nil {
+			return nil, err
+		}
+	}
+	if profile != nil {
+		convertToSRGB(dst, profile)
+	}
+	w.stats = Stats{
+		InputWidth:   w.src.Bounds().Dx(),
+		InputHeight:  w.src.Bounds().Dy(),
+		OutputWidth:  dst.Bounds().Dx(),
+		OutputHeight: dst.Bounds().Dy(),
+		Passes:       w.passes,
+		Elapsed:      time.Since(start),
+		Clipped:      clipFraction(w.clipped, w.values),
+		Trimmed:      w.trimmed,
+		Approximate:  w.approximate != 0,
+	}
+	if w.stats.Approximate {
+		fmt.Fprintf(os.Stderr, "warning: the soft deadline passed, the tiles not finished were only resized\n")
+	}
+	if w.ClipWarning > 0 && w.stats.Clipped > w.ClipWarning {
+		fmt.Fprintf(os.Stderr, "warning: %.1f%% of the values the model output were clipped, the model may expect another normalization\n", 100*w.stats.Clipped)
+	}
+	return dst, nil
+}
+
+// ExecInto executes reconstructing and writes the result into dst instead of
+// allocating a new image. The bounds of dst must be equal to OutputBounds.
+func (w *Waifu2x) ExecInto(dst *image.RGBA) error {
+	bounds, err := w.OutputBounds()
+	if err != nil {
+		return err
+	}
+	if dst == nil || dst.Bounds() != bounds {
+		return fmt.Errorf("%w: want %v", ErrInvalidBounds, bounds)
+	}
+	res, err := w.timedExec(context.Background(), dst)
+	if err != nil {
+		return err
+	}
+	if res != dst {
+		draw.Draw(dst, bounds, res, image.Point{}, draw.Src)
+	}
+	w.dst = dst
+	return nil
+}
+
+// OutputBounds returns the bounds of the image Exec produces.
+func (w *Waifu2x) OutputBounds() (image.Rectangle, error) {
+	if w.src == nil {
+		return image.Rectangle{}, ErrEmptyImage
+	}
+	_, cw, ch, err := w.outputSize(w.src.Bounds().Dx(), w.src.Bounds().Dy())
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if !w.Denoise && w.TargetWidth != 0 && w.TargetHeight != 0 {
+		return image.Rect(0, 0, w.TargetWidth, w.TargetHeight), nil
+	}
+	return image.Rect(0, 0, cw, ch), nil
+}
+
+func (w *Waifu2x) exec(ctx context.Context, into *image.RGBA) (*image.RGBA, error) {
+	if w.src == nil {
+		return nil, ErrEmptyImage
+	}
+	if err := w.checkPlanes(); err != nil {
+		return nil, err
+	}
+	if (w.TileSize > 0 || w.LowMemory) && w.tileOverlap() < w.ReceptiveField() {
+		fmt.Fprintf(os.Stderr, "warning: a tile overlap of %d is less than the receptive field %d of the model, the tiles will have seams\n", w.tileOverlap(), w.ReceptiveField())
+	}
+	width, height := w.src.Bounds().Dx(), w.src.Bounds().Dy()
+	passes, cw, ch, err := w.outputSize(width, height)
+	if err != nil {
+		return nil, err
+	}
+	w.passes = passes
+	w.clipped, w.values = 0, 0
+	w.approximate = 0
+	_, float := w.src.(*FloatImage)
+	src, preview := w.src, w.previewing(width, height) && !(float && w.HDR)
+	if preview {
+		src = Downscale(w.src, w.Preview)
+	}
+	if w.progress == nil {
+		total, _, err := w.work(image.Pt(width, height), float && w.HDR)
+		if err != nil {
+			return nil, err
+		}
+		w.progress = newProgress(total, w.Progress)
+		defer func() { w.progress = nil }()
+	}
+
+	w.hdrDst = nil
+	if f, ok := w.src.(*FloatImage); ok && w.HDR {
+		dst, err := w.execHDR(ctx, f, passes)
+		if err != nil {
+			return nil, err
+		}
+		return w.fit(dst, cw, ch, into), nil
+	}
+
+	pre := w.preUpscaled
+	if preview {
+		pre = nil
+	}
+	if pre != nil && !w.Denoise && pre.Bounds().Size() != image.Pt(width*2, height*2) {
+		return nil, fmt.Errorf("%w: pre-upscaled %v for %v", ErrSizeMismatch, pre.Bounds().Size(), w.src.Bounds().Size())
+	}
+
+	// Apply the model until the image is large enough. The last pass is
+	// restored into into when it isn't resized or letterboxed.
+	last := into
+	if last != nil && last.Bounds().Size() != image.Pt(cw, ch) {
+		last = nil
+	}
+	var img image.Image = src
+	if w.PreDenoise {
+		img = w.preDenoise(img)
+	}
+
+	// The interpolation is detected from the image, not from the output of
+	// a pass, which the model has smoothed.
+	mode := w.interpolation(w.src)
+	var dst *image.RGBA
+	for i := 0; i < passes; i++ {
+		switch {
+		case w.Denoise:
+		case i == 0 && pre != nil:
+			img = pre
+		default:
+			if img, err = w.upscale(img, mode); err != nil {
+				return nil, err
+			}
+		}
+		var out *image.RGBA
+		if i == passes-1 {
+			out = last
+		}
+		if dst, err = w.reconstruct(ctx, img, out); err != nil {
+			return nil, err
+		}
+		img = dst
+	}
+	return w.fit(dst, cw, ch, into), nil
+}
+
+// previewing tells whether an image of the size is shrunk by Preview.
+func (w *Waifu2x) previewing(width, height int) bool {
+	return w.Preview > 1 && width >= w.Preview && height >= w.Preview
+}
+
+func (w *Waifu2x) contentSize(width, height int) (int, int) {
+
+	// Calculate the size of the image before it is fitted to the target
+	// size.
+
+	tw, th := w.TargetWidth, w.TargetHeight
+	switch {
+	case w.Denoise:
+		return width, height
+	case tw == 0 && th == 0:
+		passes := w.Passes
+		if passes < 1 {
+			passes = 1
+		}
+		return width << passes, height << passes
+	case th == 0:
+		return tw, int(math.Round(float64(height*tw) / float64(width)))
+	case tw == 0:
+		return int(math.Round(float64(width*th) / float64(height))), th
+	}
+
+	sx := float64(tw) / float64(width)
+	sy := float64(th) / float64(height)
+	switch w.Fit {
+	case Letterbox:
+		if sx < sy {
+			return tw, int(math.Round(float64(height) * sx))
+		}
+		return int(math.Round(float64(width) * sy)), th
+	case Crop:
+		if sx > sy {
+			return tw, int(math.Round(float64(height) * sx))
+		}
+		return int(math.Round(float64(width) * sy)), th
+	}
+	return tw, th
+}
+
+func (w *Waifu2x) fit(img *image.RGBA, cw, ch int, dst *image.RGBA) *image.RGBA {
+
+	// Resize the reconstructed image to the content size, then letterbox
+	// or crop it to the target size. The result is written into dst, of
+	// the bounds of OutputBounds, if not nil.
+
+	letterbox := !w.Denoise && w.TargetWidth != 0 && w.TargetHeight != 0 && (cw != w.TargetWidth || ch != w.TargetHeight)
+	if img.Bounds().Dx() != cw || img.Bounds().Dy() != ch {
+		resized := resize.Resize(uint(cw), uint(ch), img, resize.Lanczos3)
+		if dst != nil && !letterbox {
+			img = dst
+		} else {
+			img = image.NewRGBA(resized.Bounds())
+		}
+		draw.Draw(img, img.Bounds(), resized, image.Point{}, draw.Src)
+	}
+	if !letterbox {
+		if dst != nil && img != dst {
+			draw.Draw(dst, dst.Bounds(), img, image.Point{}, draw.Src)
+			return dst
+		}
+		return img
+	}
+
+	res := dst
+	if res == nil {
+		res = image.NewRGBA(image.Rect(0, 0, w.TargetWidth, w.TargetHeight))
+	} else {
+		draw.Draw(res, res.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	}
+	offset := image.Pt((w.TargetWidth-cw)/2, (w.TargetHeight-ch)/2)
+	draw.Draw(res, img.Bounds().Add(offset), img, image.Point{}, draw.Src)
+	return res
+}
+
+// reconstruct applies the models to src, restoring the result into into if
+// it has the bounds of the result.
+func (w *Waifu2x) reconstruct(ctx context.Context, src image.Image, into *image.RGBA) (*image.RGBA, error) {
 
 	// Get Y value.
-	c := w.convertYCbCr(w.src)
+	alpha, src := splitAlpha(src)
+	var dst *image.RGBA
+	if w.hasChromaModel() {
+		var err error
+		if dst, err = w.reconstructChroma(ctx, src, into); err != nil {
+			return nil, err
+		}
+	} else {
+		y, restore := w.luma(src)
+		out, err := w.reconstructLuma(ctx, y, w.Rounding)
+		if err != nil {
+			return nil, err
+		}
+		dst = restore(out, into)
+	}
+	if alpha != nil {
+		applyAlpha(dst, alpha, w.AlphaThreshold)
+	}
+	return dst, nil
+}
+
+func (w *Waifu2x) reconstructLuma(ctx context.Context, y [][]float32, rounding RoundingMode) (*mat.Matrix, error) {
 
-	width := w.src.Bounds().Max.X
-	height := w.src.Bounds().Max.Y
-	m := mat.NewMatrix(w.extY(c))
+	// Apply the models to the luma in [0, 255], rounded by rounding without
+	// Dither. Truncate leaves the values to be truncated when they are
+	// converted to 8 bits, or kept in floating point.
 
-	// Padding.
-	padded := m.Pad(uint(len(w.models)), mat.Edge)
+	height := len(y)
+	width := len(y[0])
+	if out := w.uniformLuma(y); out != nil {
+		return out, nil
+	}
+	m := mat.NewMatrix(y)
+	restoreGamma := func(out *mat.Matrix) *mat.Matrix { return out }
+	if w.Linear {
+		m, restoreGamma = linearLuma(m)
+	}
+	restoreLevels := func(out *mat.Matrix) *mat.Matrix { return out }
+	if w.AutoLevels {
+		m, restoreLevels = autoLevels(m, w.AutoLevelsPercentile)
+	}
+
+	// Padding. Convolutions don't pad, so borders depend only on this.
+	padding := w.ReceptiveField()
+	padded := pad(m, uint(padding), w.Padding)
 	padded = padded.BroadcastDiv(255.0)
 
-	// Prepare planes.
-	var planes = []mat.Matrix{*padded}
+	var res [][]float32
+	var err error
+	if w.TTA {
+		res, err = w.ttaTiles(ctx, padded, width, height)
+	} else {
+		res, err = w.tiles(ctx, padded, width, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Clipping
+	//fmt.Println(planes[0])
+	for _, row := range res {
+		for _, v := range row {
+			if v < 0 || v > 1 {
+				w.clipped++
+			}
+		}
+		w.values += int64(len(row))
+	}
+	out := mat.NewMatrix(res).Clip(0.0, 1.0)
+	out = restoreGamma(restoreLevels(out.BroadcastMul(255.0)))
+	if w.Dither != NoDither {
+		out = dither(out, w.Dither)
+	} else if rounding == RoundNearest {
+		out = roundPlane(out)
+	}
+	return out, nil
+}
 
-	// Show progressing.
-	progress := 0.0
-	count := 0.0
-	for _, v := range w.models {
-		count += float64(v.NInputPlane * v.NOutputPlane)
+// uniformLuma returns a copy of the luma when it is a single value, which the
+// model wouldn't improve, and counts the work skipped as done. It returns nil
+// otherwise.
+func (w *Waifu2x) uniformLuma(y [][]float32) *mat.Matrix {
+	v := y[0][0]
+	for _, row := range y {
+		for _, u := range row {
+			if u != v {
+				return nil
+			}
+		}
+	}
+	res := make([][]float32, len(y))
+	for i, row := range y {
+		res[i] = append([]float32(nil), row...)
+	}
+	work := convolutions(w.models) * int64(len(y)*len(y[0]))
+	if w.TTA {
+		work *= 8
 	}
+	w.progress.add(work)
+	w.values += int64(len(y) * len(y[0]))
+	return mat.NewMatrix(res)
+}
 
-	for _, m := range w.models {
-		fi := int(math.Min(float64(len(m.Bias)), float64(len(m.Weight))))
-		var oPlanes []mat.Matrix
-		for i := 0; i < fi; i++ {
-			var partial *mat.Matrix
-			b := m.Bias[i]
-			wgt := m.Weight[i]
-			fj := int(math.Min(float64(len(planes)), float64(len(wgt))))
-			resCh := make(chan *mat.Matrix, fj)
-			for j := 0; j < fj; j++ {
-				go func(plane *mat.Matrix, kernel *mat.Matrix, resCh chan *mat.Matrix) {
-					m, err := plane.Convolve2d(kernel, 1, 0, mat.Edge)
-					if err != nil {
-						panic(err)
-					}
-					resCh <- m
-				}(&planes[j], mat.NewMatrix(wgt[j]), resCh)
+func (w *Waifu2x) luma(src image.Image) ([][]float32, func(out *mat.Matrix, dst *image.RGBA) *image.RGBA) {
+
+	// Extract the luma and return the function to restore the image from
+	// the reconstructed luma, into dst if it has the bounds of out.
+
+	if w.ColorManaged && w.colorSpace == displayP3 {
+		return p3Luma(src)
+	}
+
+	c := w.convertYCbCr(src)
+	return w.extY(c), func(out *mat.Matrix, dst *image.RGBA) *image.RGBA {
+		for i := range out.M {
+			for j := range out.M[i] {
+				c[i][j].Y = uint8(out.M[i][j])
 			}
-			for k := 0; k < fj; k++ {
-				p := <-resCh
-				if partial == nil {
-					partial = p
-				} else {
-					var err error
-					partial, err = mat.Add(partial, p)
-					if err != nil {
-						panic(err)
+		}
+		if w.GuidedChroma && !w.Denoise {
+			cb, cr := make([][]float32, len(c)), make([][]float32, len(c))
+			for i := range c {
+				cb[i], cr[i] = make([]float32, len(c[i])), make([]float32, len(c[i]))
+				for j, v := range c[i] {
+					cb[i][j], cr[i][j] = float32(v.Cb), float32(v.Cr)
+				}
+			}
+			gcb, gcr := guidedChroma(mat.NewMatrix(cb), mat.NewMatrix(cr), out)
+			for i := range c {
+				for j := range c[i] {
+					c[i][j].Cb = uint8(clamp255(gcb.M[i][j] + 0.5))
+					c[i][j].Cr = uint8(clamp255(gcr.M[i][j] + 0.5))
+				}
+			}
+		}
+		return ycbcrImage(c, dst)
+	}
+}
+
+// ycbcrImage converts c into dst if it has the bounds of c, or into a new
+// image otherwise.
+func ycbcrImage(c [][]color.YCbCr, dst *image.RGBA) *image.RGBA {
+	height := len(c)
+	width := 0
+	if height > 0 {
+		width = len(c[0])
+	}
+	dst = outputImage(dst, width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dst.Set(x, y, c[y][x])
+		}
+	}
+	return dst
+}
+
+// outputImage returns dst if it has the bounds of an image of the size, or a
+// new image.
+func outputImage(dst *image.RGBA, width, height int) *image.RGBA {
+	if dst != nil && dst.Bounds() == image.Rect(0, 0, width, height) {
+		return dst
+	}
+	return image.NewRGBA(image.Rect(0, 0, width, height))
+}
+
+// tiles applies the network to the padded plane of the size in tiles.
+func (w *Waifu2x) tiles(ctx context.Context, padded *mat.Matrix, width, height int) ([][]float32, error) {
+
+	// The size of the tiles, or bands of rows in low memory mode.
+	tileWidth, tileHeight := width, height
+	if w.TileSize > 0 {
+		tileWidth, tileHeight = w.TileSize, w.TileSize
+	}
+	if w.LowMemory && lowMemoryRows < tileHeight {
+		tileHeight = lowMemoryRows
+	}
+
+	// A tile that fails to allocate is retried with smaller tiles, down to
+	// minTileSize.
+	start := w.progress.position()
+	res, err := w.processTiles(ctx, padded, width, height, tileWidth, tileHeight)
+	for errors.Is(err, ErrOutOfMemory) && (tileWidth > minTileSize || tileHeight > minTileSize) {
+		tileWidth, tileHeight = halveTile(tileWidth), halveTile(tileHeight)
+		fmt.Fprintf(os.Stderr, "\r%v, retrying with %dx%d tiles\n", err, tileWidth, tileHeight)
+		w.progress.rewind(start)
+		res, err = w.processTiles(ctx, padded, width, height, tileWidth, tileHeight)
+	}
+	return res, err
+}
+
+// minTileSize is the smallest tile size tried after allocation failures.
+const minTileSize = 32
+
+// allocTile is called before allocating each tile. Tests set it to simulate
+// allocation failures.
+var allocTile func(t image.Rectangle)
+
+// allocationPanic tells whether r, recovered from a panic, is the runtime
+// error of a slice too large to allocate.
+func allocationPanic(r interface{}) bool {
+	err, ok := r.(runtime.Error)
+	if !ok {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "runtime error: makeslice: ") || strings.HasPrefix(msg, "runtime error: growslice: ")
+}
+
+// tileOverlap returns TileOverlap clamped to the receptive field, which zero
+// means.
+func (w *Waifu2x) tileOverlap() int {
+	rf := w.ReceptiveField()
+	if w.TileOverlap <= 0 || w.TileOverlap > rf {
+		return rf
+	}
+	return w.TileOverlap
+}
+
+func halveTile(size int) int {
+	if size <= minTileSize {
+		return size
+	}
+	if size /= 2; size < minTileSize {
+		return minTileSize
+	}
+	return size
+}
+
+func (w *Waifu2x) processTiles(ctx context.Context, padded *mat.Matrix, width, height, tileWidth, tileHeight int) ([][]float32, error) {
+
+	// Split into tiles, or bands of rows in low memory mode. Each tile has
+	// the padding pixels of its neighbours, so the result is the same.
+	padding := w.ReceptiveField()
+	var tiles []image.Rectangle
+	for y := 0; y < height; y += tileHeight {
+		for x := 0; x < width; x += tileWidth {
+			tiles = append(tiles, image.Rect(x, y, x+tileWidth, y+tileHeight).Intersect(image.Rect(0, 0, width, height)))
+		}
+	}
+
+	// A smaller overlap gives each tile fewer pixels of its neighbours and
+	// pads the rest from the tile itself.
+	overlap := padding
+	if len(tiles) > 1 {
+		overlap = w.tileOverlap()
+	}
+	inset := padding - overlap
+
+	// Each worker takes the index of the next tile and stores its result at
+	// the index. The results are assembled in the order of the tiles after
+	// all the workers are done, so the order of completion doesn't matter.
+	workers := w.TileWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var sem chan struct{}
+	if n := w.Workers; n > 0 {
+		sem = make(chan struct{}, n)
+	} else if w.Half {
+		sem = make(chan struct{}, runtime.GOMAXPROCS(0))
+	}
+	outs := make([]*mat.Matrix, len(tiles))
+	tileCh := make(chan int, len(tiles))
+	for i := range tiles {
+		tileCh <- i
+	}
+	close(tileCh)
+	errCh := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+
+			// A failed allocation panics, e.g. with a tile too large for
+			// a slice. Report it so that smaller tiles can be tried, and
+			// panic again with anything else, which is a bug.
+			defer func() {
+				if r := recover(); r != nil {
+					if !allocationPanic(r) {
+						panic(r)
 					}
+					errCh <- fmt.Errorf("%w: %v", ErrOutOfMemory, r)
+				}
+			}()
+			for i := range tileCh {
+				t := tiles[i]
+				if err := ctx.Err(); err != nil {
+					errCh <- err
+					return
+				}
+				if allocTile != nil {
+					allocTile(t)
 				}
-				progress++
-				fmt.Fprintf(os.Stderr, "\r%.1f%%...", 100*progress/count)
+				rows := make([][]float32, t.Dy()+overlap*2)
+				for y := range rows {
+					rows[y] = padded.M[t.Min.Y+inset+y][t.Min.X+inset : t.Max.X+padding+overlap]
+				}
+				tile := mat.NewMatrix(rows)
+				if inset > 0 {
+					tile = pad(tile, uint(inset), Edge)
+				}
+				area := int64(t.Dx() * t.Dy())
+				var ticks int64
+				tick := func() {
+					atomic.AddInt64(&ticks, 1)
+					w.progress.add(area)
+				}
+				out, err := w.network(tile, tick, sem)
+				if errors.Is(err, errSoftDeadline) {
+					// Count the work left, so the progress still ends
+					// at 1.
+					w.progress.add(area * (convolutions(w.models) - atomic.LoadInt64(&ticks)))
+					out, err = w.skippedTile(padded, padding, t.Min.X, t.Min.Y, t.Dx(), t.Dy()), nil
+				}
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				// The tile is padded by the larger receptive field on
+				// both axes, so kernels of KW != KH leave more of the
+				// padding on the other axis.
+				if dx, dy := int(out.Cols)-t.Dx(), int(out.Rows)-t.Dy(); dx > 0 || dy > 0 {
+					rows := make([][]float32, t.Dy())
+					for y := range rows {
+						rows[y] = out.M[dy/2+y][dx/2 : dx/2+t.Dx()]
+					}
+					out = mat.NewMatrix(rows)
+				}
+				outs[i] = out
 			}
-			partial = partial.BroadcastAdd(b)
-			oPlanes = append(oPlanes, *partial)
+			errCh <- nil
+		}()
+	}
+	var err error
+	for i := 0; i < workers; i++ {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
 		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		// LeakyReLU
-		planes = make([]mat.Matrix, len(oPlanes))
-		for i, v := range oPlanes {
-			max := v.BroadcastFunc(maximum, float32(0.0))
-			min := v.BroadcastFunc(minimum, float32(0.0))
-			part := min.BroadcastMul(0.1)
-			max, err := mat.Add(max, part)
-			if err != nil {
-				panic(err)
+	res := make([][]float32, height)
+	for y := range res {
+		res[y] = make([]float32, width)
+	}
+	for i, t := range tiles {
+		for y := range outs[i].M {
+			copy(res[t.Min.Y+y][t.Min.X:], outs[i].M[y])
+		}
+	}
+	return res, nil
+}
+
+// trackConvolution is called with 1 when a convolution starts and -1 when it
+// ends. Tests set it to count the convolutions in flight.
+var trackConvolution func(delta int)
+
+// convolve convolves the plane with the kernel.
+func convolve(plane, kernel *mat.Matrix) (*mat.Matrix, error) {
+	if trackConvolution != nil {
+		trackConvolution(1)
+		defer trackConvolution(-1)
+	}
+	if kernel.Rows != kernel.Cols {
+		return correlate(plane, kernel)
+	}
+	return plane.Convolve2d(kernel, 1, 0, mat.Edge)
+}
+
+// correlate is the valid correlation of the plane with the kernel, like
+// Convolve2d without padding, for kernels of KW != KH, for which Convolve2d
+// takes the width of the kernel to be its height.
+func correlate(plane, kernel *mat.Matrix) (*mat.Matrix, error) {
+	rows, cols := int(plane.Rows)-int(kernel.Rows)+1, int(plane.Cols)-int(kernel.Cols)+1
+	if rows <= 0 || cols <= 0 {
+		return nil, fmt.Errorf("a %dx%d kernel doesn't fit a %dx%d plane", kernel.Cols, kernel.Rows, plane.Cols, plane.Rows)
+	}
+	res := make([][]float32, rows)
+	for y := range res {
+		res[y] = make([]float32, cols)
+		for ky, krow := range kernel.M {
+			for kx, k := range krow {
+				row := plane.M[y+ky][kx : kx+cols]
+				for x, v := range row {
+					res[y][x] += v * k
+				}
 			}
-			planes[i] = *max
 		}
 	}
-	fmt.Println()
+	return mat.NewMatrix(res), nil
+}
+
+// addTo adds src to dst in place.
+func addTo(dst, src *mat.Matrix) error {
+	if dst.Rows != src.Rows || dst.Cols != src.Cols {
+		return fmt.Errorf("adding a %dx%d plane to a %dx%d plane", src.Cols, src.Rows, dst.Cols, dst.Rows)
+	}
+	for y, row := range dst.M {
+		for x, v := range src.M[y] {
+			row[x] += v
+		}
+	}
+	return nil
+}
+
+func (w *Waifu2x) network(padded *mat.Matrix, tick func(), sem chan struct{}) (*mat.Matrix, error) {
+
+	// Apply the layers to the padded plane. The planes between the layers
+	// are kept in float32, or in float16 with Half.
+
+	var planes planeStore = floatPlanes{*padded}
+	if w.Half {
+		planes = halfPlanes{toHalf(padded)}
+	}
+	sum := convolveSum
+	if w.Accumulate64 {
+		sum = convolveSum64
+	}
+	for _, m := range w.models {
+		if w.pastDeadline() {
+			return nil, errSoftDeadline
+		}
+		var err error
+		if planes, err = w.applyLayer(m, planes, sum, tick, sem); err != nil {
+			return nil, err
+		}
+	}
 
 	// Assert
-	if len(planes) != 1 {
-		fmt.Println("error")
-		os.Exit(1)
+	if planes.len() != 1 {
+		return nil, fmt.Errorf("%w: %d output planes", ErrInvalidModel, planes.len())
 	}
+	return planes.plane(0), nil
+}
 
-	// Clipping
-	//fmt.Println(planes[0])
-	res := planes[0].Clip(0.0, 1.0)
-	res = res.BroadcastMul(255.0)
+// applyLayer applies the layer and the activation to the planes, returning
+// the output planes in a store of the same precision.
+func (w *Waifu2x) applyLayer(m Model, planes planeStore, sum layerSum, tick func(), sem chan struct{}) (planeStore, error) {
+
+	// LeakyReLU is applied to each output plane as it is done, so that it
+	// is stored right away. A custom activation is applied to the whole
+	// layer, so the layer is kept in float32 until then.
+
+	if w.Activation == nil {
+		out := planes.alloc(layerOutputs(m))
+		err := convolveLayer(m, planes, sum, tick, sem, func(i int, p *mat.Matrix) {
+			out.set(i, &LeakyReLU([]mat.Matrix{*p})[0])
+		})
+		return out, err
+	}
+	oPlanes := make(floatPlanes, layerOutputs(m))
+	if err := convolveLayer(m, planes, sum, tick, sem, oPlanes.set); err != nil {
+		return nil, err
+	}
+	activated := w.Activation(oPlanes)
+	out := planes.alloc(len(activated))
+	for i := range activated {
+		out.set(i, &activated[i])
+	}
+	return out, nil
+}
+
+// planeStore keeps the planes between the layers of network. The planes
+// are given to the convolutions in float32.
+type planeStore interface {
+	len() int
+	// plane returns the plane j in float32.
+	plane(j int) *mat.Matrix
+	// set stores p as the plane i. The planes of different indices can be
+	// set concurrently.
+	set(i int, p *mat.Matrix)
+	// alloc returns a store of n planes of the same precision.
+	alloc(n int) planeStore
+}
+
+// floatPlanes keeps the planes in float32.
+type floatPlanes []mat.Matrix
+
+func (p floatPlanes) len() int                 { return len(p) }
+func (p floatPlanes) plane(j int) *mat.Matrix  { return &p[j] }
+func (p floatPlanes) set(i int, m *mat.Matrix) { p[i] = *m }
+func (p floatPlanes) alloc(n int) planeStore   { return make(floatPlanes, n) }
+
+// ApplyLayer applies a layer of a model to the input planes, the convolutions
+// of each output plane summed with its bias and passed through LeakyReLU, for
+// inspecting the outputs of a layer in isolation. The planes are reduced by
+// the kernel size less one.
+func ApplyLayer(model Model, planes []mat.Matrix) ([]mat.Matrix, error) {
+	if len(planes) == 0 {
+		return nil, fmt.Errorf("%w: no input planes", ErrInvalidModel)
+	}
+	oPlanes := make(floatPlanes, layerOutputs(model))
+	if err := convolveLayer(model, floatPlanes(planes), convolveSum, func() {}, nil, oPlanes.set); err != nil {
+		return nil, err
+	}
+	return LeakyReLU(oPlanes), nil
+}
+
+// layerOutputs returns the number of output planes of the layer.
+func layerOutputs(m Model) int {
+	return int(math.Min(float64(len(m.Bias)), float64(len(m.Weight))))
+}
+
+// layerSum sums the convolutions of the input planes with the kernels of an
+// output plane and adds the bias, calling tick after each convolution.
+type layerSum func(planes planeStore, kernels [][][]float32, bias float32, tick func()) (*mat.Matrix, error)
+
+// convolveLayer computes the output planes of the layer before the
+// activation with sum and gives each to put.
+// The output planes are computed concurrently, each holding a slot of sem
+// unless it is nil and convolving the input planes one at a time, so that
+// sem bounds both the convolutions and the partial sums.
+func convolveLayer(m Model, planes planeStore, sum layerSum, tick func(), sem chan struct{}, put func(i int, p *mat.Matrix)) error {
+	fi := layerOutputs(m)
+	errCh := make(chan error, fi)
+	for i := 0; i < fi; i++ {
+		go func(i int) {
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			p, err := sum(planes, m.Weight[i], m.Bias[i], tick)
+			if err != nil {
+				errCh <- fmt.Errorf("%w: output plane %d: %w", ErrInvalidModel, i, err)
+				return
+			}
+			put(i, p)
+			errCh <- nil
+		}(i)
+	}
+	var err error
+	for i := 0; i < fi; i++ {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
 
-	for i := range res.M {
-		for j := range res.M[i] {
-			c[i][j].Y = uint8(res.M[i][j])
+// convolveSum is the layerSum in float32. The results are summed in the
+// order of the planes, so that the rounding doesn't depend on the
+// scheduling.
+func convolveSum(planes planeStore, kernels [][][]float32, bias float32, tick func()) (*mat.Matrix, error) {
+	fj := int(math.Min(float64(planes.len()), float64(len(kernels))))
+	if fj == 0 {
+		return nil, errors.New("no input planes")
+	}
+	var partial *mat.Matrix
+	for j := 0; j < fj; j++ {
+		p, err := convolve(planes.plane(j), mat.NewMatrix(kernels[j]))
+		if err != nil {
+			return nil, err
+		}
+		tick()
+
+		// The first result is summed into in place, instead of
+		// allocating a plane for each sum.
+		if partial == nil {
+			partial = p
+		} else if err := addTo(partial, p); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range partial.M {
+		for x := range row {
+			row[x] += bias
 		}
 	}
+	return partial, nil
+}
 
-	w.dst = image.NewRGBA(w.src.Bounds())
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			w.dst.Set(x, y, c[y][x])
+func pad(m *mat.Matrix, w uint, mode PadMode) *mat.Matrix {
+	if mode != Reflect101 {
+		return m.Pad(w, mat.Edge)
+	}
+	rows := int(m.Rows)
+	cols := int(m.Cols)
+	res := make([][]float32, rows+int(w)*2)
+	for y := range res {
+		res[y] = make([]float32, cols+int(w)*2)
+		sy := reflect101(y-int(w), rows)
+		for x := range res[y] {
+			res[y][x] = m.M[sy][reflect101(x-int(w), cols)]
+		}
+	}
+	return mat.NewMatrix(res)
+}
+
+func reflect101(i, n int) int {
+	if n == 1 {
+		return 0
+	}
+	period := 2*n - 2
+	i %= period
+	if i < 0 {
+		i += period
+	}
+	if i >= n {
+		i = period - i
+	}
+	return i
+}
+
+func (w *Waifu2x) preDenoise(src image.Image) image.Image {
+	c := w.convertYCbCr(src)
+	m := medianFilter(mat.NewMatrix(w.extY(c)))
+	dst := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	for y := range c {
+		for x := range c[y] {
+			c[y][x].Y = uint8(m.M[y][x])
+			dst.Set(x, y, c[y][x])
+		}
+	}
+	return dst
+}
+
+func medianFilter(m *mat.Matrix) *mat.Matrix {
+
+	// 3x3 median filter, replicating the edges.
+
+	padded := pad(m, 1, Edge)
+	res := make([][]float32, m.Rows)
+	window := make([]float32, 9)
+	for y := range res {
+		res[y] = make([]float32, m.Cols)
+		for x := range res[y] {
+			for i := 0; i < 9; i++ {
+				window[i] = padded.M[y+i/3][x+i%3]
+			}
+			sort.Slice(window, func(i, j int) bool { return window[i] < window[j] })
+			res[y][x] = window[4]
 		}
 	}
+	return mat.NewMatrix(res)
 }
 
 func maximum(a float32, i ...interface{}) float32 {
@@ -1,17 +1,950 @@
 package waifu2x
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/lon9/mat"
+	"github.com/nfnt/resize"
 )
 
 func TestWaifu2x(t *testing.T) {
-	w, err := NewWaifu2x("/export/space/takaha-r/waifu2x/models/anime_style_art/scale2.0x_model.json", "miku_small.png")
+	modelPath := "/export/space/takaha-r/waifu2x/models/anime_style_art/scale2.0x_model.json"
+	for _, p := range []string{modelPath, "miku_small.png"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Skip(err)
+		}
+	}
+	w, err := NewWaifu2x(modelPath, "miku_small.png")
 	if err != nil {
 		t.Log("Cant Initialize")
 		t.Fatal(err)
 	}
-	w.Exec()
+	if err = w.Exec(); err != nil {
+		t.Fatal(err)
+	}
 	if err = w.SaveImage("miku.png"); err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestPadReflect101(t *testing.T) {
+	m := mat.NewMatrix([][]float32{
+		{1, 2, 3},
+		{4, 5, 6},
+		{7, 8, 9},
+	})
+
+	// cv2.copyMakeBorder(m, 1, 1, 1, 1, cv2.BORDER_REFLECT_101)
+	expected := [][]float32{
+		{5, 4, 5, 6, 5},
+		{2, 1, 2, 3, 2},
+		{5, 4, 5, 6, 5},
+		{8, 7, 8, 9, 8},
+		{5, 4, 5, 6, 5},
+	}
+	if res := pad(m, 1, Reflect101); !res.Equals(mat.NewMatrix(expected)) {
+		t.Errorf("got %v, want %v", res.M, expected)
+	}
+}
+
+func TestExecPadding(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 3, 3))
+	for i, v := range []uint8{0, 90, 180, 90, 180, 255, 180, 255, 255} {
+		src.Pix[i] = v
+	}
+
+	// Cross kernel averaging the four neighbours.
+	cross := Model{
+		Weight: [][][][]float32{{{
+			{0, 0.25, 0},
+			{0.25, 0, 0.25},
+			{0, 0.25, 0},
+		}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{0},
+		NInputPlane:  1,
+	}
+
+	tests := []struct {
+		mode     PadMode
+		expected []uint8
+	}{
+		{Edge, []uint8{45, 112, 176}},
+		{Reflect101, []uint8{90, 135, 172}},
+	}
+	for _, tt := range tests {
+		w := &Waifu2x{models: []Model{cross}, Padding: tt.mode}
+		dst, err := w.reconstruct(context.Background(), src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, e := range tt.expected {
+			y := color.GrayModel.Convert(dst.At(x, 0)).(color.Gray).Y
+			if d := int(y) - int(e); d < -1 || d > 1 {
+				t.Errorf("mode %d: pixel (%d, 0) = %d, want %d", tt.mode, x, y, e)
+			}
+		}
+	}
+}
+
+func identityModel() Model {
+	return Model{
+		Weight: [][][][]float32{{{
+			{0, 0, 0},
+			{0, 1, 0},
+			{0, 0, 0},
+		}}},
+		NOutputPlane: 1,
+		KW:           3,
+		KH:           3,
+		Bias:         []float32{0},
+		NInputPlane:  1,
+	}
+}
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestExecTargetSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		fit           FitMode
+		expected      image.Point
+	}{
+		{"default", 0, 0, Stretch, image.Pt(20, 12)},
+		{"width", 50, 0, Stretch, image.Pt(50, 30)},
+		{"height", 0, 15, Stretch, image.Pt(25, 15)},
+		{"stretch", 40, 40, Stretch, image.Pt(40, 40)},
+		{"letterbox", 40, 40, Letterbox, image.Pt(40, 40)},
+		{"crop", 40, 40, Crop, image.Pt(40, 40)},
+	}
+	for _, tt := range tests {
+		w := &Waifu2x{
+			models:       []Model{identityModel()},
+			src:          testImage(10, 6),
+			TargetWidth:  tt.width,
+			TargetHeight: tt.height,
+			Fit:          tt.fit,
+		}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if size := w.dst.Bounds().Size(); size != tt.expected {
+			t.Errorf("%s: got size %v, want %v", tt.name, size, tt.expected)
+		}
+	}
+}
+
+func TestExecLetterbox(t *testing.T) {
+	w := &Waifu2x{
+		models:       []Model{identityModel()},
+		src:          testImage(10, 6),
+		TargetWidth:  40,
+		TargetHeight: 40,
+		Fit:          Letterbox,
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The content is 40x24, so 8 rows are padded above and below.
+	if _, _, _, a := w.dst.At(20, 7).RGBA(); a != 0 {
+		t.Errorf("expected transparent padding, got alpha %d", a)
+	}
+	if _, _, _, a := w.dst.At(20, 8).RGBA(); a == 0 {
+		t.Error("expected opaque content")
+	}
+}
+
+func FuzzLoadModel(f *testing.F) {
+	b, err := json.Marshal([]Model{identityModel()})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b)
+	var bin bytes.Buffer
+	if err := EncodeModel(&bin, []Model{identityModel()}, binary.BigEndian); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(bin.Bytes())
+	f.Add([]byte(`[]`))
+	f.Add([]byte(`[{}]`))
+	f.Add([]byte(`[{"weight":[],"bias":[],"nInputPlane":-1,"nOutputPlane":-1,"kW":3,"kH":3}]`))
+	f.Add([]byte(`[{"weight":[[[[1]]]],"bias":[0],"nInputPlane":1,"nOutputPlane":1,"kW":1,"kH":1}]`))
+
+	src := testImage(2, 2)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		models, err := parseModel(data)
+		if err != nil {
+			if models != nil {
+				t.Errorf("got models %v with error %v", models, err)
+			}
+			return
+		}
+
+		// A valid model must not panic in Exec. It can still fail, e.g.
+		// when it outputs more than one plane.
+		w := &Waifu2x{models: models, src: src}
+		w.Exec()
+	})
+}
+
+func encodePNG(t testing.TB, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessBytes(t *testing.T) {
+	w, err := NewWaifu2x(writeModel(t, []Model{identityModel()}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := w.ProcessBytes(encodePNG(t, testImage(5, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(10, 6) {
+		t.Errorf("got size %v, want (10,6)", size)
+	}
+}
+
+func TestProcessBytesHugeHeader(t *testing.T) {
+	b := encodePNG(t, testImage(3, 2))
+	copy(b[16:24], []byte{0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff})
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+
+	w := &Waifu2x{models: []Model{identityModel()}, MaxPixels: 1 << 12}
+	if _, err := w.ProcessBytes(b); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("got %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestLoadImageHugeHeader(t *testing.T) {
+
+	// The pixels are those of a 3x2 image, so decoding them would fail
+	// with another error.
+
+	b := encodePNG(t, testImage(3, 2))
+	copy(b[16:24], []byte{0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff})
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	path := filepath.Join(t.TempDir(), "huge.png")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []*Waifu2x{
+		{models: []Model{identityModel()}, MaxPixels: 1 << 12},
+		{models: []Model{identityModel()}, MaxOutputDim: 1 << 12},
+	} {
+		if err := w.LoadImage(path); !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("got %v, want ErrImageTooLarge", err)
+		}
+		if w.Image() != nil {
+			t.Error("the image is set")
+		}
+	}
+}
+
+func FuzzProcessBytes(f *testing.F) {
+	valid := encodePNG(f, testImage(3, 2))
+	f.Add(valid)
+	f.Add(encodePNG(f, image.NewGray(image.Rect(0, 0, 1, 1))))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(3, 2), nil); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte{})
+	f.Add([]byte("\x89PNG\r\n\x1a\n"))
+
+	// Header declaring a 65535x65535 image.
+	huge := append([]byte{}, valid...)
+	copy(huge[16:24], []byte{0, 0, 0xff, 0xff, 0, 0, 0xff, 0xff})
+	binary.BigEndian.PutUint32(huge[29:33], crc32.ChecksumIEEE(huge[12:29]))
+	f.Add(huge)
+	f.Add(testEXR(3, 2))
+	f.Add(overflowTIFF())
+
+	w := &Waifu2x{models: []Model{identityModel()}, MaxPixels: 1 << 12}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		b, err := w.ProcessBytes(data)
+		if err == nil && len(b) == 0 {
+			t.Error("got empty output without error")
+		}
+	})
+}
+
+func randomModel(rng *rand.Rand, planes ...int) []Model {
+	var models []Model
+	for l := 1; l < len(planes); l++ {
+		m := Model{NInputPlane: planes[l-1], NOutputPlane: planes[l], KW: 3, KH: 3}
+		for i := 0; i < m.NOutputPlane; i++ {
+			var wgt [][][]float32
+			for j := 0; j < m.NInputPlane; j++ {
+				k := make([][]float32, 3)
+				for y := range k {
+					k[y] = make([]float32, 3)
+					for x := range k[y] {
+						k[y][x] = float32(rng.NormFloat64()) / float32(3*m.NInputPlane)
+					}
+				}
+				k[1][1] += 1 / float32(m.NInputPlane)
+				wgt = append(wgt, k)
+			}
+			m.Weight = append(m.Weight, wgt)
+			m.Bias = append(m.Bias, float32(rng.NormFloat64()*0.01))
+		}
+		models = append(models, m)
+	}
+	return models
+}
+
+func TestExecLowMemory(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 4, 1)
+	src := testImage(40, 70)
+
+	standard := &Waifu2x{models: models, src: src}
+	if err := standard.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	low := &Waifu2x{models: models, src: src, LowMemory: true}
+	if err := low.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(standard.dst.Pix, low.dst.Pix) {
+		t.Error("low memory output differs from the standard output")
+	}
+}
+
+func TestExecTileFallback(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 1)
+	src := testImage(80, 50)
+	standard := &Waifu2x{models: models, src: src}
+	if err := standard.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tiles larger than 48x48 fail to allocate, so the 160x100 luma is
+	// retried with 80x50 and then 40x32 tiles.
+	var mu sync.Mutex
+	var sizes []image.Point
+	allocTile = func(r image.Rectangle) {
+		mu.Lock()
+		sizes = append(sizes, r.Size())
+		mu.Unlock()
+		if r.Dx() > 48 || r.Dy() > 48 {
+			_ = make([]float32, -r.Dx())
+		}
+	}
+	defer func() { allocTile = nil }()
+
+	w := &Waifu2x{models: models, src: src, TileWorkers: 2}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.dst.Pix, standard.dst.Pix) {
+		t.Error("output after falling back differs from the standard output")
+	}
+	if s := sizes[len(sizes)-1]; s.X > 40 || s.Y > 32 {
+		t.Errorf("got last tile %v, want at most (40,32)", s)
+	}
+
+	// Give up at minTileSize.
+	allocTile = func(r image.Rectangle) { _ = make([]float32, -r.Dx()) }
+	if err := w.Exec(); !errors.Is(err, ErrOutOfMemory) {
+		t.Errorf("got %v, want %v", err, ErrOutOfMemory)
+	}
+}
+
+func TestAllocationPanic(t *testing.T) {
+	n := -1
+	for _, c := range []struct {
+		f    func()
+		want bool
+	}{
+		{func() { _ = make([]float32, n) }, true},
+		{func() { _ = append(make([]struct{}, 1), make([]struct{}, math.MaxInt)...) }, true},
+		{func() { panic("runtime: out of memory") }, false},
+		{func() { _ = []int{}[n+1] }, false},
+		{func() { var m *mat.Matrix; _ = m.Rows }, false},
+	} {
+		got := func() (r interface{}) {
+			defer func() { r = recover() }()
+			c.f()
+			return nil
+		}()
+		if allocationPanic(got) != c.want {
+			t.Errorf("allocationPanic(%v) = %v, want %v", got, !c.want, c.want)
+		}
+	}
+}
+
+func TestExecWorkers(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 8, 8, 1)
+	src := testImage(30, 30)
+	standard := &Waifu2x{models: models, src: src}
+	if err := standard.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	var active, highWater int32
+	trackConvolution = func(delta int) {
+		n := atomic.AddInt32(&active, int32(delta))
+		for {
+			h := atomic.LoadInt32(&highWater)
+			if n <= h || atomic.CompareAndSwapInt32(&highWater, h, n) {
+				break
+			}
+		}
+	}
+	defer func() { trackConvolution = nil }()
+
+	for _, half := range []bool{false, true} {
+		atomic.StoreInt32(&highWater, 0)
+		w := &Waifu2x{models: models, src: src, TileSize: 16, TileWorkers: 4, Workers: 2, Half: half}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if h := atomic.LoadInt32(&highWater); h < 1 || h > 2 {
+			t.Errorf("half %v: got %d convolutions at the same time, want at most 2", half, h)
+		}
+		if !half && !bytes.Equal(w.dst.Pix, standard.dst.Pix) {
+			t.Error("output with 2 workers differs from the standard output")
+		}
+	}
+}
+
+func TestSetPreUpscaled(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(1)), 1, 4, 1)
+	src := testImage(8, 6)
+	pre := resize.Resize(16, 12, src, resize.Bicubic)
+
+	w := &Waifu2x{models: models}
+	w.SetImage(src)
+	w.SetPreUpscaled(pre)
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The model is given the bicubic image as is, like a denoising model.
+	expected := &Waifu2x{models: models, src: pre, Denoise: true}
+	if err := expected.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.dst.Bounds().Size(); s != image.Pt(16, 12) {
+		t.Errorf("got size %v, want (16,12)", s)
+	}
+	if !bytes.Equal(w.dst.Pix, expected.dst.Pix) {
+		t.Error("the output isn't from the pre-upscaled image")
+	}
+
+	w.SetPreUpscaled(resize.Resize(15, 12, src, resize.Bicubic))
+	if err := w.Exec(); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want %v", err, ErrSizeMismatch)
+	}
+	w.SetImage(src)
+	if err := w.Exec(); err != nil {
+		t.Errorf("got %v after SetImage cleared the pre-upscaled image", err)
+	}
+}
+
+func TestExecProgress(t *testing.T) {
+	var fractions []float64
+	w := &Waifu2x{
+		models:      randomModel(rand.New(rand.NewSource(1)), 1, 4, 1),
+		src:         testImage(20, 20),
+		TileSize:    16,
+		TileWorkers: 2,
+		Progress:    func(f float64) { fractions = append(fractions, f) },
+	}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 9 tiles of the 40x40 luma, of 4 + 4 convolutions each.
+	if len(fractions) != 72 {
+		t.Fatalf("got %d calls, want 72", len(fractions))
+	}
+	for i := 1; i < len(fractions); i++ {
+		if fractions[i] <= fractions[i-1] {
+			t.Errorf("fraction %v after %v", fractions[i], fractions[i-1])
+		}
+	}
+	if last := fractions[len(fractions)-1]; last != 1 {
+		t.Errorf("got last fraction %v, want 1", last)
+	}
+}
+
+func TestExecInto(t *testing.T) {
+	w := &Waifu2x{models: []Model{boxModel()}}
+	if err := w.ExecInto(image.NewRGBA(image.Rect(0, 0, 8, 8))); !errors.Is(err, ErrEmptyImage) {
+		t.Errorf("got %v, want ErrEmptyImage", err)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	pix := &dst.Pix[0]
+	for i, src := range []image.Image{testImage(6, 4), image.NewGray(image.Rect(0, 0, 6, 4))} {
+		w.SetImage(src)
+		if err := w.ExecInto(dst); err != nil {
+			t.Fatal(err)
+		}
+		if &dst.Pix[0] != pix || w.Result() != dst {
+			t.Errorf("run %d: dst is reallocated", i)
+		}
+
+		expected := &Waifu2x{models: []Model{boxModel()}, src: src}
+		if err := expected.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst.Pix, expected.dst.Pix) {
+			t.Errorf("run %d: ExecInto differs from Exec", i)
+		}
+	}
+
+	if err := w.ExecInto(image.NewRGBA(image.Rect(0, 0, 12, 9))); !errors.Is(err, ErrInvalidBounds) {
+		t.Errorf("got %v, want ErrInvalidBounds", err)
+	}
+
+	// The letterbox is cleared in the reused dst.
+	w.TargetWidth, w.TargetHeight, w.Fit = 16, 16, Letterbox
+	dst = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range dst.Pix {
+		dst.Pix[i] = 0xff
+	}
+	if err := w.ExecInto(dst); err != nil {
+		t.Fatal(err)
+	}
+	expected := &Waifu2x{models: []Model{boxModel()}, src: w.src, TargetWidth: 16, TargetHeight: 16, Fit: Letterbox}
+	if err := expected.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst.Pix, expected.dst.Pix) {
+		t.Error("letterboxed ExecInto differs from Exec")
+	}
+}
+
+func TestExecIntoAllocs(t *testing.T) {
+
+	// The result is restored into dst, so ExecInto allocates about an
+	// output image less than Exec, give or take the other allocations.
+
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(64, 64)}
+	dst := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	allocated := func(f func() error) uint64 {
+		min := uint64(math.MaxUint64)
+		for i := 0; i < 3; i++ {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			if err := f(); err != nil {
+				t.Fatal(err)
+			}
+			runtime.ReadMemStats(&after)
+			if n := after.TotalAlloc - before.TotalAlloc; n < min {
+				min = n
+			}
+		}
+		return min
+	}
+	exec := allocated(w.Exec)
+	into := allocated(func() error { return w.ExecInto(dst) })
+	if into+uint64(len(dst.Pix))/2 > exec {
+		t.Errorf("ExecInto allocated %d bytes and Exec %d, want about %d less", into, exec, len(dst.Pix))
+	}
+}
+
+func TestPreDenoise(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 12; i++ {
+		src.SetGray(rng.Intn(16), rng.Intn(16), color.Gray{uint8(255 * (i % 2))})
+	}
+
+	extremes := func(img image.Image) int {
+		n := 0
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if l := luma(img.At(x, y)); l < 16 || l > 240 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	plain := &Waifu2x{models: []Model{identityModel()}, src: src}
+	if err := plain.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	denoised := &Waifu2x{models: []Model{identityModel()}, src: src, PreDenoise: true}
+	if err := denoised.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if p, d := extremes(plain.dst), extremes(denoised.dst); d >= p || d != 0 {
+		t.Errorf("got %d extreme pixels with pre-denoise and %d without, want 0 with pre-denoise", d, p)
+	}
+}
+
+func TestExecTileWorkers(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(2)), 1, 4, 1)
+	src := testImage(23, 17)
+
+	whole := &Waifu2x{models: models, src: src}
+	if err := whole.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{1, 2} {
+		w := &Waifu2x{models: models, src: src, TileSize: 10, TileWorkers: workers}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(whole.dst.Pix, w.dst.Pix) {
+			t.Errorf("%d workers: tiled output differs from the whole image", workers)
+		}
+	}
+}
+
+func TestTileOverlap(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	models := randomModel(rng, 1, 4, 4, 1)
+
+	// Widen the middle layer to 5x5 kernels: 1 + 2 + 1 pixels.
+	for i, wgt := range models[1].Weight {
+		for j, k := range wgt {
+			wide := make([][]float32, 5)
+			for y := range wide {
+				wide[y] = make([]float32, 5)
+				for x := range wide[y] {
+					if y == 0 || y == 4 || x == 0 || x == 4 {
+						wide[y][x] = float32(rng.NormFloat64()) / 20
+					} else {
+						wide[y][x] = k[y-1][x-1]
+					}
+				}
+			}
+			models[1].Weight[i][j] = wide
+		}
+	}
+	models[1].KW, models[1].KH = 5, 5
+	w := &Waifu2x{models: models}
+	if rf := w.ReceptiveField(); rf != 4 {
+		t.Fatalf("got a receptive field of %d, want 4", rf)
+	}
+
+	src := testImage(23, 17)
+	whole := &Waifu2x{models: models, src: src}
+	if err := whole.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	for _, overlap := range []int{0, 4, 9} {
+		w := &Waifu2x{models: models, src: src, TileSize: 8, TileOverlap: overlap}
+		if err := w.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(whole.dst.Pix, w.dst.Pix) {
+			t.Errorf("overlap %d: tiled output differs from the whole image", overlap)
+		}
+	}
+
+	// A smaller overlap leaves seams.
+	w = &Waifu2x{models: models, src: src, TileSize: 8, TileOverlap: 1}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(whole.dst.Pix, w.dst.Pix) {
+		t.Error("overlap 1: tiled output is the same as the whole image, want seams")
+	}
+}
+
+func TestExecUniform(t *testing.T) {
+	var convolutions int32
+	trackConvolution = func(delta int) {
+		if delta > 0 {
+			atomic.AddInt32(&convolutions, 1)
+		}
+	}
+	defer func() { trackConvolution = nil }()
+
+	src := image.NewRGBA(image.Rect(0, 0, 7, 5))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{200, 80, 40, 255}), image.Point{}, draw.Src)
+	var fractions []float64
+	w := &Waifu2x{models: randomModel(rand.New(rand.NewSource(14)), 1, 4, 1), src: src, Progress: func(f float64) { fractions = append(fractions, f) }}
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&convolutions); n != 0 {
+		t.Errorf("got %d convolutions of a solid color, want 0", n)
+	}
+	want := image.NewRGBA(image.Rect(0, 0, 14, 10))
+	draw.Draw(want, want.Bounds(), image.NewUniform(color.RGBA{200, 80, 40, 255}), image.Point{}, draw.Src)
+
+	// The conversion to YCbCr and back rounds by 1.
+	if max, _, err := Compare(w.Result(), want); err != nil || max > 1 {
+		t.Errorf("got max diff %d (%v) from the resized input", max, err)
+	}
+	if len(fractions) == 0 || fractions[len(fractions)-1] != 1 {
+		t.Errorf("got progress %v, want it to end at 1", fractions)
+	}
+}
+
+func TestExecTilesReproducible(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(3)), 1, 8, 4, 1)
+	src := testImage(37, 29)
+	for _, half := range []bool{false, true} {
+		var results [][]byte
+		for run := 0; run < 2; run++ {
+			w := &Waifu2x{models: models, src: src, TileSize: 8, TileWorkers: 6, Workers: 3, Half: half}
+			if err := w.Exec(); err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, w.dst.Pix)
+		}
+		if !bytes.Equal(results[0], results[1]) {
+			t.Errorf("half %v: the outputs of two runs differ", half)
+		}
+	}
+}
+
+func TestExecContext(t *testing.T) {
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(8, 8), TileSize: 4}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.ExecContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if w.Result() != nil {
+		t.Error("got a result after canceling")
+	}
+	if err := w.ExecContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecSubImage(t *testing.T) {
+	full := testImage(12, 10)
+	sub := full.SubImage(image.Rect(3, 2, 10, 7))
+	crop := image.NewRGBA(image.Rect(0, 0, 7, 5))
+	draw.Draw(crop, crop.Bounds(), sub, sub.Bounds().Min, draw.Src)
+
+	models := randomModel(rand.New(rand.NewSource(8)), 1, 4, 1)
+	for _, denoise := range []bool{false, true} {
+		want := &Waifu2x{models: models, src: crop, Denoise: denoise, PreDenoise: true}
+		if err := want.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		got := &Waifu2x{models: models, src: sub, Denoise: denoise, PreDenoise: true}
+		if err := got.Exec(); err != nil {
+			t.Fatal(err)
+		}
+		if got.Result().Bounds() != want.Result().Bounds() || !bytes.Equal(got.Result().Pix, want.Result().Pix) {
+			t.Errorf("denoise %v: the sub image gives %v, differing from the cropped copy", denoise, got.Result().Bounds())
+		}
+	}
+}
+
+// referenceNetwork applies the layers like network, summing the planes with
+// mat.Add.
+func referenceNetwork(models []Model, padded *mat.Matrix) *mat.Matrix {
+	planes := []mat.Matrix{*padded}
+	for _, m := range models {
+		var oPlanes []mat.Matrix
+		for i := range m.Weight {
+			var partial *mat.Matrix
+			for j := range planes {
+				p, err := planes[j].Convolve2d(mat.NewMatrix(m.Weight[i][j]), 1, 0, mat.Edge)
+				if err != nil {
+					panic(err)
+				}
+				if partial != nil {
+					p, _ = mat.Add(partial, p)
+				}
+				partial = p
+			}
+			oPlanes = append(oPlanes, *partial.BroadcastAdd(m.Bias[i]))
+		}
+		planes = LeakyReLU(oPlanes)
+	}
+	return &planes[0]
+}
+
+func TestNetworkSameAsReference(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(7)), 1, 8, 8, 1)
+	w := &Waifu2x{models: models}
+	padded := pad(mat.NewMatrix(w.extY(w.convertYCbCr(testImage(24, 16)))), uint(len(models)), Edge).BroadcastDiv(255)
+	got, err := w.network(padded, func() {}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := referenceNetwork(models, padded)
+	for y := range want.M {
+		for x := range want.M[y] {
+			if got.M[y][x] != want.M[y][x] {
+				t.Fatalf("(%d, %d): got %v, want %v", x, y, got.M[y][x], want.M[y][x])
+			}
+		}
+	}
+}
+
+func BenchmarkNetwork(b *testing.B) {
+	models := randomModel(rand.New(rand.NewSource(8)), 1, 16, 16, 1)
+	w := &Waifu2x{models: models}
+	padded := pad(mat.NewMatrix(w.extY(w.convertYCbCr(testImage(64, 64)))), uint(len(models)), Edge).BroadcastDiv(255)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.network(padded, func() {}, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestExecPreview(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(9)), 1, 4, 1)
+	src := testImage(32, 24)
+	full := &Waifu2x{models: models, src: src}
+	if err := full.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	preview := &Waifu2x{models: models, src: src, Preview: 4}
+	if err := preview.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The preview is resized to the size of the full output.
+	if got, want := preview.Result().Bounds(), full.Result().Bounds(); got != want {
+		t.Errorf("got a preview of %v, want %v", got, want)
+	}
+	fullOps, _ := full.EstimateOps(32, 24)
+	previewOps, _ := preview.EstimateOps(32, 24)
+	if previewOps >= fullOps {
+		t.Errorf("got %d ops for the preview, want fewer than %d", previewOps, fullOps)
+	}
+	if _, mean, err := Compare(preview.Result(), full.Result()); err != nil || mean > 32 {
+		t.Errorf("got a mean difference of %v (%v) from the full output", mean, err)
+	}
+}
+
+func TestConvertYCbCrJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(17, 11), nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycc, ok := img.(*image.YCbCr)
+	if !ok {
+		t.Fatalf("got %T, want *image.YCbCr", img)
+	}
+
+	// The planes are read as they are, with the chroma subsampled 4:2:0.
+	w := &Waifu2x{}
+	c := w.convertYCbCr(ycc)
+	for y := range c {
+		for x, v := range c[y] {
+			want := color.YCbCr{ycc.Y[ycc.YOffset(x, y)], ycc.Cb[ycc.COffset(x, y)], ycc.Cr[ycc.COffset(x, y)]}
+			if v != want {
+				t.Fatalf("(%d, %d): got %v, want %v", x, y, v, want)
+			}
+		}
+	}
+
+	// A sub-image starts at its bounds.
+	sub := ycc.SubImage(image.Rect(3, 2, 9, 7)).(*image.YCbCr)
+	if got, want := w.convertYCbCr(sub)[0][0].Y, ycc.Y[ycc.YOffset(3, 2)]; got != want {
+		t.Errorf("got luma %d at the corner of a sub-image, want %d", got, want)
+	}
+}
+
+func TestExecResizeMismatch(t *testing.T) {
+	defer func(f func(uint, uint, image.Image, resize.InterpolationFunction) image.Image) { resizeImage = f }(resizeImage)
+
+	// A resize off by one column.
+	resizeImage = func(width, height uint, img image.Image, interp resize.InterpolationFunction) image.Image {
+		return resize.Resize(width-1, height, img, interp)
+	}
+	w := &Waifu2x{models: []Model{identityModel()}, src: testImage(5, 4)}
+	if err := w.Exec(); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("got %v, want %v", err, ErrSizeMismatch)
+	}
+}
+
+func TestApplyLayer(t *testing.T) {
+	m := Model{
+		Weight: [][][][]float32{{{
+			{1, 0},
+			{0, 0.5},
+		}}},
+		Bias:         []float32{-4},
+		KW:           2,
+		KH:           2,
+		NInputPlane:  1,
+		NOutputPlane: 1,
+	}
+	plane := mat.NewMatrix([][]float32{
+		{1, 2, 3},
+		{4, 5, 6},
+		{7, 8, 9},
+	})
+	got, err := ApplyLayer(m, []mat.Matrix{*plane})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e.g. 1*1 + 0.5*5 - 4 = -0.5, which LeakyReLU scales by 0.1.
+	want := [][]float32{
+		{-0.05, 1},
+		{4, 5.5},
+	}
+	if len(got) != 1 || got[0].Rows != 2 || got[0].Cols != 2 {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for y, row := range want {
+		for x, v := range row {
+			if d := got[0].M[y][x] - v; d < -1e-6 || d > 1e-6 {
+				t.Errorf("at (%d, %d): got %v, want %v", x, y, got[0].M[y][x], v)
+			}
+		}
+	}
+
+	if _, err := ApplyLayer(m, nil); !errors.Is(err, ErrInvalidModel) {
+		t.Errorf("no planes: got %v, want ErrInvalidModel", err)
+	}
+}
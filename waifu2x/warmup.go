@@ -0,0 +1,36 @@
+package waifu2x
+
+import (
+	"image"
+	"image/color"
+	"time"
+)
+
+// warmupSize is the width and the height of the image of Warmup.
+const warmupSize = 16
+
+// Warmup runs a tiny image through the models with the settings of w, so
+// that the first Exec of a server doesn't pay for growing the heap for the
+// planes, starting the goroutines and warming the caches of the CPU. The
+// models are loaded by NewWaifu2x already, so the first Exec is only a
+// little slower without it, mostly for small images. The image, the result
+// and the stats of w are left alone.
+func (w *Waifu2x) Warmup() error {
+	src := image.NewGray(image.Rect(0, 0, warmupSize, warmupSize))
+	for y := 0; y < warmupSize; y++ {
+		for x := 0; x < warmupSize; x++ {
+			src.SetGray(x, y, color.Gray{uint8(16 * ((x + y) % warmupSize))})
+		}
+	}
+
+	// Leave out what applies to the real image, and keep the warnings and
+	// the progress of the dummy one quiet.
+	sub := *w
+	sub.src, sub.preUpscaled, sub.profile = src, nil, nil
+	sub.TargetWidth, sub.TargetHeight, sub.Passes = 0, 0, 1
+	sub.Mask, sub.AutoTrim, sub.Preview = nil, false, 0
+	sub.TileSize, sub.LowMemory, sub.ClipWarning = 0, false, 0
+	sub.Progress, sub.progress = func(float64) {}, nil
+	sub.SoftDeadline = time.Time{}
+	return sub.Exec()
+}
@@ -0,0 +1,32 @@
+package waifu2x
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	models := randomModel(rand.New(rand.NewSource(13)), 1, 4, 1)
+	src := testImage(24, 20)
+	w := &Waifu2x{models: models, src: src, TargetWidth: 60}
+	if err := w.Warmup(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Result() != nil || w.Image() != src {
+		t.Error("Warmup changed the image or the result")
+	}
+
+	start := time.Now()
+	if err := w.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Exec after Warmup took %v", time.Since(start))
+	want := &Waifu2x{models: models, src: src, TargetWidth: 60}
+	if err := want.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if !sameImage(w.Result(), want.Result()) {
+		t.Error("the result after Warmup differs")
+	}
+}